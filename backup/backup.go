@@ -0,0 +1,205 @@
+// Package backup takes consistent snapshots of the SQLite database while the
+// server is using it, prunes old snapshots and restores them.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+)
+
+const (
+	prefix     = "fanks-"
+	ext        = ".db"
+	timeFormat = "20060102T150405Z"
+)
+
+// ErrUnsupported is returned when the database is not SQLite
+var ErrUnsupported = errors.New("backups are only supported for SQLite, use pg_dump for Postgres")
+
+// File is a snapshot in the backup directory
+type File struct {
+	Name string
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// Snapshot writes a consistent copy of the database to dest, which must not exist yet
+func Snapshot(ctx context.Context, db *gorm.DB, dest string) error {
+	if db.Dialector.Name() != "sqlite" {
+		return ErrUnsupported
+	}
+	err := db.WithContext(ctx).Exec("VACUUM INTO ?", dest).Error
+	return errors.Wrapf(err, "writing snapshot to %s", dest)
+}
+
+// Create writes a snapshot named after the current time to dir
+func Create(ctx context.Context, db *gorm.DB, dir string, now time.Time) (File, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return File{}, errors.Wrap(err, "creating backup directory")
+	}
+
+	name := prefix + now.UTC().Format(timeFormat) + ext
+	path := filepath.Join(dir, name)
+	if err := Snapshot(ctx, db, path); err != nil {
+		return File{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return File{}, errors.Wrap(err, "checking snapshot")
+	}
+	return File{Name: name, Path: path, Time: now.UTC().Truncate(time.Second), Size: info.Size()}, nil
+}
+
+// List returns the snapshots in dir, newest first
+func List(dir string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []File{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "listing backups")
+	}
+
+	ret := []File{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(timeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Wrapf(err, "checking %s", name)
+		}
+		ret = append(ret, File{Name: name, Path: filepath.Join(dir, name), Time: t, Size: info.Size()})
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Time.After(ret[j].Time) })
+	return ret, nil
+}
+
+// Prune keeps the newest snapshot of each of the last keepDaily days and of
+// each of the last keepWeekly weeks, and removes every other snapshot
+func Prune(dir string, keepDaily, keepWeekly int, loc *time.Location) ([]File, error) {
+	files, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := map[string]bool{}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for _, f := range files {
+		t := f.Time.In(loc)
+		day := t.Format("2006-01-02")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[f.Name] = true
+		}
+
+		year, week := t.ISOWeek()
+		isoWeek := fmt.Sprintf("%d-%d", year, week)
+		if !weeks[isoWeek] && len(weeks) < keepWeekly {
+			weeks[isoWeek] = true
+			keep[f.Name] = true
+		}
+	}
+
+	removed := []File{}
+	for _, f := range files {
+		if keep[f.Name] {
+			continue
+		}
+		if err := os.Remove(f.Path); err != nil {
+			return removed, errors.Wrapf(err, "removing %s", f.Name)
+		}
+		removed = append(removed, f)
+	}
+	return removed, nil
+}
+
+// Verify checks that path is an intact fanks database
+func Verify(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrapf(err, "finding %s", path)
+	}
+	// A URI keeps ? and # in the file name from being read as its query
+	dsn := (&url.URL{Scheme: "file", Path: abs, RawQuery: "mode=ro"}).String()
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return errors.Wrapf(err, "opening %s", path)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	var result string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return errors.Wrapf(err, "checking %s", path)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s is corrupt: %s", path, result)
+	}
+	if !db.Migrator().HasTable("users") || !db.Migrator().HasTable("notes") {
+		return fmt.Errorf("%s is not a fanks database", path)
+	}
+	return nil
+}
+
+// Restore replaces the database at dbPath with the snapshot at src. Nothing
+// may be using the database while it is restored.
+func Restore(src, dbPath string) error {
+	if err := Verify(src); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "opening snapshot")
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), filepath.Base(dbPath)+".restore-*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "copying snapshot")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "syncing snapshot")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "closing snapshot")
+	}
+
+	// Leftover journals belong to the old database and would corrupt the new one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrapf(err, "removing %s%s", dbPath, suffix)
+		}
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), dbPath), "replacing database")
+}
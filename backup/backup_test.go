@@ -0,0 +1,248 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	_ "github.com/ncruces/go-sqlite3/embed"
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+)
+
+// openDatabase opens the SQLite database at path, creating it if needed
+func openDatabase(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// createDatabase writes a database with the tables Verify looks for and a
+// single user named name
+func createDatabase(t *testing.T, path string, name string) {
+	t.Helper()
+	db := openDatabase(t, path)
+	for _, stmt := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE notes (id INTEGER PRIMARY KEY, user_id INTEGER, content TEXT)",
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Exec("INSERT INTO users (name) VALUES (?)", name).Error; err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+}
+
+func userName(t *testing.T, path string) string {
+	t.Helper()
+	var name string
+	if err := openDatabase(t, path).Raw("SELECT name FROM users").Scan(&name).Error; err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestPrune(t *testing.T) {
+	// 2026-06-15 is a Monday, the 14th is the Sunday ending the week before
+	snapshots := []string{
+		"fanks-20260615T030000Z.db",
+		"fanks-20260615T010000Z.db",
+		"fanks-20260614T030000Z.db",
+		"fanks-20260613T030000Z.db",
+		"fanks-20260608T030000Z.db",
+		"fanks-20260601T030000Z.db",
+		"fanks-20260525T030000Z.db",
+	}
+	// Files which are not snapshots are never removed
+	others := []string{"notes.txt", "fanks-latest.db"}
+
+	tests := []struct {
+		name       string
+		keepDaily  int
+		keepWeekly int
+		want       []string
+	}{
+		{
+			name:      "daily",
+			keepDaily: 2,
+			want:      []string{"fanks-20260615T030000Z.db", "fanks-20260614T030000Z.db"},
+		},
+		{
+			name:       "weekly",
+			keepWeekly: 3,
+			want:       []string{"fanks-20260615T030000Z.db", "fanks-20260614T030000Z.db", "fanks-20260601T030000Z.db"},
+		},
+		{
+			name:       "daily and weekly",
+			keepDaily:  3,
+			keepWeekly: 4,
+			want: []string{
+				"fanks-20260615T030000Z.db",
+				"fanks-20260614T030000Z.db",
+				"fanks-20260613T030000Z.db",
+				"fanks-20260601T030000Z.db",
+				"fanks-20260525T030000Z.db",
+			},
+		},
+		{
+			name:       "more than there are",
+			keepDaily:  30,
+			keepWeekly: 10,
+			want: []string{
+				"fanks-20260615T030000Z.db",
+				"fanks-20260614T030000Z.db",
+				"fanks-20260613T030000Z.db",
+				"fanks-20260608T030000Z.db",
+				"fanks-20260601T030000Z.db",
+				"fanks-20260525T030000Z.db",
+			},
+		},
+		{
+			name: "nothing",
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range append(slices.Clone(snapshots), others...) {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			removed, err := Prune(dir, tt.keepDaily, tt.keepWeekly, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(removed) != len(snapshots)-len(tt.want) {
+				t.Errorf("removed %d snapshots, want %d", len(removed), len(snapshots)-len(tt.want))
+			}
+
+			files, err := List(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := []string{}
+			for _, f := range files {
+				kept = append(kept, f.Name)
+			}
+			if !slices.Equal(kept, tt.want) {
+				t.Errorf("kept %v, want %v", kept, tt.want)
+			}
+			for _, name := range others {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("%s was removed: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	odd := filepath.Join(dir, "what?#1")
+	if err := os.Mkdir(odd, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	createDatabase(t, filepath.Join(dir, "fanks.db"), "Alice")
+	createDatabase(t, filepath.Join(odd, "fanks.db"), "Alice")
+	if err := openDatabase(t, filepath.Join(dir, "other.db")).Exec("CREATE TABLE things (id INTEGER)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "garbage.db"), []byte(strings.Repeat("not a database ", 512)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "fanks database", path: filepath.Join(dir, "fanks.db")},
+		{name: "name with ? and #", path: filepath.Join(odd, "fanks.db")},
+		{name: "other database", path: filepath.Join(dir, "other.db"), wantErr: "not a fanks database"},
+		{name: "not a database", path: filepath.Join(dir, "garbage.db"), wantErr: "garbage.db"},
+		{name: "missing", path: filepath.Join(dir, "missing.db"), wantErr: "missing.db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.path)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Verify returned %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Verify returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.db")); !os.IsNotExist(err) {
+		t.Errorf("verifying a missing database created it: %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "fanks.db")
+	createDatabase(t, dbPath, "Alice")
+
+	// Snapshot, then change the database after it
+	file, err := Create(t.Context(), openDatabase(t, dbPath), filepath.Join(dir, "backups"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := openDatabase(t, dbPath).Exec("UPDATE users SET name = 'Mallory'").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, []byte(strings.Repeat("not a database ", 512)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(garbage, dbPath); err == nil {
+		t.Error("restoring a file which is not a database succeeded")
+	}
+	if name := userName(t, dbPath); name != "Mallory" {
+		t.Errorf("a failed restore changed the database, user is %q", name)
+	}
+
+	// A journal left behind by the old database must not be applied to the restored one
+	if err := os.WriteFile(dbPath+"-wal", []byte("stale"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(file.Path, dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dbPath + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("the old journal is still there: %v", err)
+	}
+	if name := userName(t, dbPath); name != "Alice" {
+		t.Errorf("user is %q after restoring, want the snapshot's Alice", name)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".restore-") {
+			t.Errorf("restoring left %s behind", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Package blobstore stores binary objects, such as photos attached to notes,
+// outside of the database.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store saves blobs under slash separated keys like "attachments/1/abc.jpg"
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns ErrNotFound if there is no blob with the key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete does not return an error if the blob does not exist
+	Delete(ctx context.Context, key string) error
+}
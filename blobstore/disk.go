@@ -0,0 +1,83 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Disk stores blobs as files below a root directory
+type Disk struct {
+	root string
+}
+
+func NewDisk(root string) (*Disk, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, errors.Wrap(err, "creating blob directory")
+	}
+	return &Disk{root: root}, nil
+}
+
+func (d *Disk) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean != "/"+key || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(d.root, filepath.FromSlash(clean)), nil
+}
+
+func (d *Disk) Put(ctx context.Context, key string, r io.Reader) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return errors.Wrap(err, "creating blob directory")
+	}
+
+	// Write to a temporary file first so a failed write never leaves a partial blob behind
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "creating blob")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing blob")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "writing blob")
+	}
+	return errors.Wrap(os.Rename(f.Name(), p), "saving blob")
+}
+
+func (d *Disk) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, errors.Wrap(err, "opening blob")
+}
+
+func (d *Disk) Delete(ctx context.Context, key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return errors.Wrap(err, "deleting blob")
+}
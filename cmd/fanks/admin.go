@@ -0,0 +1,29 @@
+package main
+
+import (
+	errs "errors"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/backup"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+)
+
+// adminPushJobs is how many of the latest push jobs the admin page shows
+const adminPushJobs = 50
+
+func adminPage(cfg types.Config, jobs store.PushJobStore, subs store.SubscriptionStore) echo.HandlerFunc {
+	return withAdmin(func(c echo.Context, user types.User) error {
+		ctx := c.Request().Context()
+		page := types.AdminPageData{}
+		var backupErr, pushErr, countErr, keyErr error
+		page.Backups, backupErr = backup.List(cfg.Backup.Dir)
+		page.PushJobs, pushErr = jobs.List(ctx, adminPushJobs)
+		page.PushJobCounts, countErr = jobs.CountByStatus(ctx, 0)
+		if cfg.RotatingVapidKeys() {
+			page.DevicesOnPreviousKey, keyErr = subs.CountByVapidKey(ctx, cfg.VapidPreviousPublicKey)
+		}
+		return render(c, 200, views.AdminPage(cfg, user, page, errs.Join(backupErr, pushErr, countErr, keyErr)))
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestAdminPushJobs(t *testing.T) {
+	memory := store.NewMemory()
+	stores := memory.Stores()
+	cfg := types.Config{Backup: types.BackupConfig{Dir: t.TempDir()}}
+	admin := createTestUser(t, stores.Users, "admin@example.com")
+	admin.Role = "admin"
+	alice := createTestUser(t, stores.Users, "alice@example.com")
+
+	queued := time.Now()
+	sending := types.PushBatch{Kind: PushKindReminder, QueuedAt: &queued}
+	memory.AddPushBatch(&sending)
+	done := types.PushBatch{Kind: PushKindReminder, QueuedAt: &queued}
+	memory.AddPushBatch(&done)
+	queueing := types.PushBatch{Kind: PushKindReminder}
+	memory.AddPushBatch(&queueing)
+
+	pending := types.PushJob{UserID: alice.ID, BatchID: &sending.ID, Kind: PushKindReminder, Status: types.PushJobPending}
+	memory.AddPushJob(&pending)
+	dead := types.PushJob{UserID: alice.ID, BatchID: &sending.ID, Kind: PushKindReminder, Status: types.PushJobDead, Attempts: 8, LastError: "push service kept failing"}
+	memory.AddPushJob(&dead)
+	sent := types.PushJob{UserID: alice.ID, BatchID: &done.ID, Kind: PushKindReminder, Status: types.PushJobSent}
+	memory.AddPushJob(&sent)
+
+	t.Run("admin page", func(t *testing.T) {
+		rec := call(adminPage(cfg, stores.PushJobs, stores.Subscriptions), &admin, newRequest(http.MethodGet, "/admin", nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "push service kept failing") {
+			t.Errorf("admin page answered %d without the dead job", rec.Code)
+		}
+
+		rec = call(adminPage(cfg, stores.PushJobs, stores.Subscriptions), &alice, newRequest(http.MethodGet, "/admin", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("admin page for a user answered %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("batch status", func(t *testing.T) {
+		tests := []struct {
+			batch uint
+			want  string
+			jobs  int64
+		}{
+			{batch: sending.ID, want: "sending", jobs: 2},
+			{batch: done.ID, want: "done", jobs: 1},
+			{batch: queueing.ID, want: "queueing"},
+		}
+		for _, tt := range tests {
+			id := fmt.Sprint(tt.batch)
+			rec := call(pushBatchStatus(stores.PushJobs), &admin, newRequest(http.MethodGet, "/push/trigger/"+id, nil), "id", id)
+			var progress pushBatchProgress
+			if err := json.Unmarshal(rec.Body.Bytes(), &progress); err != nil {
+				t.Fatalf("batch %s answered %d: %s", id, rec.Code, rec.Body)
+			}
+			if progress.Status != tt.want || progress.Jobs != tt.jobs {
+				t.Errorf("batch %s is %s with %d jobs, want %s with %d", id, progress.Status, progress.Jobs, tt.want, tt.jobs)
+			}
+		}
+
+		rec := call(pushBatchStatus(stores.PushJobs), &admin, newRequest(http.MethodGet, "/push/trigger/9999", nil), "id", "9999")
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("unknown batch answered %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		queue := &pushQueue{wake: make(chan struct{}, 1)}
+		retry := retryPushJob(stores.PushJobs, queue)
+
+		id := fmt.Sprint(pending.ID)
+		rec := call(retry, &admin, newRequest(http.MethodPost, "/admin/push-jobs/"+id+"/retry", nil), "id", id)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("retrying a pending job answered %d, want 404", rec.Code)
+		}
+
+		id = fmt.Sprint(dead.ID)
+		rec = call(retry, &alice, newRequest(http.MethodPost, "/admin/push-jobs/"+id+"/retry", nil), "id", id)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("user retrying a job answered %d, want 401", rec.Code)
+		}
+		rec = call(retry, &admin, newRequest(http.MethodPost, "/admin/push-jobs/"+id+"/retry", nil), "id", id)
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("retrying a dead job answered %d: %s", rec.Code, rec.Body)
+		}
+		select {
+		case <-queue.wake:
+		default:
+			t.Error("retrying a job did not wake the push queue")
+		}
+
+		jobs, err := stores.PushJobs.List(t.Context(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, job := range jobs {
+			if job.ID == dead.ID && (job.Status != types.PushJobPending || job.Attempts != 0) {
+				t.Errorf("retried job is %s after %d attempts, want pending after 0", job.Status, job.Attempts)
+			}
+		}
+	})
+}
+
+func TestDownloadBackup(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			admin := createTestUser(t, stores.Users, "admin@example.com")
+			admin.Role = "admin"
+
+			rec := call(downloadBackup(types.Config{DBDriver: types.DBDriverPostgres}, stores.Snapshots), &admin, newRequest(http.MethodGet, "/admin/backup", nil))
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("backup of a database without snapshots answered %d, want 404", rec.Code)
+			}
+
+			rec = call(downloadBackup(types.Config{DBDriver: types.DBDriverSQLite}, stores.Snapshots), &admin, newRequest(http.MethodGet, "/admin/backup", nil))
+			switch name {
+			case "sqlite":
+				if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "SQLite format 3") {
+					t.Errorf("backup answered %d without an SQLite database", rec.Code)
+				}
+			case "memory":
+				if rec.Code != http.StatusInternalServerError {
+					t.Errorf("backup of the memory store answered %d, want 500", rec.Code)
+				}
+			}
+		})
+	}
+}
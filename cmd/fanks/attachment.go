@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/oliverisaac/fanks/blobstore"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const maxAttachmentsPerNote = 4
+
+// formFieldsHeadroom is how much of a note form may be fields other than its
+// photos
+const formFieldsHeadroom = 1 << 20
+
+// bodyLimit refuses request bodies larger than a note with all of its photos,
+// before they are parsed into memory or spooled to disk
+func bodyLimit(cfg types.Config) echo.MiddlewareFunc {
+	limit := maxAttachmentsPerNote*cfg.MaxUploadBytes + formFieldsHeadroom
+	return middleware.BodyLimit(fmt.Sprintf("%dB", limit))
+}
+
+// readUploads processes the photos uploaded with a form. The returned error
+// is meant to be shown to the user.
+func readUploads(c echo.Context, field string, maxBytes int64) ([]processedImage, error) {
+	ret := []processedImage{}
+
+	form, err := c.MultipartForm()
+	if errors.Is(err, http.ErrNotMultipart) {
+		return ret, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading upload")
+	}
+
+	files := form.File[field]
+	if len(files) > maxAttachmentsPerNote {
+		return nil, fmt.Errorf("you can attach at most %d photos to a note", maxAttachmentsPerNote)
+	}
+
+	for _, fh := range files {
+		if fh.Size == 0 {
+			continue
+		}
+		if fh.Size > maxBytes {
+			return nil, fmt.Errorf("%s is too big, photos can be at most %d MB", fh.Filename, maxBytes>>20)
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening %s", fh.Filename)
+		}
+		img, err := processImage(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, fh.Filename)
+		}
+		ret = append(ret, img)
+	}
+	return ret, nil
+}
+
+// newBlobName returns a random name for the blobs of an attachment, so their
+// keys cannot be guessed from one another
+func newBlobName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating blob name")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// putAttachments writes the images to the blob store and adds them to the
+// note, they are recorded when the note is saved. If it fails, any blobs which
+// were already written are removed.
+func putAttachments(ctx context.Context, blobs blobstore.Store, note *types.Note, images []processedImage) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			removeAttachmentBlobs(ctx, blobs, note.Attachments)
+		}
+	}()
+
+	for _, img := range images {
+		name, err := newBlobName()
+		if err != nil {
+			return err
+		}
+
+		attachment := types.Attachment{
+			UserID:      note.UserID,
+			ContentType: img.ContentType,
+			Key:         fmt.Sprintf("%d/%s.%s", note.UserID, name, img.Ext),
+			ThumbKey:    fmt.Sprintf("%d/%s-thumb.%s", note.UserID, name, img.Ext),
+			Width:       img.Width,
+			Height:      img.Height,
+		}
+		note.Attachments = append(note.Attachments, attachment)
+
+		if err := blobs.Put(ctx, attachment.Key, bytes.NewReader(img.Data)); err != nil {
+			return errors.Wrap(err, "saving photo")
+		}
+		if err := blobs.Put(ctx, attachment.ThumbKey, bytes.NewReader(img.Thumb)); err != nil {
+			return errors.Wrap(err, "saving thumbnail")
+		}
+	}
+	return nil
+}
+
+// removeAttachmentBlobs cleans up the blobs of attachments which could not be saved
+func removeAttachmentBlobs(ctx context.Context, blobs blobstore.Store, attachments []types.Attachment) {
+	for _, a := range attachments {
+		for _, key := range []string{a.Key, a.ThumbKey} {
+			if err := blobs.Delete(ctx, key); err != nil {
+				logrus.Error(errors.Wrapf(err, "cleaning up blob %s", key))
+			}
+		}
+	}
+}
+
+// deleteAttachments removes the attachments of a note from the database and the blob store
+func deleteAttachments(ctx context.Context, db *gorm.DB, blobs blobstore.Store, noteID uint) error {
+	var attachments []types.Attachment
+	if err := db.Where("note_id = ?", noteID).Find(&attachments).Error; err != nil {
+		return errors.Wrap(err, "finding attachments")
+	}
+
+	for _, a := range attachments {
+		if err := blobs.Delete(ctx, a.Key); err != nil {
+			return err
+		}
+		if err := blobs.Delete(ctx, a.ThumbKey); err != nil {
+			return err
+		}
+		if err := db.Unscoped().Delete(&a).Error; err != nil {
+			return errors.Wrap(err, "deleting attachment")
+		}
+	}
+	return nil
+}
+
+func serveAttachment(notes store.NoteStore, blobs blobstore.Store, thumb bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		id, err := parseUintParam(c, "id")
+		if err != nil {
+			return c.String(http.StatusNotFound, "attachment not found")
+		}
+		attachment, err := notes.GetAttachment(c.Request().Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "attachment not found")
+		} else if err != nil {
+			return err
+		}
+
+		// Only people who can see the note can see its photos
+		_, err = notes.GetForUser(c.Request().Context(), attachment.NoteID, user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "attachment not found")
+		} else if err != nil {
+			return err
+		}
+
+		key := attachment.Key
+		if thumb {
+			key = attachment.ThumbKey
+		}
+
+		r, err := blobs.Get(c.Request().Context(), key)
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return c.String(http.StatusNotFound, "attachment not found")
+		} else if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		c.Response().Header().Set(echo.HeaderCacheControl, "private, max-age=31536000, immutable")
+		c.Response().Header().Set(echo.HeaderContentType, attachment.ContentType)
+		c.Response().WriteHeader(http.StatusOK)
+		_, err = io.Copy(c.Response(), r)
+		return err
+	}
+}
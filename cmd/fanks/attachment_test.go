@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/blobstore"
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestServeAttachment(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			blobs, err := blobstore.NewDisk(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			alice := createTestUser(t, stores.Users, "alice@example.com")
+			bob := createTestUser(t, stores.Users, "bob@example.com")
+			carol := createTestUser(t, stores.Users, "carol@example.com")
+			family := createTestCircle(t, stores.Circles, "Family", alice, bob)
+
+			for key, content := range map[string]string{"photo": "full size", "photo-thumb": "thumbnail"} {
+				if err := blobs.Put(t.Context(), key, strings.NewReader(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			note := createTestNote(t, stores.Notes, types.Note{
+				UserID:      alice.ID,
+				Content:     "a sunny day",
+				Circles:     []types.Circle{family},
+				Attachments: []types.Attachment{{UserID: alice.ID, ContentType: "image/jpeg", Key: "photo", ThumbKey: "photo-thumb"}},
+			}, time.Now())
+			id := fmt.Sprint(note.Attachments[0].ID)
+
+			tests := []struct {
+				name  string
+				user  *types.User
+				id    string
+				thumb bool
+				want  int
+				body  string
+			}{
+				{name: "author", user: &alice, id: id, want: http.StatusOK, body: "full size"},
+				{name: "thumbnail", user: &alice, id: id, thumb: true, want: http.StatusOK, body: "thumbnail"},
+				{name: "circle member", user: &bob, id: id, want: http.StatusOK, body: "full size"},
+				{name: "outsider", user: &carol, id: id, want: http.StatusNotFound},
+				{name: "signed out", id: id, want: http.StatusUnauthorized},
+				{name: "unknown attachment", user: &alice, id: "9999", want: http.StatusNotFound},
+				{name: "invalid id", user: &alice, id: "photo", want: http.StatusNotFound},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					rec := call(serveAttachment(stores.Notes, blobs, tt.thumb), tt.user, newRequest(http.MethodGet, "/attachments/"+tt.id, nil), "id", tt.id)
+					if rec.Code != tt.want {
+						t.Fatalf("answered %d, want %d", rec.Code, tt.want)
+					}
+					if tt.body != "" && rec.Body.String() != tt.body {
+						t.Errorf("served %q, want %q", rec.Body, tt.body)
+					}
+					if tt.want == http.StatusOK && rec.Header().Get(echo.HeaderContentType) != "image/jpeg" {
+						t.Errorf("served content type %q", rec.Header().Get(echo.HeaderContentType))
+					}
+				})
+			}
+
+			// Deleted notes take their photos with them
+			if err := stores.Notes.Delete(t.Context(), note); err != nil {
+				t.Fatal(err)
+			}
+			rec := call(serveAttachment(stores.Notes, blobs, false), &bob, newRequest(http.MethodGet, "/attachments/"+id, nil), "id", id)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("attachment of a deleted note answered %d, want 404", rec.Code)
+			}
+		})
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	cfg := types.Config{MaxUploadBytes: 1 << 10}
+	e := echo.New()
+	e.Use(bodyLimit(cfg))
+	e.POST("/note", func(c echo.Context) error {
+		if _, err := io.ReadAll(c.Request().Body); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	limit := maxAttachmentsPerNote*cfg.MaxUploadBytes + formFieldsHeadroom
+
+	tests := []struct {
+		name    string
+		size    int64
+		chunked bool
+		want    int
+	}{
+		{name: "every photo at the limit", size: limit, want: http.StatusOK},
+		{name: "too large", size: limit + 1, want: http.StatusRequestEntityTooLarge},
+		{name: "too large without a length", size: limit + 1, chunked: true, want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/note", strings.NewReader(strings.Repeat("x", int(tt.size))))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("a %d byte body got %d, want %d", tt.size, rec.Code, tt.want)
+			}
+		})
+	}
+}
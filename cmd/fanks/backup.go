@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/backup"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// backupIfDue takes the daily backup unless one was already taken today and
+// prunes the backups which are no longer needed
+func backupIfDue(ctx context.Context, cfg types.Config, db *gorm.DB, now time.Time) error {
+	files, err := backup.List(cfg.Backup.Dir)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 && startOfDay(files[0].Time.In(now.Location())).Equal(startOfDay(now)) {
+		return nil
+	}
+
+	file, err := backup.Create(ctx, db, cfg.Backup.Dir, now)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Backed up the database to %s (%d bytes)", file.Path, file.Size)
+
+	removed, err := backup.Prune(cfg.Backup.Dir, cfg.Backup.KeepDaily, cfg.Backup.KeepWeekly, now.Location())
+	for _, f := range removed {
+		logrus.Infof("Removed old backup %s", f.Path)
+	}
+	return err
+}
+
+func startBackupWorker(workers *workerGroup, cfg types.Config, db *gorm.DB) {
+	if !cfg.Backup.Enabled() {
+		if cfg.SupportsSnapshots() {
+			logrus.Info("Scheduled backups are disabled")
+		} else {
+			logrus.Infof("Scheduled backups are not available for %s, back it up with its own tools", cfg.DBDriver)
+		}
+		return
+	}
+
+	workers.Every("backup", time.Hour, true, func(ctx context.Context) {
+		if err := backupIfDue(ctx, cfg, db, time.Now()); err != nil {
+			logrus.Error(errors.Wrap(err, "backing up database"))
+		}
+	})
+}
+
+// downloadBackup sends the admin a snapshot of the database taken just now
+func downloadBackup(cfg types.Config, snapshots store.Snapshotter) echo.HandlerFunc {
+	return withAdmin(func(c echo.Context, user types.User) error {
+		if !cfg.SupportsSnapshots() {
+			return c.String(http.StatusNotFound, backup.ErrUnsupported.Error())
+		}
+
+		dir, err := os.MkdirTemp("", "fanks-snapshot-")
+		if err != nil {
+			return errors.Wrap(err, "creating snapshot directory")
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "fanks.db")
+		if err := snapshots.Snapshot(c.Request().Context(), path); err != nil {
+			return err
+		}
+
+		logrus.Infof("User %s downloaded a database snapshot", user.Email)
+		name := fmt.Sprintf("fanks-%s.db", time.Now().UTC().Format("20060102T150405Z"))
+		return c.Attachment(path, name)
+	})
+}
+
+// withAdmin wraps a handler which only admins may use
+func withAdmin(fn func(c echo.Context, user types.User) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+		if user.Role != "admin" {
+			return c.String(http.StatusUnauthorized, "unauthorized, must be admin")
+		}
+		return fn(c, user)
+	}
+}
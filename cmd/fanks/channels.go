@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/notify"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// notifierFor returns the notifier which delivers to the channel with client
+func notifierFor(channel types.NotificationChannel, client *http.Client) (notify.Notifier, error) {
+	switch channel.Kind {
+	case types.ChannelNtfy:
+		return notify.Ntfy{TopicURL: channel.URL, Token: channel.Token, Client: client}, nil
+	case types.ChannelGotify:
+		return notify.Gotify{ServerURL: channel.URL, Token: channel.Token, Client: client}, nil
+	case types.ChannelMatrix:
+		return notify.Matrix{HomeserverURL: channel.URL, AccessToken: channel.Token, RoomID: channel.RoomID, Client: client}, nil
+	case types.ChannelWebhook:
+		return notify.Webhook{URL: channel.URL, Secret: channel.Token, Client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown notification channel kind %q", channel.Kind)
+}
+
+// channelMessage is the message of a job as a notifier sends it. Retries of
+// the job keep the same ID.
+func channelMessage(cfg types.Config, job *types.PushJob) notify.Message {
+	return notify.Message{
+		ID:    fmt.Sprintf("fanks-%d", job.ID),
+		Title: job.Message.Title,
+		Body:  job.Message.Body,
+		URL:   fmt.Sprintf("https://%s%s", cfg.Hostname, job.Message.URL),
+	}
+}
+
+// channelResult turns the error of a notifier into the result the push queue
+// records, so channels are retried like devices are
+func channelResult(err error, now time.Time) pushResult {
+	var statusErr *notify.StatusError
+	switch {
+	case err == nil:
+		return pushResult{StatusCode: http.StatusOK}
+	case errors.As(err, &statusErr):
+		return pushResult{
+			StatusCode: statusErr.StatusCode,
+			RetryAfter: parseRetryAfter(statusErr.RetryAfter, now),
+			Body:       statusErr.Body,
+		}
+	default:
+		return pushResult{Err: err}
+	}
+}
+
+// enqueueChannelPush queues a message for one of the user's notification channels
+func enqueueChannelPush(db *gorm.DB, batch types.PushBatch, channel types.NotificationChannel, msg types.PushMessage, at time.Time) error {
+	job := types.PushJob{
+		UserID:        channel.UserID,
+		BatchID:       &batch.ID,
+		ChannelID:     &channel.ID,
+		Kind:          batch.Kind,
+		Message:       msg,
+		Status:        types.PushJobPending,
+		NextAttemptAt: at,
+	}
+	return errors.Wrap(db.Omit(clause.Associations).Create(&job).Error, "queueing push")
+}
+
+// SendToChannel queues a message for a single notification channel
+func (q *pushQueue) SendToChannel(ctx context.Context, channel types.NotificationChannel, kind string, msg types.PushMessage) (types.PushBatch, error) {
+	now := time.Now()
+	batch := types.PushBatch{Kind: kind, UserID: channel.UserID, QueuedAt: &now}
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&batch).Error; err != nil {
+			return errors.Wrap(err, "saving push batch")
+		}
+		return enqueueChannelPush(tx, batch, channel, msg, now)
+	})
+	if err != nil {
+		return batch, err
+	}
+	q.Wake()
+	return batch, nil
+}
+
+// attemptChannel sends the job through the notifier of its channel
+func (q *pushQueue) attemptChannel(ctx context.Context, logrus *logrus.Entry, job *types.PushJob, now time.Time) error {
+	channel, err := q.channels.Get(ctx, *job.ChannelID)
+	if errors.Is(err, store.ErrNotFound) {
+		job.LastError = "channel was removed"
+		finishPushJob(job, types.PushJobGone, now)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	notifier, err := notifierFor(channel, q.channelClient)
+	if err != nil {
+		job.LastError = err.Error()
+		finishPushJob(job, types.PushJobDead, now)
+		return nil
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, q.cfg.Push.Timeout)
+	err = notifier.Notify(sendCtx, channelMessage(q.cfg, job))
+	cancel()
+	res := channelResult(err, now)
+	switch gone := recordPushAttempt(job, res, now); {
+	case gone:
+		// Unlike a subscription a channel is set up by hand, so it is left
+		// for the user to fix or remove
+		job.LastError = fmt.Sprintf("rejected with status %d: %s", res.StatusCode, res.Body)
+		finishPushJob(job, types.PushJobDead, now)
+	case errors.Is(err, notify.ErrPrivateAddress):
+		// Trying again will not make the address public
+		finishPushJob(job, types.PushJobDead, now)
+	}
+	pushSends.WithLabelValues(channel.Kind, pushOutcome(job)).Inc()
+	if job.Status == types.PushJobSent {
+		if err := q.channels.MarkSucceeded(ctx, channel, now); err != nil {
+			logrus.Error(err)
+		}
+	}
+	return nil
+}
+
+// parseChannel reads a new channel from the settings form
+func parseChannel(c echo.Context, userID uint) (types.NotificationChannel, error) {
+	channel := types.NotificationChannel{
+		UserID: userID,
+		Kind:   c.FormValue("kind"),
+		Label:  strings.TrimSpace(c.FormValue("label")),
+		URL:    strings.TrimSpace(c.FormValue("url")),
+		Token:  strings.TrimSpace(c.FormValue("token")),
+		RoomID: strings.TrimSpace(c.FormValue("roomID")),
+	}
+
+	if !slices.Contains(types.ChannelKinds, channel.Kind) {
+		return channel, fmt.Errorf("pick the kind of channel")
+	}
+	u, err := url.Parse(channel.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return channel, fmt.Errorf("the URL must start with https:// or http://")
+	}
+	// Hostnames are checked once they are resolved, when sending
+	if ip, err := netip.ParseAddr(strings.Trim(u.Hostname(), "[]")); (err == nil && !notify.PublicAddress(ip)) || u.Hostname() == "localhost" {
+		return channel, fmt.Errorf("the URL must point to a public address")
+	}
+	switch {
+	case channel.Kind == types.ChannelGotify && channel.Token == "":
+		return channel, fmt.Errorf("gotify needs an application token")
+	case channel.Kind == types.ChannelMatrix && channel.Token == "":
+		return channel, fmt.Errorf("matrix needs an access token")
+	case channel.Kind == types.ChannelMatrix && !strings.HasPrefix(channel.RoomID, "!"):
+		return channel, fmt.Errorf("matrix needs the room ID, which starts with !")
+	case channel.Kind == types.ChannelWebhook && channel.Token == "":
+		return channel, fmt.Errorf("webhooks need a secret to sign them with")
+	}
+
+	if channel.Label == "" {
+		channel.Label = fmt.Sprintf("%s at %s", channel.Kind, u.Host)
+	}
+	if len(channel.Label) > maxDeviceLabelLength {
+		return channel, fmt.Errorf("names can be at most %d characters", maxDeviceLabelLength)
+	}
+	return channel, nil
+}
+
+// addChannel saves a channel and shows the user's channels again
+func addChannel(users store.UserStore, channels store.ChannelStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		channel, err := parseChannel(c, user.ID)
+		if err != nil {
+			return render(c, 422, views.ChannelsSection(user.NotificationChannels, channel, err))
+		}
+		if err := channels.Create(c.Request().Context(), &channel); err != nil {
+			return err
+		}
+
+		user, err = users.Get(c.Request().Context(), user.ID)
+		if err != nil {
+			return err
+		}
+		return render(c, 200, views.ChannelsSection(user.NotificationChannels, types.NotificationChannel{}, nil))
+	}
+}
+
+// withChannel loads the session user's channel for the handler
+func withChannel(channels store.ChannelStore, fn func(c echo.Context, channel types.NotificationChannel) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			return c.String(http.StatusNotFound, "channel not found")
+		}
+		channel, err := channels.GetForUser(c.Request().Context(), uint(id), user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "channel not found")
+		} else if err != nil {
+			return err
+		}
+		return fn(c, channel)
+	}
+}
+
+// testChannel queues a notification for just this channel
+func testChannel(channels store.ChannelStore, queue *pushQueue) echo.HandlerFunc {
+	return withChannel(channels, func(c echo.Context, channel types.NotificationChannel) error {
+		msg := types.PushMessage{
+			Title: "Fanks",
+			Body:  fmt.Sprintf("Notifications work on %s", channel.Label),
+			URL:   "/settings",
+		}
+		if _, err := queue.SendToChannel(c.Request().Context(), channel, PushKindTest, msg); err != nil {
+			return err
+		}
+		return render(c, 200, views.ChannelRow(channel, "Test notification sent"))
+	})
+}
+
+func removeChannel(channels store.ChannelStore) echo.HandlerFunc {
+	return withChannel(channels, func(c echo.Context, channel types.NotificationChannel) error {
+		if err := channels.Delete(c.Request().Context(), channel); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+}
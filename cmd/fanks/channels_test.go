@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestParseChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    url.Values
+		wantErr string
+	}{
+		{name: "ntfy", form: url.Values{"kind": {types.ChannelNtfy}, "url": {"https://ntfy.sh/my-topic"}}},
+		{name: "webhook", form: url.Values{"kind": {types.ChannelWebhook}, "url": {"https://example.com/hook"}, "token": {"secret"}}},
+		{name: "unknown kind", form: url.Values{"kind": {"pager"}, "url": {"https://example.com"}}, wantErr: "pick the kind"},
+		{name: "not http", form: url.Values{"kind": {types.ChannelNtfy}, "url": {"file:///etc/passwd"}}, wantErr: "must start with"},
+		{name: "loopback", form: url.Values{"kind": {types.ChannelNtfy}, "url": {"http://127.0.0.1:8080/topic"}}, wantErr: "public address"},
+		{name: "localhost", form: url.Values{"kind": {types.ChannelNtfy}, "url": {"http://localhost/topic"}}, wantErr: "public address"},
+		{name: "cloud metadata", form: url.Values{"kind": {types.ChannelWebhook}, "url": {"http://169.254.169.254/latest/meta-data"}, "token": {"x"}}, wantErr: "public address"},
+		{name: "private network", form: url.Values{"kind": {types.ChannelGotify}, "url": {"http://192.168.1.10"}, "token": {"x"}}, wantErr: "public address"},
+		{name: "ipv6 loopback", form: url.Values{"kind": {types.ChannelNtfy}, "url": {"http://[::1]:80/topic"}}, wantErr: "public address"},
+		{name: "webhook without secret", form: url.Values{"kind": {types.ChannelWebhook}, "url": {"https://example.com/hook"}}, wantErr: "secret"},
+		{name: "matrix without room", form: url.Values{"kind": {types.ChannelMatrix}, "url": {"https://matrix.org"}, "token": {"x"}}, wantErr: "room ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := echo.New().NewContext(newRequest(http.MethodPost, "/settings/channels", tt.form), httptest.NewRecorder())
+			_, err := parseChannel(c, 1)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("parseChannel returned %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("parseChannel returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAttemptChannel(t *testing.T) {
+	q, db, stores := newTestPushQueue(t)
+	var received atomic.Int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer service.Close()
+	user := createTestUser(t, stores.Users, "alice@example.com")
+
+	channel := types.NotificationChannel{UserID: user.ID, Kind: types.ChannelNtfy, URL: service.URL + "/topic", Label: "ntfy"}
+	if err := stores.Channels.Create(t.Context(), &channel); err != nil {
+		t.Fatal(err)
+	}
+	attempt := func() types.PushJob {
+		t.Helper()
+		batch, err := q.SendToChannel(t.Context(), channel, PushKindTest, types.PushMessage{Title: "Fanks", Body: "Hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var job types.PushJob
+		if err := db.Where("batch_id = ?", batch.ID).First(&job).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := q.attempt(t.Context(), &job, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	// The service listens on loopback, which channels may not reach
+	job := attempt()
+	if job.Status != types.PushJobDead || job.Attempts != 1 || !strings.Contains(job.LastError, "public addresses") {
+		t.Errorf("job for a loopback channel is %s after %d attempts: %s", job.Status, job.Attempts, job.LastError)
+	}
+	if n := received.Load(); n != 0 {
+		t.Errorf("loopback service received %d notifications", n)
+	}
+
+	// A client which may reach it delivers
+	q.channelClient = service.Client()
+	job = attempt()
+	if job.Status != types.PushJobSent {
+		t.Errorf("job is %s: %s", job.Status, job.LastError)
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("service received %d notifications, want 1", n)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const circleInviteTTL = 7 * 24 * time.Hour
+
+func parseUintParam(c echo.Context, name string) (uint, error) {
+	v, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing param %s", name)
+	}
+	return uint(v), nil
+}
+
+func parseCircleIDs(values []string) []uint {
+	ret := []uint{}
+	for _, v := range values {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || id == 0 {
+			continue
+		}
+		ret = append(ret, uint(id))
+	}
+	return ret
+}
+
+func newInviteToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating invite token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func circlesPage(cfg types.Config, users store.UserStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		circles, err := users.Circles(c.Request().Context(), user.ID)
+		return render(c, 200, views.CirclesPage(cfg, user, circles, err))
+	}
+}
+
+func createCircle(cfg types.Config, circles store.CircleStore, users store.UserStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		name := strings.TrimSpace(c.FormValue("name"))
+		if name == "" {
+			circles, err := users.Circles(c.Request().Context(), user.ID)
+			if err == nil {
+				err = fmt.Errorf("Your circle needs a name")
+			}
+			return render(c, 422, views.CirclesPage(cfg, user, circles, err))
+		}
+
+		circle := types.Circle{
+			Name: name,
+			Members: []types.CircleMember{
+				{UserID: user.ID, Role: types.CircleRoleOwner},
+			},
+		}
+		if err := circles.Create(c.Request().Context(), &circle); err != nil {
+			return err
+		}
+
+		logrus.Infof("User %s created circle %q", user.Email, circle.Name)
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/circles/%d", circle.ID))
+	}
+}
+
+func circlePage(cfg types.Config, circles store.CircleStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		circleID, err := parseUintParam(c, "id")
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid circle")
+		}
+
+		circle, member, err := circles.GetForUser(c.Request().Context(), circleID, user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "circle not found")
+		} else if err != nil {
+			return err
+		}
+
+		return render(c, 200, views.CirclePage(cfg, user, circle, member, nil))
+	}
+}
+
+// withCircle wraps a handler which requires the session user to be a member of the circle
+func withCircle(cfg types.Config, circles store.CircleStore, fn func(c echo.Context, user types.User, circle types.Circle, member types.CircleMember) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		circleID, err := parseUintParam(c, "id")
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid circle")
+		}
+
+		circle, member, err := circles.GetForUser(c.Request().Context(), circleID, user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "circle not found")
+		} else if err != nil {
+			return err
+		}
+
+		return fn(c, user, circle, member)
+	}
+}
+
+func renderCircle(c echo.Context, cfg types.Config, circles store.CircleStore, user types.User, circleID uint, actionErr error) error {
+	circle, member, err := circles.GetForUser(c.Request().Context(), circleID, user.ID)
+	if err != nil {
+		return err
+	}
+	status := 200
+	if actionErr != nil {
+		status = 422
+	}
+	return render(c, status, views.CircleDetail(cfg, user, circle, member, actionErr))
+}
+
+func renameCircle(cfg types.Config, circles store.CircleStore) echo.HandlerFunc {
+	return withCircle(cfg, circles, func(c echo.Context, user types.User, circle types.Circle, member types.CircleMember) error {
+		if !member.CanManage() {
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("Only owners and admins can rename a circle"))
+		}
+
+		name := strings.TrimSpace(c.FormValue("name"))
+		if name == "" {
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("Your circle needs a name"))
+		}
+
+		if err := circles.Rename(c.Request().Context(), circle, name); err != nil {
+			return err
+		}
+		return renderCircle(c, cfg, circles, user, circle.ID, nil)
+	})
+}
+
+func deleteCircle(cfg types.Config, circles store.CircleStore) echo.HandlerFunc {
+	return withCircle(cfg, circles, func(c echo.Context, user types.User, circle types.Circle, member types.CircleMember) error {
+		if !member.IsOwner() {
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("Only the owner can delete a circle"))
+		}
+
+		if err := circles.Delete(c.Request().Context(), circle); err != nil {
+			return err
+		}
+
+		c.Response().Header().Set("HX-Redirect", "/circles")
+		return c.NoContent(200)
+	})
+}
+
+func createCircleInvite(cfg types.Config, circles store.CircleStore) echo.HandlerFunc {
+	return withCircle(cfg, circles, func(c echo.Context, user types.User, circle types.Circle, member types.CircleMember) error {
+		if !member.CanManage() {
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("Only owners and admins can invite people"))
+		}
+
+		token, err := newInviteToken()
+		if err != nil {
+			return err
+		}
+
+		invite := types.CircleInvite{
+			CircleID:    circle.ID,
+			Token:       token,
+			InvitedByID: user.ID,
+			ExpiresAt:   time.Now().Add(circleInviteTTL),
+		}
+		if err := circles.CreateInvite(c.Request().Context(), &invite); err != nil {
+			return err
+		}
+
+		return renderCircle(c, cfg, circles, user, circle.ID, nil)
+	})
+}
+
+func deleteCircleInvite(cfg types.Config, circles store.CircleStore) echo.HandlerFunc {
+	return withCircle(cfg, circles, func(c echo.Context, user types.User, circle types.Circle, member types.CircleMember) error {
+		if !member.CanManage() {
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("Only owners and admins can revoke invites"))
+		}
+
+		inviteID, err := parseUintParam(c, "inviteID")
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid invite")
+		}
+
+		if err := circles.DeleteInvite(c.Request().Context(), circle.ID, inviteID); err != nil {
+			return err
+		}
+
+		return renderCircle(c, cfg, circles, user, circle.ID, nil)
+	})
+}
+
+func updateCircleMember(cfg types.Config, circles store.CircleStore) echo.HandlerFunc {
+	return withCircle(cfg, circles, func(c echo.Context, user types.User, circle types.Circle, member types.CircleMember) error {
+		if !member.IsOwner() {
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("Only the owner can change roles"))
+		}
+
+		userID, err := parseUintParam(c, "userID")
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid member")
+		}
+
+		target, ok := circle.Member(userID)
+		if !ok {
+			return c.String(http.StatusNotFound, "member not found")
+		}
+
+		role := c.FormValue("role")
+		if !slices.Contains([]string{types.CircleRoleAdmin, types.CircleRoleMember}, role) {
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("Unknown role %q", role))
+		}
+		if target.IsOwner() {
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("The owner's role cannot be changed"))
+		}
+
+		if err := circles.SetRole(c.Request().Context(), circle.ID, target.UserID, role); err != nil {
+			return err
+		}
+
+		return renderCircle(c, cfg, circles, user, circle.ID, nil)
+	})
+}
+
+func removeCircleMember(cfg types.Config, circles store.CircleStore) echo.HandlerFunc {
+	return withCircle(cfg, circles, func(c echo.Context, user types.User, circle types.Circle, member types.CircleMember) error {
+		userID, err := parseUintParam(c, "userID")
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid member")
+		}
+
+		target, ok := circle.Member(userID)
+		if !ok {
+			return c.String(http.StatusNotFound, "member not found")
+		}
+
+		leaving := target.UserID == user.ID
+		switch {
+		case target.IsOwner():
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("The owner cannot leave the circle, delete it instead"))
+		case leaving:
+		case member.IsOwner():
+		case member.CanManage() && target.Role == types.CircleRoleMember:
+		default:
+			return renderCircle(c, cfg, circles, user, circle.ID, fmt.Errorf("You are not allowed to remove %s", target.User.Name))
+		}
+
+		if err := circles.RemoveMember(c.Request().Context(), circle.ID, target.UserID); err != nil {
+			return err
+		}
+
+		if leaving {
+			c.Response().Header().Set("HX-Redirect", "/circles")
+			return c.NoContent(200)
+		}
+		return renderCircle(c, cfg, circles, user, circle.ID, nil)
+	})
+}
+
+func joinCirclePage(cfg types.Config, circles store.CircleStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return render(c, 200, views.SignInForm(cfg, types.ComposeLink{}, fmt.Errorf("Sign in to accept your invite, then open the invite link again")))
+		}
+
+		invite, err := circles.GetInvite(c.Request().Context(), c.Param("token"))
+		if errors.Is(err, store.ErrNotFound) {
+			return render(c, 404, views.JoinCirclePage(cfg, user, invite, fmt.Errorf("This invite is invalid or has expired")))
+		} else if err != nil {
+			return err
+		}
+
+		return render(c, 200, views.JoinCirclePage(cfg, user, invite, nil))
+	}
+}
+
+func joinCircle(circles store.CircleStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		invite, err := circles.GetInvite(c.Request().Context(), c.Param("token"))
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "invite not found")
+		} else if err != nil {
+			return err
+		}
+
+		member := types.CircleMember{CircleID: invite.CircleID, UserID: user.ID, Role: types.CircleRoleMember}
+		if err := circles.AddMember(c.Request().Context(), member); err != nil {
+			return err
+		}
+		logrus.Infof("User %s joined circle %d", user.Email, invite.CircleID)
+
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/circles/%d", invite.CircleID))
+	}
+}
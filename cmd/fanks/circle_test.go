@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestCircleMembership(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			cfg := types.Config{}
+			alice := createTestUser(t, stores.Users, "alice@example.com")
+			bob := createTestUser(t, stores.Users, "bob@example.com")
+			carol := createTestUser(t, stores.Users, "carol@example.com")
+			dave := createTestUser(t, stores.Users, "dave@example.com")
+
+			members := func(circleID uint, user types.User) map[uint]string {
+				t.Helper()
+				circle, _, err := stores.Circles.GetForUser(t.Context(), circleID, user.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ret := map[uint]string{}
+				for _, m := range circle.Members {
+					ret[m.UserID] = m.Role
+				}
+				return ret
+			}
+
+			// Creating a circle makes the user its owner
+			rec := call(createCircle(cfg, stores.Circles, stores.Users), &alice, newRequest(http.MethodPost, "/circles", url.Values{"name": {"  "}}))
+			if rec.Code != 422 {
+				t.Errorf("creating a circle without a name answered %d, want 422", rec.Code)
+			}
+			rec = call(createCircle(cfg, stores.Circles, stores.Users), &alice, newRequest(http.MethodPost, "/circles", url.Values{"name": {"Family"}}))
+			if rec.Code != http.StatusFound {
+				t.Fatalf("creating a circle answered %d: %s", rec.Code, rec.Body)
+			}
+			var circleID uint
+			if _, err := fmt.Sscanf(rec.Header().Get("Location"), "/circles/%d", &circleID); err != nil {
+				t.Fatalf("creating a circle redirected to %q", rec.Header().Get("Location"))
+			}
+			id := fmt.Sprint(circleID)
+			if got := members(circleID, alice); got[alice.ID] != types.CircleRoleOwner {
+				t.Errorf("creator has role %q, want owner", got[alice.ID])
+			}
+
+			// Outsiders can neither see nor change the circle
+			rec = call(circlePage(cfg, stores.Circles), &bob, newRequest(http.MethodGet, "/circles/"+id, nil), "id", id)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("outsider viewing the circle answered %d, want 404", rec.Code)
+			}
+			rec = call(createCircleInvite(cfg, stores.Circles), &bob, newRequest(http.MethodPost, "/circles/"+id+"/invites", nil), "id", id)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("outsider inviting to the circle answered %d, want 404", rec.Code)
+			}
+
+			// Invites let people join
+			rec = call(createCircleInvite(cfg, stores.Circles), &alice, newRequest(http.MethodPost, "/circles/"+id+"/invites", nil), "id", id)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("creating an invite answered %d: %s", rec.Code, rec.Body)
+			}
+			circle, _, err := stores.Circles.GetForUser(t.Context(), circleID, alice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(circle.Invites) != 1 {
+				t.Fatalf("circle has %d invites, want 1", len(circle.Invites))
+			}
+			token := circle.Invites[0].Token
+			if !strings.Contains(rec.Body.String(), token) {
+				t.Error("circle page does not show the invite link")
+			}
+
+			rec = call(joinCirclePage(cfg, stores.Circles), nil, newRequest(http.MethodGet, "/circles/join/"+token, nil), "token", token)
+			if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Family") {
+				t.Errorf("signed out join page answered %d or named the circle", rec.Code)
+			}
+			rec = call(joinCirclePage(cfg, stores.Circles), &bob, newRequest(http.MethodGet, "/circles/join/nope", nil), "token", "nope")
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("join page for an unknown invite answered %d, want 404", rec.Code)
+			}
+			rec = call(joinCirclePage(cfg, stores.Circles), &bob, newRequest(http.MethodGet, "/circles/join/"+token, nil), "token", token)
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Family") {
+				t.Errorf("join page answered %d without the circle name", rec.Code)
+			}
+			for _, user := range []types.User{bob, bob, carol, dave} {
+				rec = call(joinCircle(stores.Circles), &user, newRequest(http.MethodPost, "/circles/join/"+token, nil), "token", token)
+				if rec.Code != http.StatusFound {
+					t.Errorf("%s joining answered %d: %s", user.Email, rec.Code, rec.Body)
+				}
+			}
+			if got := members(circleID, bob); len(got) != 4 || got[bob.ID] != types.CircleRoleMember {
+				t.Errorf("circle members are %v, want alice as owner and the others as members", got)
+			}
+
+			// Only owners and admins manage the circle
+			rec = call(renameCircle(cfg, stores.Circles), &bob, newRequest(http.MethodPut, "/circles/"+id, url.Values{"name": {"Bob's"}}), "id", id)
+			if rec.Code != 422 {
+				t.Errorf("member renaming the circle answered %d, want 422", rec.Code)
+			}
+			rec = call(updateCircleMember(cfg, stores.Circles), &alice, newRequest(http.MethodPut, "/circles/"+id, url.Values{"role": {types.CircleRoleOwner}}), "id", id, "userID", fmt.Sprint(bob.ID))
+			if rec.Code != 422 {
+				t.Errorf("making a second owner answered %d, want 422", rec.Code)
+			}
+			rec = call(updateCircleMember(cfg, stores.Circles), &alice, newRequest(http.MethodPut, "/circles/"+id, url.Values{"role": {types.CircleRoleAdmin}}), "id", id, "userID", fmt.Sprint(bob.ID))
+			if rec.Code != http.StatusOK {
+				t.Errorf("making bob an admin answered %d: %s", rec.Code, rec.Body)
+			}
+			rec = call(renameCircle(cfg, stores.Circles), &bob, newRequest(http.MethodPut, "/circles/"+id, url.Values{"name": {"Family & friends"}}), "id", id)
+			if rec.Code != http.StatusOK {
+				t.Errorf("admin renaming the circle answered %d: %s", rec.Code, rec.Body)
+			}
+
+			// Admins remove members, members only remove themselves and nobody removes the owner
+			removals := []struct {
+				by     types.User
+				target types.User
+				want   int
+			}{
+				{by: carol, target: dave, want: 422},
+				{by: bob, target: alice, want: 422},
+				{by: bob, target: carol, want: http.StatusOK},
+				{by: dave, target: dave, want: http.StatusOK},
+			}
+			for _, r := range removals {
+				rec = call(removeCircleMember(cfg, stores.Circles), &r.by, newRequest(http.MethodDelete, "/circles/"+id, nil), "id", id, "userID", fmt.Sprint(r.target.ID))
+				if rec.Code != r.want {
+					t.Errorf("%s removing %s answered %d, want %d", r.by.Email, r.target.Email, rec.Code, r.want)
+				}
+			}
+			if got := members(circleID, alice); len(got) != 2 || got[bob.ID] != types.CircleRoleAdmin {
+				t.Errorf("circle members are %v, want alice as owner and bob as admin", got)
+			}
+			if circle, _, _ := stores.Circles.GetForUser(t.Context(), circleID, alice.ID); circle.Name != "Family & friends" {
+				t.Errorf("circle is called %q after renaming", circle.Name)
+			}
+
+			// Only the owner deletes the circle
+			rec = call(deleteCircle(cfg, stores.Circles), &bob, newRequest(http.MethodDelete, "/circles/"+id, nil), "id", id)
+			if rec.Code != 422 {
+				t.Errorf("admin deleting the circle answered %d, want 422", rec.Code)
+			}
+			rec = call(deleteCircle(cfg, stores.Circles), &alice, newRequest(http.MethodDelete, "/circles/"+id, nil), "id", id)
+			if rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") != "/circles" {
+				t.Errorf("owner deleting the circle answered %d, redirect %q", rec.Code, rec.Header().Get("HX-Redirect"))
+			}
+			rec = call(circlePage(cfg, stores.Circles), &alice, newRequest(http.MethodGet, "/circles/"+id, nil), "id", id)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("deleted circle answered %d, want 404", rec.Code)
+			}
+			rec = call(joinCircle(stores.Circles), &carol, newRequest(http.MethodPost, "/circles/join/"+token, nil), "token", token)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("joining a deleted circle answered %d, want 404", rec.Code)
+			}
+		})
+	}
+}
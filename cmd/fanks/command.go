@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oliverisaac/fanks/backup"
+	"github.com/oliverisaac/fanks/migrations"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/vapid"
+	"github.com/pkg/errors"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	_ "github.com/ncruces/go-sqlite3/embed"
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+)
+
+const usage = `usage: fanks [command]
+
+Without a command fanks starts the web server.
+
+Commands:
+  migrate status   list the database migrations and whether they are applied
+  migrate up       apply all pending database migrations
+  backup [file]    write a snapshot of the database to file, or to the backup directory
+  restore <file>   replace the database with a snapshot, stop the server first
+  vapid generate   print a new pair of VAPID keys to sign push notifications with`
+
+func openDB(cfg types.Config) (*gorm.DB, error) {
+	dialector := sqlite.Open(cfg.DBPath)
+	if cfg.DBDriver == types.DBDriverPostgres {
+		dialector = postgres.Open(cfg.DatabaseURL)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	return db, errors.Wrapf(err, "failed to connect %s database", cfg.DBDriver)
+}
+
+// runCommand runs one of the maintenance commands instead of the web server
+func runCommand(cfg types.Config, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(cfg, args[1:])
+	case "backup":
+		return backupCommand(cfg, args[1:])
+	case "restore":
+		return restoreCommand(cfg, args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+func migrateCommand(cfg types.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := migrations.GetStatus(db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied() {
+				applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	case "up":
+		applied, err := migrations.Up(db)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("The database is up to date")
+		}
+		for _, m := range applied {
+			fmt.Printf("Applied %d %s\n", m.Version, m.Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], usage)
+	}
+}
+
+func backupCommand(cfg types.Config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	if !cfg.SupportsSnapshots() {
+		return backup.ErrUnsupported
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		if err := backup.Snapshot(context.Background(), db, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Backed up the database to %s\n", args[0])
+		return nil
+	}
+
+	file, err := backup.Create(context.Background(), db, cfg.Backup.Dir, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up the database to %s\n", file.Path)
+	return nil
+}
+
+// vapidCommand runs without a config, as the config cannot be loaded until
+// there are keys
+func vapidCommand(args []string) error {
+	if len(args) != 1 || args[0] != "generate" {
+		return fmt.Errorf("%s", usage)
+	}
+
+	publicKey, privateKey, err := vapid.Generate()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("VAPID_PUBLIC_KEY=%s\nVAPID_PRIVATE_KEY=%s\n", publicKey, privateKey)
+	return nil
+}
+
+func restoreCommand(cfg types.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	if !cfg.SupportsSnapshots() {
+		return backup.ErrUnsupported
+	}
+
+	if err := backup.Verify(args[0]); err != nil {
+		return err
+	}
+
+	// Keep what is being replaced in case the wrong snapshot was picked
+	if _, err := os.Stat(cfg.DBPath); err == nil {
+		db, err := openDB(cfg)
+		if err != nil {
+			return err
+		}
+		file, err := backup.Create(context.Background(), db, cfg.Backup.Dir, time.Now())
+		if sqlDB, dbErr := db.DB(); dbErr == nil {
+			sqlDB.Close()
+		}
+		if err != nil {
+			return errors.Wrap(err, "backing up the current database")
+		}
+		fmt.Printf("Backed up the current database to %s\n", file.Path)
+	}
+
+	if err := backup.Restore(args[0], cfg.DBPath); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s\n", cfg.DBPath, args[0])
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+	"github.com/pkg/errors"
+)
+
+const maxDeviceLabelLength = 100
+
+// deviceLabel names a device after the browser and OS in its user agent, the
+// order matters as most browsers claim to be several others
+func deviceLabel(userAgent string) string {
+	browser := "Browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, os := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "Mac"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, os.token) {
+			return fmt.Sprintf("%s on %s", browser, os.name)
+		}
+	}
+	return browser
+}
+
+// getDeviceForUser returns the user's subscription with the id in the path
+func getDeviceForUser(c echo.Context, subs store.SubscriptionStore, userID uint) (types.PushSubscription, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return types.PushSubscription{}, errors.Wrapf(store.ErrNotFound, "parsing device id %q", c.Param("id"))
+	}
+	return subs.GetForUser(c.Request().Context(), uint(id), userID)
+}
+
+// withDevice loads the session user's device for the handler
+func withDevice(subs store.SubscriptionStore, fn func(c echo.Context, device types.PushSubscription) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		device, err := getDeviceForUser(c, subs, user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "device not found")
+		} else if err != nil {
+			return err
+		}
+		return fn(c, device)
+	}
+}
+
+func renameDevice(subs store.SubscriptionStore) echo.HandlerFunc {
+	return withDevice(subs, func(c echo.Context, device types.PushSubscription) error {
+		label := strings.TrimSpace(c.FormValue("label"))
+		if label == "" {
+			return render(c, 422, views.DeviceRow(device, "A device needs a name"))
+		}
+		if len(label) > maxDeviceLabelLength {
+			return render(c, 422, views.DeviceRow(device, fmt.Sprintf("Names can be at most %d characters", maxDeviceLabelLength)))
+		}
+
+		if err := subs.Rename(c.Request().Context(), device, label); err != nil {
+			return err
+		}
+		device.Label = label
+		return render(c, 200, views.DeviceRow(device, "Renamed"))
+	})
+}
+
+// testDevice queues a notification for just this device
+func testDevice(subs store.SubscriptionStore, queue *pushQueue) echo.HandlerFunc {
+	return withDevice(subs, func(c echo.Context, device types.PushSubscription) error {
+		msg := types.PushMessage{
+			Title: "Fanks",
+			Body:  fmt.Sprintf("Notifications work on %s", device.Label),
+			URL:   "/settings",
+			Topic: "fanks-test",
+		}
+		if _, err := queue.SendTo(c.Request().Context(), device, PushKindTest, msg); err != nil {
+			return err
+		}
+		return render(c, 200, views.DeviceRow(device, "Test notification sent"))
+	})
+}
+
+func removeDevice(subs store.SubscriptionStore) echo.HandlerFunc {
+	return withDevice(subs, func(c echo.Context, device types.PushSubscription) error {
+		if err := subs.Delete(c.Request().Context(), device); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+}
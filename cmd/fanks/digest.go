@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const digestHour = 8
+const digestHighlights = 5
+
+// digestPeriod returns the period covered by the most recent digest of the
+// given frequency. Weekly digests go out on Monday morning and monthly digests
+// on the morning of the first of the month.
+func digestPeriod(frequency string, now time.Time) (time.Time, time.Time) {
+	if frequency == types.DigestMonthly {
+		end := time.Date(now.Year(), now.Month(), 1, digestHour, 0, 0, 0, now.Location())
+		if end.After(now) {
+			end = end.AddDate(0, -1, 0)
+		}
+		return end.AddDate(0, -1, 0), end
+	}
+
+	end := startOfDay(now).Add(digestHour * time.Hour)
+	for end.Weekday() != time.Monday || end.After(now) {
+		end = end.AddDate(0, 0, -1)
+	}
+	return end.AddDate(0, 0, -7), end
+}
+
+// getStreakStats counts the consecutive days the user wrote at least one note
+func getStreakStats(ctx context.Context, notes store.NoteStore, userID uint, now time.Time) (types.StreakStats, error) {
+	var ret types.StreakStats
+
+	times, err := notes.CreationTimes(ctx, userID)
+	if err != nil {
+		return ret, err
+	}
+	ret.TotalNotes = len(times)
+
+	days := map[time.Time]bool{}
+	for _, t := range times {
+		days[startOfDay(t.In(now.Location()))] = true
+	}
+	ret.DaysWritten = len(days)
+
+	sorted := make([]time.Time, 0, len(days))
+	for d := range days {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	run := 0
+	for i, d := range sorted {
+		if i > 0 && sorted[i-1].AddDate(0, 0, 1).Equal(d) {
+			run++
+		} else {
+			run = 1
+		}
+		ret.LongestStreak = max(ret.LongestStreak, run)
+	}
+
+	// The current streak is still alive if the user has not written yet today
+	day := startOfDay(now)
+	if !days[day] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for days[day] {
+		ret.CurrentStreak++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return ret, nil
+}
+
+func buildDigest(ctx context.Context, cfg types.Config, notes store.NoteStore, user types.User, now time.Time) (types.Digest, error) {
+	start, end := digestPeriod(user.DigestFrequency, now)
+	digest := types.Digest{
+		User:      user,
+		Hostname:  cfg.Hostname,
+		Frequency: user.DigestFrequency,
+		Start:     start,
+		End:       end,
+	}
+
+	var err error
+	digest.Notes, err = notes.ListForUser(ctx, user.ID, store.NoteFilter{AuthorID: user.ID, From: start, Until: end}, 0)
+	if err != nil {
+		return digest, errors.Wrap(err, "finding notes for digest")
+	}
+	// The digest reads from the start of the period
+	slices.Reverse(digest.Notes)
+
+	digest.Highlights, err = notes.ListForUser(ctx, user.ID, store.NoteFilter{NotAuthorID: user.ID, From: start, Until: end}, digestHighlights)
+	if err != nil {
+		return digest, errors.Wrap(err, "finding highlights for digest")
+	}
+
+	digest.Stats, err = getStreakStats(ctx, notes, user.ID, now)
+	return digest, err
+}
+
+func digestEmail(digest types.Digest) (Email, error) {
+	var html bytes.Buffer
+	if err := views.DigestEmail(digest).Render(context.Background(), &html); err != nil {
+		return Email{}, errors.Wrap(err, "rendering digest")
+	}
+
+	return Email{
+		To:      fmt.Sprintf("%q <%s>", digest.User.Name, digest.User.Email),
+		Subject: views.DigestSubject(digest),
+		HTML:    html.String(),
+		Text:    views.DigestText(digest),
+	}, nil
+}
+
+// sendDueDigests emails every opted-in user whose digest for the current
+// period has not been sent yet
+func sendDueDigests(ctx context.Context, cfg types.Config, notes store.NoteStore, users store.UserStore, mailer Mailer, now time.Time) error {
+	subscribers, err := users.ListWithDigests(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range subscribers {
+		_, end := digestPeriod(user.DigestFrequency, now)
+		if user.DigestLastSentAt != nil && !user.DigestLastSentAt.Before(end) {
+			continue
+		}
+
+		logrus := logrus.WithField("user", user.Email)
+		digest, err := buildDigest(ctx, cfg, notes, user, now)
+		if err != nil {
+			logrus.Error(errors.Wrap(err, "building digest"))
+			continue
+		}
+
+		email, err := digestEmail(digest)
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+
+		if err := mailer.Send(email); err != nil {
+			logrus.Error(errors.Wrap(err, "sending digest"))
+			continue
+		}
+
+		if err := users.MarkDigestSent(ctx, user, now); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		logrus.Infof("Sent %s digest", user.DigestFrequency)
+	}
+	return nil
+}
+
+func startDigestWorker(workers *workerGroup, cfg types.Config, notes store.NoteStore, users store.UserStore, mailer Mailer) {
+	if mailer == nil {
+		logrus.Info("SMTP is not configured, email digests are disabled")
+		return
+	}
+
+	workers.Every("digest", 10*time.Minute, false, func(ctx context.Context) {
+		if err := sendDueDigests(ctx, cfg, notes, users, mailer, time.Now()); err != nil {
+			logrus.Error(errors.Wrap(err, "sending digests"))
+		}
+	})
+}
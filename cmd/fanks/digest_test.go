@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestDigestPeriod(t *testing.T) {
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		frequency string
+		now       time.Time
+		start     time.Time
+		end       time.Time
+	}{
+		// 2026-06-15 is a Monday
+		{frequency: types.DigestWeekly, now: at(time.June, 15, 9), start: at(time.June, 8, 8), end: at(time.June, 15, 8)},
+		{frequency: types.DigestWeekly, now: at(time.June, 15, 7), start: at(time.June, 1, 8), end: at(time.June, 8, 8)},
+		{frequency: types.DigestWeekly, now: at(time.June, 18, 12), start: at(time.June, 8, 8), end: at(time.June, 15, 8)},
+		{frequency: types.DigestMonthly, now: at(time.June, 1, 8), start: at(time.May, 1, 8), end: at(time.June, 1, 8)},
+		{frequency: types.DigestMonthly, now: at(time.June, 1, 7), start: at(time.April, 1, 8), end: at(time.May, 1, 8)},
+		{frequency: types.DigestMonthly, now: at(time.January, 20, 12), start: at(time.December, 1, 8).AddDate(-1, 0, 0), end: at(time.January, 1, 8)},
+	}
+	for _, tt := range tests {
+		start, end := digestPeriod(tt.frequency, tt.now)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%s digest at %s covers %s to %s, want %s to %s", tt.frequency, tt.now, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestSendDueDigests(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			cfg := types.Config{Hostname: "fanks.example.com"}
+			alice := types.User{Name: "Alice", Email: "alice@example.com", DigestFrequency: types.DigestWeekly}
+			if err := stores.Users.Create(t.Context(), &alice); err != nil {
+				t.Fatal(err)
+			}
+			bob := createTestUser(t, stores.Users, "bob@example.com")
+			family := createTestCircle(t, stores.Circles, "Family", alice, bob)
+
+			at := func(day, hour int) time.Time {
+				return time.Date(2026, time.June, day, hour, 0, 0, 0, time.Local)
+			}
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "before the week"}, at(7, 20))
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "tuesday walk"}, at(9, 20))
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "friday dinner"}, at(12, 20))
+			createTestNote(t, stores.Notes, types.Note{UserID: bob.ID, Content: "bob shared", Circles: []types.Circle{family}}, at(10, 20))
+			createTestNote(t, stores.Notes, types.Note{UserID: bob.ID, Content: "bob kept"}, at(10, 21))
+
+			mailer := &fakeMailer{}
+			now := at(15, 9)
+			if err := sendDueDigests(t.Context(), cfg, stores.Notes, stores.Users, mailer, now); err != nil {
+				t.Fatal(err)
+			}
+			sent := mailer.Sent()
+			if len(sent) != 1 {
+				t.Fatalf("sent %d digests, want 1", len(sent))
+			}
+			if !strings.Contains(sent[0].To, "alice@example.com") {
+				t.Errorf("digest went to %s", sent[0].To)
+			}
+			for _, s := range []string{"tuesday walk", "friday dinner", "bob shared"} {
+				if !strings.Contains(sent[0].HTML, s) || !strings.Contains(sent[0].Text, s) {
+					t.Errorf("digest does not contain %q", s)
+				}
+			}
+			for _, s := range []string{"before the week", "bob kept"} {
+				if strings.Contains(sent[0].HTML, s) || strings.Contains(sent[0].Text, s) {
+					t.Errorf("digest contains %q", s)
+				}
+			}
+			if strings.Index(sent[0].Text, "tuesday walk") > strings.Index(sent[0].Text, "friday dinner") {
+				t.Error("digest does not list the notes oldest first")
+			}
+
+			// The digest for the week goes out once
+			if err := sendDueDigests(t.Context(), cfg, stores.Notes, stores.Users, mailer, now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(mailer.Sent()); n != 1 {
+				t.Errorf("sent %d digests after sending again in the same week, want 1", n)
+			}
+
+			if err := sendDueDigests(t.Context(), cfg, stores.Notes, stores.Users, mailer, now.AddDate(0, 0, 7)); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(mailer.Sent()); n != 2 {
+				t.Errorf("sent %d digests after a week went by, want 2", n)
+			}
+		})
+	}
+}
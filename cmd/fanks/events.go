@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	NoteCreated = "created"
+	NoteUpdated = "updated"
+	NoteDeleted = "deleted"
+)
+
+// subscriberBuffer is how many events a slow client may fall behind before
+// events are dropped for it
+const subscriberBuffer = 16
+
+// NoteEvent is published whenever a note changes. Audience holds the IDs of the
+// users who are allowed to see the note.
+type NoteEvent struct {
+	Kind     string
+	Note     types.Note
+	Audience []uint
+}
+
+type Subscriber struct {
+	UserID uint
+	Events chan NoteEvent
+}
+
+// EventHub is an in-process pub/sub hub which fans note events out to every
+// connected client that is allowed to see them.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscriber]struct{}
+	closed      bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: map[*Subscriber]struct{}{},
+	}
+}
+
+func (h *EventHub) Subscribe(userID uint) *Subscriber {
+	s := &Subscriber{
+		UserID: userID,
+		Events: make(chan NoteEvent, subscriberBuffer),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.Events)
+		return s
+	}
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe removes the subscriber and closes its event channel. It is safe
+// to call more than once.
+func (h *EventHub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.Events)
+	}
+}
+
+// Close disconnects every subscriber, and the ones which subscribe later right
+// away, so open event streams do not hold up the server shutting down
+func (h *EventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		close(s.Events)
+	}
+}
+
+// Publish delivers the event to every subscriber in its audience. It never
+// blocks: subscribers whose buffer is full miss the event.
+func (h *EventHub) Publish(ev NoteEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers {
+		if !slices.Contains(ev.Audience, s.UserID) {
+			continue
+		}
+		select {
+		case s.Events <- ev:
+		default:
+			logrus.Warnf("Dropping %s event for note %d, user %d is not keeping up", ev.Kind, ev.Note.ID, s.UserID)
+		}
+	}
+}
+
+// publishNoteEvent loads the audience of the note and publishes the event. Failures
+// are logged rather than returned so that they never fail the request which changed the note.
+func publishNoteEvent(notes store.NoteStore, hub *EventHub, kind string, note types.Note) {
+	audience, err := notes.Audience(context.Background(), note)
+	if err != nil {
+		logrus.Error(errors.Wrapf(err, "publishing %s event for note %d", kind, note.ID))
+		return
+	}
+	hub.Publish(NoteEvent{Kind: kind, Note: note, Audience: audience})
+}
+
+func noteEventComponent(ev NoteEvent, userID uint, filter store.NoteFilter) templ.Component {
+	note := ev.Note
+	note.IsUserNote = note.UserID == userID
+
+	if ev.Kind == NoteDeleted {
+		return views.NoteStreamDeleted(note)
+	}
+
+	if !filter.Matches(note) {
+		// The note is not part of the feed being viewed
+		return views.NoteStreamDeleted(note)
+	}
+
+	if ev.Kind == NoteUpdated {
+		return views.NoteStreamUpdated(note)
+	}
+	return views.NoteStreamCreated(note)
+}
+
+func writeSSE(w http.ResponseWriter, event string, data string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func streamEvents(hub *EventHub) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		filter := store.NoteFilter{Tag: normalizeTag(c.QueryParam("tag"))}
+		if id, err := strconv.ParseUint(c.QueryParam("circle"), 10, 64); err == nil {
+			filter.CircleID = uint(id)
+		}
+
+		w := c.Response()
+		w.Header().Set(echo.HeaderContentType, "text/event-stream")
+		w.Header().Set(echo.HeaderCacheControl, "no-cache")
+		w.Header().Set(echo.HeaderConnection, "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		w.Flush()
+
+		sub := hub.Subscribe(user.ID)
+		defer hub.Unsubscribe(sub)
+		logrus.Debugf("User %s connected to the event stream", user.Email)
+
+		keepalive := time.NewTicker(30 * time.Second)
+		defer keepalive.Stop()
+
+		ctx := c.Request().Context()
+		for {
+			select {
+			case <-ctx.Done():
+				logrus.Debugf("User %s disconnected from the event stream", user.Email)
+				return nil
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return nil
+				}
+				w.Flush()
+			case ev, ok := <-sub.Events:
+				if !ok {
+					return nil
+				}
+				var buf bytes.Buffer
+				if err := noteEventComponent(ev, user.ID, filter).Render(ctx, &buf); err != nil {
+					return errors.Wrap(err, "rendering note event")
+				}
+				if err := writeSSE(w, "note", buf.String()); err != nil {
+					return nil
+				}
+				w.Flush()
+			}
+		}
+	}
+}
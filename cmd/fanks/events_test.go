@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/types"
+)
+
+func noteEvent(id uint, audience ...uint) NoteEvent {
+	note := types.Note{Content: "hello"}
+	note.ID = id
+	return NoteEvent{Kind: NoteCreated, Note: note, Audience: audience}
+}
+
+func subscriberCount(h *EventHub) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+func receive(t *testing.T, s *Subscriber) (NoteEvent, bool) {
+	t.Helper()
+	select {
+	case ev, ok := <-s.Events:
+		return ev, ok
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+		return NoteEvent{}, false
+	}
+}
+
+func assertNoEvent(t *testing.T, s *Subscriber) {
+	t.Helper()
+	select {
+	case ev := <-s.Events:
+		t.Fatalf("user %d got event for note %d, want none", s.UserID, ev.Note.ID)
+	default:
+	}
+}
+
+func TestEventHubDeliversToAudience(t *testing.T) {
+	hub := NewEventHub()
+	alice := hub.Subscribe(1)
+	aliceTab := hub.Subscribe(1)
+	bob := hub.Subscribe(2)
+	carol := hub.Subscribe(3)
+
+	hub.Publish(noteEvent(10, 1, 2))
+
+	for _, s := range []*Subscriber{alice, aliceTab, bob} {
+		ev, ok := receive(t, s)
+		if !ok || ev.Note.ID != 10 {
+			t.Errorf("user %d got note %d (open %v), want note 10", s.UserID, ev.Note.ID, ok)
+		}
+	}
+	assertNoEvent(t, carol)
+}
+
+func TestEventHubUnsubscribe(t *testing.T) {
+	hub := NewEventHub()
+	alice := hub.Subscribe(1)
+	bob := hub.Subscribe(2)
+
+	hub.Unsubscribe(alice)
+	hub.Unsubscribe(alice)
+	if _, ok := <-alice.Events; ok {
+		t.Error("events channel is open after unsubscribing")
+	}
+	if n := subscriberCount(hub); n != 1 {
+		t.Errorf("hub has %d subscribers, want 1", n)
+	}
+
+	hub.Publish(noteEvent(10, 1, 2))
+	if ev, ok := receive(t, bob); !ok || ev.Note.ID != 10 {
+		t.Errorf("bob got note %d (open %v), want note 10", ev.Note.ID, ok)
+	}
+}
+
+func TestEventHubSlowConsumer(t *testing.T) {
+	hub := NewEventHub()
+	slow := hub.Subscribe(1)
+	fast := hub.Subscribe(1)
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := range subscriberBuffer + 5 {
+			hub.Publish(noteEvent(uint(i+1), 1))
+			// fast keeps up, slow never reads
+			if ev, ok := <-fast.Events; !ok || ev.Note.ID != uint(i+1) {
+				t.Errorf("fast got note %d (open %v), want note %d", ev.Note.ID, ok, i+1)
+			}
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a slow subscriber")
+	}
+
+	if n := len(slow.Events); n != subscriberBuffer {
+		t.Fatalf("slow subscriber has %d buffered events, want %d", n, subscriberBuffer)
+	}
+	// The oldest events are kept and the ones which did not fit are dropped
+	for i := range subscriberBuffer {
+		if ev := <-slow.Events; ev.Note.ID != uint(i+1) {
+			t.Errorf("slow event %d is note %d, want note %d", i, ev.Note.ID, i+1)
+		}
+	}
+}
+
+func TestEventHubClose(t *testing.T) {
+	hub := NewEventHub()
+	alice := hub.Subscribe(1)
+
+	hub.Close()
+	if _, ok := <-alice.Events; ok {
+		t.Error("events channel is open after closing the hub")
+	}
+	late := hub.Subscribe(2)
+	if _, ok := <-late.Events; ok {
+		t.Error("subscribing after close returned an open channel")
+	}
+	hub.Unsubscribe(alice)
+	hub.Publish(noteEvent(10, 1, 2))
+}
+
+func TestStreamEventsUnsubscribesOnDisconnect(t *testing.T) {
+	hub := NewEventHub()
+	alice := types.User{Email: "alice@example.com"}
+	alice.ID = 1
+	e := echo.New()
+	signedInAs(e, alice)
+	e.GET("/events", streamEvents(hub))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Errorf("content type is %q, want text/event-stream", ct)
+	}
+
+	waitFor(t, "the stream to subscribe", func() bool { return subscriberCount(hub) == 1 })
+	hub.Publish(noteEvent(10, 1))
+
+	lines := bufio.NewScanner(res.Body)
+	if !lines.Scan() || lines.Text() != "event: note" {
+		t.Fatalf("first line is %q, want event: note", lines.Text())
+	}
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "data: ") {
+		t.Fatalf("second line is %q, want data", lines.Text())
+	}
+
+	cancel()
+	waitFor(t, "the stream to unsubscribe", func() bool { return subscriberCount(hub) == 0 })
+}
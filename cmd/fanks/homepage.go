@@ -1,32 +1,63 @@
 package main
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
 	"github.com/oliverisaac/fanks/types"
 	"github.com/oliverisaac/fanks/views"
 	"github.com/sirupsen/logrus"
-	"gorm.io/gorm"
 )
 
-func homePageHandler(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+// feedLength is the number of notes shown on the home page
+const feedLength = 50
+
+func homePageHandler(cfg types.Config, notes store.NoteStore, users store.UserStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		pageData := types.HomePageData{Config: cfg}
 
 		if user, ok := GetSessionUser(c); ok {
 			logrus.Infof("Generating homepage for user %s", user.Email)
-			notes, err := GetAllNotes(db)
+			circles, err := users.Circles(c.Request().Context(), user.ID)
 			if err != nil {
-				pageData.WithError(err)
+				pageData = pageData.WithError(err)
+			}
+
+			filter := store.NoteFilter{Tag: normalizeTag(c.QueryParam("tag"))}
+			if id, err := strconv.ParseUint(c.QueryParam("circle"), 10, 64); err == nil {
+				for _, circle := range circles {
+					if circle.ID == uint(id) {
+						filter.CircleID = circle.ID
+						pageData = pageData.WithCircle(circle)
+					}
+				}
+			}
+
+			feed, err := notes.ListForUser(c.Request().Context(), user.ID, filter, feedLength)
+			if err != nil {
+				pageData = pageData.WithError(err)
+			}
+
+			if filter == (store.NoteFilter{}) {
+				memories, err := getMemories(c.Request().Context(), notes, user.ID, time.Now())
+				if err != nil {
+					pageData = pageData.WithError(err)
+				}
+				pageData = pageData.WithMemories(memories)
 			}
 
-			for i, note := range notes {
+			for i, note := range feed {
 				note.IsUserNote = note.UserID == user.ID
-				notes[i] = note
+				feed[i] = note
 			}
 
 			pageData = pageData.
 				WithUser(user).
-				WithNotes(notes)
+				WithTag(filter.Tag).
+				WithCircles(circles).
+				WithNotes(feed)
 		} else {
 			logrus.Debug("Generating anonymous homepage")
 		}
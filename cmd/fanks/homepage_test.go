@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestFeedPages(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			cfg := types.Config{}
+			alice := createTestUser(t, stores.Users, "alice@example.com")
+			bob := createTestUser(t, stores.Users, "bob@example.com")
+			carol := createTestUser(t, stores.Users, "carol@example.com")
+			family := createTestCircle(t, stores.Circles, "Family", alice, bob)
+
+			now := time.Now()
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "shared sunshine #sun", Circles: []types.Circle{family}}, now.Add(-time.Hour))
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "private thought"}, now.Add(-2*time.Hour))
+			createTestNote(t, stores.Notes, types.Note{UserID: bob.ID, Content: "bob on his own #sun"}, now.Add(-3*time.Hour))
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "a year of gratitude"}, now.AddDate(-1, 0, 0))
+
+			tests := []struct {
+				name    string
+				user    *types.User
+				target  string
+				want    []string
+				notWant []string
+			}{
+				{
+					name:    "home of the author",
+					user:    &alice,
+					target:  "/",
+					want:    []string{"shared sunshine", "private thought", "a year of gratitude"},
+					notWant: []string{"bob on his own"},
+				},
+				{
+					name:    "home of a circle member",
+					user:    &bob,
+					target:  "/",
+					want:    []string{"shared sunshine", "bob on his own"},
+					notWant: []string{"private thought", "a year of gratitude"},
+				},
+				{
+					name:    "home of an outsider",
+					user:    &carol,
+					target:  "/",
+					notWant: []string{"shared sunshine", "private thought", "bob on his own"},
+				},
+				{
+					name:    "home filtered to a circle",
+					user:    &alice,
+					target:  fmt.Sprintf("/?circle=%d", family.ID),
+					want:    []string{"shared sunshine"},
+					notWant: []string{"private thought"},
+				},
+				{
+					name:    "home filtered to a circle the user is not in",
+					user:    &carol,
+					target:  fmt.Sprintf("/?circle=%d", family.ID),
+					notWant: []string{"shared sunshine"},
+				},
+				{
+					name:    "home filtered to a tag",
+					user:    &bob,
+					target:  "/?tag=%23Sun",
+					want:    []string{"shared sunshine", "bob on his own"},
+					notWant: []string{"private thought"},
+				},
+				{
+					name:    "signed out home",
+					target:  "/",
+					notWant: []string{"shared sunshine", "private thought"},
+				},
+			}
+
+			home := homePageHandler(cfg, stores.Notes, stores.Users)
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					rec := call(home, tt.user, newRequest(http.MethodGet, tt.target, nil))
+					if rec.Code != http.StatusOK {
+						t.Fatalf("answered %d: %s", rec.Code, rec.Body)
+					}
+					body := rec.Body.String()
+					for _, s := range tt.want {
+						if !strings.Contains(body, s) {
+							t.Errorf("page does not show %q", s)
+						}
+					}
+					for _, s := range tt.notWant {
+						if strings.Contains(body, s) {
+							t.Errorf("page shows %q", s)
+						}
+					}
+				})
+			}
+
+			t.Run("memories", func(t *testing.T) {
+				rec := call(memoriesPage(cfg, stores.Notes), &alice, newRequest(http.MethodGet, "/memories", nil))
+				if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "a year of gratitude") {
+					t.Errorf("memories of the author answered %d without last year's note", rec.Code)
+				}
+
+				rec = call(memoriesPage(cfg, stores.Notes), &bob, newRequest(http.MethodGet, "/memories", nil))
+				if strings.Contains(rec.Body.String(), "a year of gratitude") || strings.Contains(rec.Body.String(), "shared sunshine") {
+					t.Error("memories show notes the user did not write")
+				}
+
+				rec = call(memoriesPage(cfg, stores.Notes), nil, newRequest(http.MethodGet, "/memories", nil))
+				if rec.Code != http.StatusFound {
+					t.Errorf("signed out memories answered %d, want %d", rec.Code, http.StatusFound)
+				}
+			})
+
+			t.Run("tags", func(t *testing.T) {
+				rec := call(tagsPage(cfg, stores.Notes), &bob, newRequest(http.MethodGet, "/tags", nil))
+				if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/tags/sun") {
+					t.Errorf("tags page answered %d without the sun tag: %s", rec.Code, rec.Body)
+				}
+
+				rec = call(tagsPage(cfg, stores.Notes), &carol, newRequest(http.MethodGet, "/tags", nil))
+				if strings.Contains(rec.Body.String(), "/tags/sun") {
+					t.Error("tags page lists tags of notes the user cannot see")
+				}
+
+				rec = call(tagTimelinePage(cfg, stores.Notes), &alice, newRequest(http.MethodGet, "/tags/Sun", nil), "name", "Sun")
+				body := rec.Body.String()
+				if rec.Code != http.StatusOK || !strings.Contains(body, "shared sunshine") {
+					t.Errorf("tag timeline answered %d without the tagged note", rec.Code)
+				}
+				if strings.Contains(body, "bob on his own") || strings.Contains(body, "private thought") {
+					t.Error("tag timeline shows notes the user cannot see or which lack the tag")
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	_ "image/gif"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const maxImageDimension = 2048
+const thumbDimension = 480
+
+// maxImagePixels bounds the images which are decoded at all. A small file can
+// claim a huge size and would take gigabytes to decode, a 48 megapixel phone
+// photo still fits.
+const maxImagePixels = 50_000_000
+
+type processedImage struct {
+	ContentType string
+	Ext         string
+	Data        []byte
+	Thumb       []byte
+	Width       int
+	Height      int
+}
+
+// processImage decodes an uploaded image, rotates it upright and re-encodes a
+// full size copy and a thumbnail. Re-encoding drops all metadata, including
+// the EXIF location data that phones embed in photos.
+func processImage(r io.Reader) (processedImage, error) {
+	var ret processedImage
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ret, errors.Wrap(err, "reading image")
+	}
+
+	errUnsupported := fmt.Errorf("that file does not look like a supported image (jpeg, png, gif or webp)")
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ret, errUnsupported
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return ret, fmt.Errorf("that image is too large, it can have at most %d megapixels", maxImagePixels/1_000_000)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ret, errUnsupported
+	}
+
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	img = fit(img, maxImageDimension)
+	bounds := img.Bounds()
+	ret.Width, ret.Height = bounds.Dx(), bounds.Dy()
+
+	// Keep transparency for formats which usually have it
+	encode := encodeJPEG
+	ret.ContentType, ret.Ext = "image/jpeg", "jpg"
+	if format == "png" || format == "gif" {
+		encode = encodePNG
+		ret.ContentType, ret.Ext = "image/png", "png"
+	}
+
+	if ret.Data, err = encode(img); err != nil {
+		return ret, err
+	}
+	if ret.Thumb, err = encode(fit(img, thumbDimension)); err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	return buf.Bytes(), errors.Wrap(err, "encoding jpeg")
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), errors.Wrap(err, "encoding png")
+}
+
+// fit scales the image down so neither side is longer than limit
+func fit(img image.Image, limit int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= limit && h <= limit {
+		return img
+	}
+	if w > h {
+		w, h = limit, h*limit/w
+	} else {
+		w, h = w*limit/h, limit
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// orient applies an EXIF orientation so the image is upright
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // needs a 90 degree clockwise rotation
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs a 90 degree counter clockwise rotation
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation tag of a jpeg, or 1 if it has none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			// Image data starts at SOS, there is no metadata after it
+			return 1
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func encodeTestPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := range w {
+		img.Set(x, 0, color.NRGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImage(t *testing.T) {
+	// A GIF header which claims to be 65535x65535, decoding it would
+	// allocate gigabytes
+	bomb := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00;")
+
+	tests := []struct {
+		name       string
+		data       []byte
+		err        string
+		width      int
+		height     int
+		thumbWidth int
+	}{
+		{name: "small png", data: encodeTestPNG(t, 40, 20), width: 40, height: 20, thumbWidth: 40},
+		{name: "large png is scaled down", data: encodeTestPNG(t, 4096, 1024), width: 2048, height: 512, thumbWidth: 480},
+		{name: "decompression bomb", data: bomb, err: "too large"},
+		{name: "not an image", data: []byte("hello"), err: "supported image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := processImage(bytes.NewReader(tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ContentType != "image/png" || got.Width != tt.width || got.Height != tt.height {
+				t.Errorf("got %s %dx%d, want image/png %dx%d", got.ContentType, got.Width, got.Height, tt.width, tt.height)
+			}
+			thumb, err := png.DecodeConfig(bytes.NewReader(got.Thumb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if thumb.Width != tt.thumbWidth {
+				t.Errorf("thumbnail is %d wide, want %d", thumb.Width, tt.thumbWidth)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverisaac/fanks/types"
+	"github.com/pkg/errors"
+)
+
+type Email struct {
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+	Headers map[string]string
+}
+
+type Mailer interface {
+	Send(email Email) error
+}
+
+// smtpTimeout bounds sending a single email, so an SMTP server which stops
+// answering holds up neither its worker nor shutting down
+const smtpTimeout = 30 * time.Second
+
+type smtpMailer struct {
+	cfg     types.SMTPConfig
+	timeout time.Duration
+}
+
+// NewMailer returns a mailer which sends through the configured SMTP server,
+// or nil if SMTP is not configured
+func NewMailer(cfg types.SMTPConfig) Mailer {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &smtpMailer{cfg: cfg, timeout: smtpTimeout}
+}
+
+func (m *smtpMailer) Send(email Email) error {
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return errors.Wrap(err, "parsing from address")
+	}
+	to, err := mail.ParseAddress(email.To)
+	if err != nil {
+		return errors.Wrap(err, "parsing to address")
+	}
+
+	msg, err := buildMessage(from, to, email)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: m.cfg.Host}
+	dialer := &net.Dialer{Timeout: m.timeout}
+	var conn net.Conn
+	if m.cfg.Port == 465 {
+		// Port 465 expects TLS from the first byte
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return errors.Wrap(err, "connecting to smtp server")
+	}
+	if err := conn.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+		conn.Close()
+		return errors.Wrap(err, "setting smtp deadline")
+	}
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "creating smtp client")
+	}
+	defer client.Close()
+
+	if m.cfg.Port != 465 {
+		// Upgrade to STARTTLS when the server supports it, like smtp.SendMail
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return errors.Wrap(err, "starting tls")
+			}
+		}
+	}
+	if m.cfg.Username != "" {
+		auth := smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return errors.Wrap(err, "authenticating with smtp server")
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return errors.Wrap(err, "setting sender")
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return errors.Wrap(err, "setting recipient")
+	}
+	w, err := client.Data()
+	if err != nil {
+		return errors.Wrap(err, "starting message")
+	}
+	if _, err := w.Write(msg); err != nil {
+		return errors.Wrap(err, "writing message")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "finishing message")
+	}
+	return errors.Wrap(client.Quit(), "closing smtp connection")
+}
+
+// buildMessage renders the email as a multipart/alternative message with a
+// plaintext and an HTML part
+func buildMessage(from, to *mail.Address, email Email) ([]byte, error) {
+	var buf bytes.Buffer
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "generating message id")
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+
+	headers := map[string]string{
+		"From":         from.String(),
+		"To":           to.String(),
+		"Subject":      mime.QEncoding.Encode("utf-8", email.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Message-ID":   fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain),
+		"MIME-Version": "1.0",
+		"Content-Type": fmt.Sprintf("multipart/alternative; boundary=%q", mw.Boundary()),
+	}
+	for k, v := range email.Headers {
+		headers[k] = v
+	}
+	for _, k := range []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"} {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, headers[k])
+		delete(headers, k)
+	}
+	for k, v := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "creating message part")
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, errors.Wrap(err, "writing message part")
+		}
+		if err := qp.Close(); err != nil {
+			return nil, errors.Wrap(err, "writing message part")
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, errors.Wrap(err, "closing message")
+	}
+
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+// fakeSMTPServer accepts connections on a local port and hands each to serve
+func fakeSMTPServer(t *testing.T, serve func(conn net.Conn)) types.SMTPConfig {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return types.SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "Fanks <fanks@example.com>"}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	data := make(chan string, 1)
+	cfg := fakeSMTPServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				reply("354 go ahead")
+				var msg strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					msg.WriteString(line)
+				}
+				data <- msg.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	})
+
+	mailer := NewMailer(cfg)
+	err := mailer.Send(Email{To: "Alice <alice@example.com>", Subject: "Hello", Text: "What are you grateful for?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-data
+	for _, s := range []string{"To: \"Alice\" <alice@example.com>", "Subject: Hello", "What are you grateful for?"} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("message does not contain %q:\n%s", s, msg)
+		}
+	}
+}
+
+func TestSMTPMailerTimeout(t *testing.T) {
+	// The server accepts the connection and never answers
+	cfg := fakeSMTPServer(t, func(conn net.Conn) {
+		conn.Read(make([]byte, 1))
+	})
+
+	mailer := &smtpMailer{cfg: cfg, timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err := mailer.Send(Email{To: "alice@example.com", Subject: "Hello", Text: "Hi"})
+	if err == nil {
+		t.Fatal("sending to a server which does not answer succeeded")
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("sending gave up after %s, want about 100ms", took)
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/a-h/templ"
@@ -13,14 +16,14 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
+	"github.com/oliverisaac/fanks/blobstore"
+	"github.com/oliverisaac/fanks/migrations"
 	"github.com/oliverisaac/fanks/static"
+	"github.com/oliverisaac/fanks/store"
 	"github.com/oliverisaac/fanks/types"
 	"github.com/oliverisaac/goli"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-
-	_ "github.com/ncruces/go-sqlite3/embed"
-	sqlite "github.com/ncruces/go-sqlite3/gormlite"
 	"gorm.io/gorm"
 )
 
@@ -28,6 +31,10 @@ func init() {
 	goli.InitLogrus(logrus.DebugLevel)
 }
 
+// shutdownTimeout bounds how long shutting down may take, it is below the 30
+// seconds Kubernetes waits after SIGTERM before it kills the pod
+const shutdownTimeout = 25 * time.Second
+
 const SessionKey = "session"
 const UserKey = "session-user"
 const SessionUserIDKey = "userid"
@@ -44,13 +51,13 @@ func render(ctx echo.Context, status int, t templ.Component) error {
 }
 
 func main() {
-	err := run()
+	err := run(os.Args[1:])
 	if err != nil {
 		logrus.Fatal(err)
 	}
 }
 
-func run() error {
+func run(args []string) error {
 	err := godotenv.Load(".env")
 	if err != nil {
 		logrus.Error(errors.Wrap(err, "Failed to load .env"))
@@ -65,11 +72,29 @@ func run() error {
 		time.Local = loc
 	}
 
+	if len(args) > 0 && args[0] == "vapid" {
+		return vapidCommand(args[1:])
+	}
+
 	cfg, err := types.ConfigFromEnv()
 	if err != nil {
 		return errors.Wrap(err, "Loading config from env")
 	}
 
+	if len(args) > 0 {
+		return runCommand(cfg, args)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serve(ctx, cfg, ":8080")
+}
+
+// serve runs the server on addr until ctx is done. It then stops taking new
+// requests and waits for the open ones, stops the workers, flushes the push
+// queue and closes the database, giving up on whatever is left after
+// shutdownTimeout.
+func serve(ctx context.Context, cfg types.Config, addr string) error {
 	e := echo.New()
 
 	e.StaticFS("/static", static.FS)
@@ -97,32 +122,87 @@ func run() error {
 	}))
 
 	e.Use(middleware.Secure())
+	e.Use(metricsMiddleware)
+	e.Use(bodyLimit(cfg))
 
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: "method=${method}, uri=${uri}, status=${status}\n",
 		Skipper: func(c echo.Context) bool {
-			return c.Request().URL.Path == "/healthz"
+			switch c.Request().URL.Path {
+			case "/healthz", "/readyz", "/metrics":
+				return true
+			}
+			return false
 		},
 	}))
 
-	db, err := gorm.Open(sqlite.Open(cfg.DBPath), &gorm.Config{})
+	db, err := openDB(cfg)
 	if err != nil {
-		return errors.Wrap(err, "failed to connect database")
+		return err
 	}
+	defer closeDB(db)
 
-	err = db.AutoMigrate(&types.User{}, &types.Note{}, &types.PushSubscription{})
-	if err != nil {
+	if _, err := migrations.Up(db); err != nil {
 		return errors.Wrap(err, "Failed to migrate")
 	}
 
-	err = startNotificationWorker(cfg, db)
+	if err := instrumentDB(db); err != nil {
+		return err
+	}
+	stores := store.NewGORM(db)
+
+	// Devices which subscribed before keys were recorded used the keys which
+	// were configured then, which are the previous ones while rotating
+	vapidKey := cfg.VapidPublicKey
+	if cfg.RotatingVapidKeys() {
+		vapidKey = cfg.VapidPreviousPublicKey
+	}
+	if err := stores.Subscriptions.AssignVapidKey(ctx, vapidKey); err != nil {
+		return err
+	}
+
+	workers := newWorkerGroup()
+	defer func() {
+		// Only has something to stop when serve returns before shutting down
+		stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := workers.Stop(stopCtx); err != nil {
+			logrus.Error(err)
+		}
+	}()
+
+	queue := startPushQueue(workers, cfg, db, stores)
+	stats, err := newStatsRegistry(db, queue)
+	if err != nil {
+		return errors.Wrap(err, "registering metrics")
+	}
+
+	err = startNotificationWorker(workers, queue)
 	if err != nil {
 		return errors.Wrap(err, "Failed to setup notifciation worker")
 	}
 
+	mailer := NewMailer(cfg.SMTP)
+	startDigestWorker(workers, cfg, stores.Notes, stores.Users, mailer)
+	if err := startReminderEmailWorker(workers, cfg, stores.Users, mailer); err != nil {
+		return err
+	}
+
+	hub := NewEventHub()
+	e.Server.RegisterOnShutdown(hub.Close)
+
+	blobs, err := blobstore.NewDisk(cfg.AttachmentsPath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open attachment store")
+	}
+
+	startTrashPurger(workers, cfg, db, blobs)
+	startIdempotencyKeyExpiry(workers, stores.Notes)
+	startBackupWorker(workers, cfg, db)
+
 	store := sessions.NewCookieStore(cfg.CookeSecret)
 	e.Use(session.Middleware(store))
-	e.Use(UserMiddleware(db))
+	e.Use(UserMiddleware(stores.Users))
 
 	e.GET("/serviceWorker.js", func(c echo.Context) error {
 		sw, err := static.FS.ReadFile("serviceWorker.js")
@@ -133,40 +213,131 @@ func run() error {
 	})
 
 	// Pages
-	e.GET("/", homePageHandler(cfg, db))
+	e.GET("/", homePageHandler(cfg, stores.Notes, stores.Users))
+	e.GET("/memories", memoriesPage(cfg, stores.Notes))
+	e.GET("/mood", moodPage(cfg, stores.Notes))
+	e.GET("/settings", settingsPage(cfg))
+	e.PUT("/settings", updateSettings(cfg, stores.Users))
+	e.GET("/email/write", composeFromEmail(cfg))
+	e.GET("/email/unsubscribe", unsubscribeFromEmail(cfg))
+	e.POST("/email/unsubscribe", confirmUnsubscribe(cfg, stores.Users))
+	e.POST("/settings/channels", addChannel(stores.Users, stores.Channels))
+	e.POST("/settings/channels/:id/test", testChannel(stores.Channels, queue))
+	e.DELETE("/settings/channels/:id", removeChannel(stores.Channels))
+	e.GET("/metrics", serveMetrics(cfg, stats))
+	// /healthz only says the server answers, /readyz checks what it depends on
 	e.GET("/healthz", func(c echo.Context) error {
 		return c.String(http.StatusOK, "ok")
 	})
+	e.GET("/readyz", readyz(db, workers))
 
 	// Blocks
 	e.GET("/auth/sign-in", signIn(cfg))
-	e.POST("/auth/sign-in", signInWithEmailAndPassword(db, cfg))
+	e.POST("/auth/sign-in", signInWithEmailAndPassword(stores.Users, cfg))
 	if cfg.AllowSignup || len(cfg.AllowSignupEmails) > 0 {
 		e.GET("/auth/sign-up", signUp())
-		e.POST("/auth/sign-up", signUpWithEmailAndPassword(db, cfg))
+		e.POST("/auth/sign-up", signUpWithEmailAndPassword(stores.Users, cfg))
 	}
 	e.POST("/auth/sign-out", signOut())
 
 	// notes
-	e.GET("/note/create", createNoteNoPrompt(db))
-	e.POST("/note/create", createNote(db))
-	e.DELETE("/note/:id", deleteNote(db))
+	e.GET("/note/create", createNoteNoPrompt(stores.Users))
+	e.POST("/note/create", createNote(cfg, stores.Notes, stores.Users, hub, blobs))
+	e.POST("/note/preview", previewNote())
+	e.GET("/note/:id", showNote(stores.Notes))
+	e.GET("/note/:id/edit", editNote(stores.Notes))
+	e.PUT("/note/:id", updateNote(stores.Notes, hub))
+	e.DELETE("/note/:id", deleteNote(stores.Notes, hub))
+	e.POST("/note/:id/restore", restoreNote(cfg, stores.Notes, hub))
+	e.GET("/trash", trashPage(cfg, stores.Notes))
+
+	// attachments
+	e.GET("/attachments/:id", serveAttachment(stores.Notes, blobs, false))
+	e.GET("/attachments/:id/thumb", serveAttachment(stores.Notes, blobs, true))
+
+	// tags
+	e.GET("/tags", tagsPage(cfg, stores.Notes))
+	e.GET("/tags/:name", tagTimelinePage(cfg, stores.Notes))
+
+	// live updates
+	e.GET("/events", streamEvents(hub))
+
+	// circles
+	e.GET("/circles", circlesPage(cfg, stores.Users))
+	e.POST("/circles", createCircle(cfg, stores.Circles, stores.Users))
+	e.GET("/circles/join/:token", joinCirclePage(cfg, stores.Circles))
+	e.POST("/circles/join/:token", joinCircle(stores.Circles))
+	e.GET("/circles/:id", circlePage(cfg, stores.Circles))
+	e.PUT("/circles/:id", renameCircle(cfg, stores.Circles))
+	e.DELETE("/circles/:id", deleteCircle(cfg, stores.Circles))
+	e.POST("/circles/:id/invites", createCircleInvite(cfg, stores.Circles))
+	e.DELETE("/circles/:id/invites/:inviteID", deleteCircleInvite(cfg, stores.Circles))
+	e.PUT("/circles/:id/members/:userID", updateCircleMember(cfg, stores.Circles))
+	e.DELETE("/circles/:id/members/:userID", removeCircleMember(cfg, stores.Circles))
+
+	// admin
+	e.GET("/admin", adminPage(cfg, stores.PushJobs, stores.Subscriptions))
+	e.GET("/admin/backup", downloadBackup(cfg, stores.Snapshots))
+	e.POST("/admin/push-jobs/:id/retry", retryPushJob(stores.PushJobs, queue))
 
 	// push
-	e.POST("/push/subscribe", saveSubscription(db))
-	e.POST("/push/unsubscribe", removeSubscription(db))
-	e.POST("/push/trigger", triggerPushes())
+	e.POST("/push/subscribe", saveSubscription(cfg, stores.Subscriptions))
+	e.POST("/push/unsubscribe", removeSubscription(stores.Subscriptions))
+	e.PUT("/push/devices/:id", renameDevice(stores.Subscriptions))
+	e.POST("/push/devices/:id/test", testDevice(stores.Subscriptions, queue))
+	e.DELETE("/push/devices/:id", removeDevice(stores.Subscriptions))
+	e.POST("/push/trigger", triggerPushes(queue))
+	e.GET("/push/trigger/:id", pushBatchStatus(stores.PushJobs))
+	e.POST("/push/actions/reply", replyToReminder(cfg, stores.Notes, stores.Users, hub))
+	e.POST("/push/actions/snooze", snoozeReminder(cfg, stores.Users, queue))
+	e.POST("/push/actions/skip", skipReminder(cfg, stores.Users, queue))
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.Start(addr)
+	}()
+	select {
+	case err := <-errc:
+		return errors.Wrap(err, "running server")
+	case <-ctx.Done():
+	}
+
+	logrus.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	return e.Start(":8080")
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		logrus.Error(errors.Wrap(err, "waiting for open requests"))
+	}
+	if err := workers.Stop(shutdownCtx); err != nil {
+		logrus.Error(errors.Wrap(err, "stopping workers"))
+	}
+	if err := queue.Flush(shutdownCtx); err != nil {
+		logrus.Error(errors.Wrap(err, "flushing push queue"))
+	}
+	logrus.Info("Shut down")
+	return nil
+}
+
+// closeDB closes the connections to the database, it is logged rather than
+// returned as there is nothing left to do about it
+func closeDB(db *gorm.DB) {
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		logrus.Error(errors.Wrap(err, "closing database"))
+	}
 }
 
-func UserMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+func UserMiddleware(users store.UserStore) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			sess, _ := session.Get(SessionKey, c)
 			if sess.Values[SessionUserIDKey] != nil {
 				userID := sess.Values[SessionUserIDKey].(uint)
-				user, err := getUserByID(db, userID)
+				user, err := users.Get(c.Request().Context(), userID)
 				if err != nil {
 					return errors.Wrap(err, "getting user by id")
 				}
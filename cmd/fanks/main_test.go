@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/migrations"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/vapid"
+)
+
+// TestServeShutdown starts the server in-process, stops it while a push is
+// being delivered and checks the push is recorded rather than sent again
+func TestServeShutdown(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, privateKey, err := vapid.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := types.Config{
+		CookeSecret:     []byte("secret"),
+		DBDriver:        types.DBDriverSQLite,
+		DBPath:          filepath.Join(dir, "fanks.db"),
+		AttachmentsPath: filepath.Join(dir, "attachments"),
+		MaxUploadBytes:  1 << 20,
+		TrashRetention:  30 * 24 * time.Hour,
+		Backup:          types.BackupConfig{Dir: filepath.Join(dir, "backups")},
+		VapidPublicKey:  publicKey,
+		VapidPrivateKey: privateKey,
+		Push:            types.PushConfig{Workers: 2, Timeout: 5 * time.Second},
+	}
+
+	// The push service holds on to the push until the server is shutting down
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var received atomic.Int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer service.Close()
+
+	db, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	q := newPushQueue(cfg, db, store.NewGORM(db))
+	user := createTestUser(t, q.users, "alice@example.com")
+	job := queueTestPush(t, q, createTestSubscription(t, q, user, service.URL+"/device"), time.Now())
+	closeDB(db)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, cfg, addr)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		res, err := http.Get("http://" + addr + "/healthz")
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not come up: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case <-arrived:
+	case <-time.After(10 * time.Second):
+		t.Fatal("push queue did not send the due push")
+	}
+	cancel()
+	time.AfterFunc(200*time.Millisecond, func() { close(release) })
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("serve returned %v", err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Fatal("server did not shut down")
+	}
+	if _, err := http.Get("http://" + addr + "/healthz"); err == nil {
+		t.Error("server still answers after shutting down")
+	}
+
+	db, err = openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB(db)
+	if err := db.First(&job, job.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != types.PushJobSent || job.Attempts != 1 {
+		t.Errorf("push delivered during shutdown is %s after %d attempts, want sent after 1", job.Status, job.Attempts)
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("push service received %d pushes, want 1", n)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func getMemories(ctx context.Context, notes store.NoteStore, userID uint, day time.Time) (types.Memories, error) {
+	var ret types.Memories
+	var err error
+
+	ret.OnThisDay, err = notes.OnThisDay(ctx, userID, startOfDay(day))
+	if err != nil {
+		return ret, err
+	}
+
+	ret.Random, err = notes.RandomBefore(ctx, userID, startOfDay(day))
+	if err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+func memoriesPage(cfg types.Config, notes store.NoteStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		pageData := types.HomePageData{Config: cfg}.WithUser(user)
+		memories, err := getMemories(c.Request().Context(), notes, user.ID, time.Now())
+		if err != nil {
+			pageData = pageData.WithError(err)
+		}
+		pageData = pageData.WithMemories(memories)
+
+		return render(c, 200, views.MemoriesPage(pageData))
+	}
+}
+
+// noteText returns the written part of a note, falling back to its three things
+func noteText(note types.Note) string {
+	if note.Content == "" {
+		return strings.Join(note.Things, ", ")
+	}
+	return note.Content
+}
+
+func snippet(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n])) + "…"
+}
+
+// memoriesMessage builds the weekly memories notification. It returns false
+// if the user has no past notes to resurface.
+func memoriesMessage(memories types.Memories, now time.Time) (types.PushMessage, bool) {
+	msg := types.PushMessage{
+		Title: "Fanks memories",
+		URL:   "/memories",
+		Topic: "fanks-weekly-memories",
+	}
+
+	switch {
+	case len(memories.OnThisDay) > 0:
+		note := memories.OnThisDay[0]
+		years := now.Year() - note.CreatedAt.In(now.Location()).Year()
+		ago := "1 year ago"
+		if years != 1 {
+			ago = fmt.Sprintf("%d years ago", years)
+		}
+		msg.Body = fmt.Sprintf("On this day %s you were grateful for: %s", ago, snippet(noteText(note), 120))
+	case memories.Random != nil:
+		msg.Body = fmt.Sprintf("Remember when you were grateful for: %s", snippet(noteText(*memories.Random), 120))
+	default:
+		return msg, false
+	}
+	return msg, true
+}
+
+// queueMemoriesForUser queues the weekly memories notification for each of the user's devices
+func queueMemoriesForUser(db *gorm.DB, batch types.PushBatch, user types.User, memories types.Memories) error {
+	msg, ok := memoriesMessage(memories, time.Now())
+	if !ok {
+		logrus.Debugf("No memories to send to user %s", user.Email)
+		return nil
+	}
+	for _, sub := range user.PushSubscriptions {
+		if err := enqueuePush(db, batch, sub, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/version"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fanks_http_requests_total",
+		Help: "HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fanks_http_request_duration_seconds",
+		Help:    "Time taken to answer HTTP requests by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	notesCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fanks_notes_created_total",
+		Help: "Notes created, by where they were written.",
+	}, []string{"source"})
+	pushSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fanks_push_sends_total",
+		Help: "Attempts at delivering a push job, by channel and outcome.",
+	}, []string{"channel", "outcome"})
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fanks_db_query_duration_seconds",
+		Help:    "Time taken by database statements, by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "fanks_build_info",
+		Help:        "The version fanks was built from, always 1.",
+		ConstLabels: prometheus.Labels{"version": version.Tag, "goversion": runtime.Version()},
+	}, func() float64 { return 1 })
+)
+
+const (
+	NoteSourceComposer     = "composer"
+	NoteSourceNotification = "notification"
+)
+
+// pushOutcome names what came of an attempt for fanks_push_sends_total
+func pushOutcome(job *types.PushJob) string {
+	if job.Status == types.PushJobPending {
+		return "retry"
+	}
+	return job.Status
+}
+
+// metricsMiddleware counts requests by the route they matched, so IDs in
+// paths do not create a series each
+func metricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			// The error handler writes the response after the middleware returns
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			} else {
+				status = http.StatusInternalServerError
+			}
+		}
+		route := c.Path()
+		if status == http.StatusNotFound && (route == "" || strings.HasSuffix(route, "/*")) {
+			route = "unmatched"
+		}
+
+		labels := []string{c.Request().Method, route, strconv.Itoa(status)}
+		httpRequests.WithLabelValues(labels...).Inc()
+		httpRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// serveMetrics exposes the metrics for Prometheus, those of the default
+// registry along with stats. They are public unless cfg.MetricsToken is set,
+// then scrapes need it as a bearer token.
+func serveMetrics(cfg types.Config, stats prometheus.Gatherer) echo.HandlerFunc {
+	handler := echo.WrapHandler(promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, stats}, promhttp.HandlerOpts{})))
+	return func(c echo.Context) error {
+		if cfg.MetricsToken != "" {
+			token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) != 1 {
+				return c.String(http.StatusUnauthorized, "unauthorized")
+			}
+		}
+		return handler(c)
+	}
+}
+
+// statsCollector reads the gauges which live in the database when Prometheus
+// scrapes, rather than keeping them up to date on every change
+type statsCollector struct {
+	db    *gorm.DB
+	queue *pushQueue
+
+	activeUsers    *prometheus.Desc
+	queueDepth     *prometheus.Desc
+	pushesInFlight *prometheus.Desc
+}
+
+// activeUserWindows are the periods users count as active for after writing a note
+var activeUserWindows = []struct {
+	label string
+	since time.Duration
+}{
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// newStatsRegistry returns a registry of the stats of db and queue. It is one
+// of its own, so every server started in a process reports its own database.
+func newStatsRegistry(db *gorm.DB, queue *pushQueue) (*prometheus.Registry, error) {
+	reg := prometheus.NewRegistry()
+	err := reg.Register(&statsCollector{
+		db:    db,
+		queue: queue,
+		activeUsers: prometheus.NewDesc("fanks_active_users",
+			"Users who wrote a note within the window.", []string{"window"}, nil),
+		queueDepth: prometheus.NewDesc("fanks_push_queue_depth",
+			"Push jobs waiting to be sent, including those waiting for a retry.", nil, nil),
+		pushesInFlight: prometheus.NewDesc("fanks_push_jobs_in_flight",
+			"Push jobs being sent right now.", nil, nil),
+	})
+	return reg, err
+}
+
+func (s *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.activeUsers
+	ch <- s.queueDepth
+	ch <- s.pushesInFlight
+}
+
+func (s *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, window := range activeUserWindows {
+		var count int64
+		err := s.db.Model(&types.Note{}).
+			Where("created_at >= ?", now.Add(-window.since)).
+			Distinct("user_id").Count(&count).Error
+		if err != nil {
+			logrus.Error(errors.Wrap(err, "counting active users"))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(s.activeUsers, prometheus.GaugeValue, float64(count), window.label)
+	}
+
+	var pending int64
+	if err := s.db.Model(&types.PushJob{}).Where("status = ?", types.PushJobPending).Count(&pending).Error; err != nil {
+		logrus.Error(errors.Wrap(err, "counting pending push jobs"))
+	} else {
+		ch <- prometheus.MustNewConstMetric(s.queueDepth, prometheus.GaugeValue, float64(pending))
+	}
+	ch <- prometheus.MustNewConstMetric(s.pushesInFlight, prometheus.GaugeValue, float64(len(s.queue.inFlightIDs())))
+}
+
+// instrumentDB times every statement gorm runs. The start time is kept on the
+// statement, each of which belongs to a single goroutine.
+func instrumentDB(db *gorm.DB) error {
+	const startKey = "metrics:start"
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(startKey, time.Now())
+	}
+	after := func(operation string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			if start, ok := tx.InstanceGet(startKey); ok {
+				dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start.(time.Time)).Seconds())
+			}
+		}
+	}
+
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("metrics:before_create", before),
+		cb.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", before),
+		cb.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", before),
+		cb.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", before),
+		cb.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	} {
+		if err != nil {
+			return errors.Wrap(err, "registering database metrics")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrape fetches /metrics from h with the given Authorization header
+func scrape(t *testing.T, h http.Handler, authorization string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if authorization != "" {
+		req.Header.Set(echo.HeaderAuthorization, authorization)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeMetricsToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{name: "public", want: http.StatusOK},
+		{name: "missing token", token: "s3cret", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "s3cret", authorization: "Bearer guess", want: http.StatusUnauthorized},
+		{name: "token without Bearer", token: "s3cret", authorization: "s3cret", want: http.StatusUnauthorized},
+		{name: "token", token: "s3cret", authorization: "Bearer s3cret", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/metrics", serveMetrics(types.Config{MetricsToken: tt.token}, prometheus.NewRegistry()))
+			rec := scrape(t, e, tt.authorization)
+			if rec.Code != tt.want {
+				t.Errorf("scrape answered %d, want %d", rec.Code, tt.want)
+			}
+			if scraped := strings.Contains(rec.Body.String(), "fanks_build_info"); scraped != (tt.want == http.StatusOK) {
+				t.Errorf("scrape answered %d with metrics: %v", rec.Code, scraped)
+			}
+		})
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(metricsMiddleware)
+	e.GET("/metrics", serveMetrics(types.Config{}, prometheus.NewRegistry()))
+	e.GET("/metrics-test/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.POST("/metrics-test/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden)
+	})
+	e.DELETE("/metrics-test/:id", func(c echo.Context) error {
+		return errors.New("database is on fire")
+	})
+	e.GET("/metrics-test-files/*", func(c echo.Context) error {
+		return echo.ErrNotFound
+	})
+
+	for _, r := range []struct{ method, path string }{
+		{http.MethodGet, "/metrics-test/1"},
+		{http.MethodGet, "/metrics-test/2"},
+		{http.MethodPost, "/metrics-test/1"},
+		{http.MethodDelete, "/metrics-test/1"},
+		{http.MethodGet, "/metrics-test-files/missing.png"},
+		{http.MethodGet, "/no/such/page"},
+	} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(r.method, r.path, nil))
+	}
+
+	body := scrape(t, e, "").Body.String()
+	for _, want := range []string{
+		// IDs in paths share the series of their route
+		`fanks_http_requests_total{method="GET",route="/metrics-test/:id",status="200"} 2`,
+		`fanks_http_requests_total{method="POST",route="/metrics-test/:id",status="403"} 1`,
+		`fanks_http_requests_total{method="DELETE",route="/metrics-test/:id",status="500"} 1`,
+		`fanks_http_request_duration_seconds_count{method="GET",route="/metrics-test/:id",status="200"} 2`,
+		// Paths nothing serves do not create a series each
+		`fanks_http_requests_total{method="GET",route="unmatched",status="404"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+	for _, unwanted := range []string{"/no/such/page", "missing.png", `route="/metrics-test-files/*"`} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("metrics contain %s", unwanted)
+		}
+	}
+}
+
+func TestStatsCollector(t *testing.T) {
+	q, db, stores := newTestPushQueue(t)
+	now := time.Now()
+	alice := createTestUser(t, stores.Users, "alice@example.com")
+	bob := createTestUser(t, stores.Users, "bob@example.com")
+	carol := createTestUser(t, stores.Users, "carol@example.com")
+	createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "today"}, now.Add(-time.Hour))
+	createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "yesterday"}, now.Add(-25*time.Hour))
+	createTestNote(t, stores.Notes, types.Note{UserID: bob.ID, Content: "this week"}, now.Add(-3*24*time.Hour))
+	createTestNote(t, stores.Notes, types.Note{UserID: carol.ID, Content: "last month"}, now.Add(-40*24*time.Hour))
+
+	sub := createTestSubscription(t, q, alice, "https://push.example.com/alice")
+	inFlight := queueTestPush(t, q, sub, now)
+	queueTestPush(t, q, sub, now.Add(time.Hour))
+	sent := queueTestPush(t, q, sub, now.Add(-time.Hour))
+	if err := db.Model(&sent).Update("status", types.PushJobSent).Error; err != nil {
+		t.Fatal(err)
+	}
+	q.mu.Lock()
+	q.inFlight[inFlight.ID] = struct{}{}
+	q.mu.Unlock()
+
+	stats, err := newStatsRegistry(db, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.GET("/metrics", serveMetrics(types.Config{}, stats))
+	body := scrape(t, e, "").Body.String()
+	for _, want := range []string{
+		`fanks_active_users{window="1d"} 1`,
+		`fanks_active_users{window="7d"} 2`,
+		`fanks_active_users{window="30d"} 2`,
+		`fanks_push_queue_depth 2`,
+		`fanks_push_jobs_in_flight 1`,
+		// The default registry is scraped alongside
+		`fanks_build_info{`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
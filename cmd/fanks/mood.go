@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+)
+
+const maxThings = 3
+
+// moodRanges are the number of days the mood chart can show
+var moodRanges = []int{30, 90, 365}
+
+func parseMood(s string) (int, error) {
+	if s == "" {
+		return types.MoodNone, nil
+	}
+	mood, err := strconv.Atoi(s)
+	if err != nil || mood < types.MoodMin || mood > types.MoodMax {
+		return types.MoodNone, fmt.Errorf("mood must be between %d and %d", types.MoodMin, types.MoodMax)
+	}
+	return mood, nil
+}
+
+// parseThings returns the non-empty "three things" answers
+func parseThings(values []string) ([]string, error) {
+	ret := []string{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	if len(ret) > maxThings {
+		return nil, fmt.Errorf("you can list at most %d things", maxThings)
+	}
+	return ret, nil
+}
+
+// getMoodDays returns the average mood of each day since the given time on
+// which the user rated their mood, oldest first
+func getMoodDays(ctx context.Context, notes store.NoteStore, userID uint, since time.Time) ([]types.MoodDay, error) {
+	rated, err := notes.Moods(ctx, userID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []types.MoodDay{}
+	total := 0
+	for _, note := range rated {
+		day := startOfDay(note.CreatedAt.Local())
+		if len(ret) == 0 || !ret[len(ret)-1].Day.Equal(day) {
+			ret = append(ret, types.MoodDay{Day: day})
+			total = 0
+		}
+		last := &ret[len(ret)-1]
+		total += note.Mood
+		last.Count++
+		last.Average = float64(total) / float64(last.Count)
+	}
+	return ret, nil
+}
+
+func moodPage(cfg types.Config, notes store.NoteStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		days, _ := strconv.Atoi(c.QueryParam("days"))
+		if !slices.Contains(moodRanges, days) {
+			days = moodRanges[0]
+		}
+
+		chart := types.MoodChart{Days: days, Ranges: moodRanges}
+		chart.End = startOfDay(time.Now()).AddDate(0, 0, 1)
+		chart.Start = chart.End.AddDate(0, 0, -days)
+		var err error
+		chart.Moods, err = getMoodDays(c.Request().Context(), notes, user.ID, chart.Start)
+		return render(c, 200, views.MoodPage(cfg, user, chart, err))
+	}
+}
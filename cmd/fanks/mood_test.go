@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestParseMood(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "", want: types.MoodNone},
+		{in: "1", want: 1},
+		{in: "5", want: 5},
+		{in: "0", wantErr: true},
+		{in: "6", wantErr: true},
+		{in: "happy", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMood(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMood(%q) = %d, %v, want %d and error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMoodDays(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			alice := createTestUser(t, stores.Users, "alice@example.com")
+			bob := createTestUser(t, stores.Users, "bob@example.com")
+
+			today := startOfDay(time.Now())
+			yesterday := today.AddDate(0, 0, -1)
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "long ago", Mood: 1}, today.AddDate(0, 0, -40))
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "morning", Mood: 2}, yesterday.Add(8*time.Hour))
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "unrated"}, yesterday.Add(12*time.Hour))
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "evening", Mood: 5}, yesterday.Add(20*time.Hour))
+			createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "today", Mood: 4}, today.Add(time.Minute))
+			createTestNote(t, stores.Notes, types.Note{UserID: bob.ID, Content: "bob", Mood: 1}, today.Add(time.Minute))
+
+			days, err := getMoodDays(t.Context(), stores.Notes, alice.ID, today.AddDate(0, 0, -30))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []types.MoodDay{
+				{Day: yesterday, Average: 3.5, Count: 2},
+				{Day: today, Average: 4, Count: 1},
+			}
+			if len(days) != len(want) {
+				t.Fatalf("got %d mood days, want %d: %v", len(days), len(want), days)
+			}
+			for i := range want {
+				if !days[i].Day.Equal(want[i].Day) || days[i].Average != want[i].Average || days[i].Count != want[i].Count {
+					t.Errorf("day %d is %+v, want %+v", i, days[i], want[i])
+				}
+			}
+
+			for _, target := range []string{"/mood", "/mood?days=90", "/mood?days=7"} {
+				rec := call(moodPage(types.Config{}, stores.Notes), &alice, newRequest(http.MethodGet, target, nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("%s answered %d", target, rec.Code)
+				}
+			}
+			rec := call(moodPage(types.Config{}, stores.Notes), nil, newRequest(http.MethodGet, "/mood", nil))
+			if rec.Code != http.StatusFound {
+				t.Errorf("signed out mood page answered %d, want %d", rec.Code, http.StatusFound)
+			}
+		})
+	}
+}
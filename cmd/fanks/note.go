@@ -1,58 +1,187 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/blobstore"
+	"github.com/oliverisaac/fanks/store"
 	"github.com/oliverisaac/fanks/types"
 	"github.com/oliverisaac/fanks/views"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"gorm.io/gorm"
 )
 
 func newNoteForUser(prompt, content string, user types.User) types.Note {
-	return types.Note{
+	note := types.Note{
 		User:       user,
+		UserID:     user.ID,
 		Prompt:     prompt,
 		IsUserNote: true,
 		Content:    content,
-		CreatedAt:  time.Now(),
 	}
+	note.CreatedAt = time.Now()
+	return note
 }
 
-func createNote(db *gorm.DB) echo.HandlerFunc {
+const idempotencyKeyHeader = "Idempotency-Key"
+const maxIdempotencyKeyLength = 255
+
+// idempotencyKeyTTL is how long a key is remembered. Clients only retry a
+// submission for as long as a draft waits to be sent.
+const idempotencyKeyTTL = 7 * 24 * time.Hour
+
+const idempotencyKeyField = "idempotencyKey"
+
+// idempotencyKey returns the key the client sent to identify this submission.
+// API clients send a header, the create form sends a hidden field.
+func idempotencyKey(c echo.Context) string {
+	if key := c.Request().Header.Get(idempotencyKeyHeader); key != "" {
+		return key
+	}
+	return c.FormValue(idempotencyKeyField)
+}
+
+// findNoteByIdempotencyKey returns the note the user created with the key, or nil
+func findNoteByIdempotencyKey(ctx context.Context, notes store.NoteStore, userID uint, key string) (*types.Note, error) {
+	note, err := notes.GetByIdempotencyKey(ctx, userID, key)
+	if note != nil {
+		note.IsUserNote = true
+	}
+	return note, err
+}
+
+// pickCircles returns the circles with the given ids, ids of circles which are
+// not in the list are ignored
+func pickCircles(circles []types.Circle, ids []uint) []types.Circle {
+	ret := []types.Circle{}
+	for _, circle := range circles {
+		if slices.Contains(ids, circle.ID) {
+			ret = append(ret, types.Circle{Model: circle.Model, Name: circle.Name})
+		}
+	}
+	return ret
+}
+
+// replayCreateNote answers a repeated submission with the note the first
+// submission created. A note which was deleted since is not put back in the feed.
+func replayCreateNote(c echo.Context, note types.Note, circles []types.Circle) error {
+	c.Response().Header().Set("Idempotent-Replayed", "true")
+	if note.DeletedAt.Valid {
+		note = types.Note{}
+	}
+	return render(c, 200, views.CreateNoteForm(note, "random", randomPrompt(), circles, nil))
+}
+
+func createNote(cfg types.Config, notes store.NoteStore, users store.UserStore, hub *EventHub, blobs blobstore.Store) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user, ok := GetSessionUser(c)
 		if !ok {
 			return fmt.Errorf("You must be logged in to create a note")
 		}
+		ctx := c.Request().Context()
 
 		content := c.FormValue("content")
 		prompt := c.FormValue("prompt")
 		promptName := c.FormValue("promptName")
 		note := newNoteForUser(prompt, content, user)
 
-		if note.Content == "" {
-			return render(c, 422, views.CreateNoteForm(note, promptName, prompt, fmt.Errorf("you cannot have an empty note")))
+		circles, err := users.Circles(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		key := idempotencyKey(c)
+		if len(key) > maxIdempotencyKeyLength {
+			return c.String(http.StatusBadRequest, "idempotency key is too long")
+		}
+		if key != "" {
+			existing, err := findNoteByIdempotencyKey(ctx, notes, user.ID, key)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return replayCreateNote(c, *existing, circles)
+			}
+			note.IdempotencyKey = &key
+		}
+
+		images, err := readUploads(c, "photos", cfg.MaxUploadBytes)
+		if err != nil {
+			return render(c, 422, views.CreateNoteForm(note, promptName, prompt, circles, err))
 		}
 
-		if err := db.Create(&note).Error; err != nil {
+		note.Mood, err = parseMood(c.FormValue("mood"))
+		if err != nil {
+			return render(c, 422, views.CreateNoteForm(note, promptName, prompt, circles, err))
+		}
+
+		params, err := c.FormParams()
+		if err != nil {
+			return errors.Wrap(err, "parsing form")
+		}
+		note.Things, err = parseThings(params["things"])
+		if err != nil {
+			return render(c, 422, views.CreateNoteForm(note, promptName, prompt, circles, err))
+		}
+
+		if isEmptyNote(note.Content, note.Things, len(images)) {
+			return render(c, 422, views.CreateNoteForm(note, promptName, prompt, circles, fmt.Errorf("you cannot have an empty note")))
+		}
+
+		note.Circles = pickCircles(circles, parseCircleIDs(params["circles"]))
+		note.Tags = noteTags(note.Content)
+
+		err = putAttachments(ctx, blobs, &note, images)
+		if err == nil {
+			err = notes.Create(ctx, &note)
+			if err != nil {
+				removeAttachmentBlobs(ctx, blobs, note.Attachments)
+			}
+		}
+		if err != nil && key != "" {
+			// A parallel request with the same key may have won the race
+			// for the unique index
+			existing, findErr := findNoteByIdempotencyKey(ctx, notes, user.ID, key)
+			if findErr == nil && existing != nil {
+				return replayCreateNote(c, *existing, circles)
+			}
+		}
+		if err != nil {
 			err = errors.Wrap(err, "Saving note to db")
 			logrus.Error(err)
 			if prompt == "" {
 				prompt = randomPrompt()
 			}
-			return render(c, 500, views.CreateNoteForm(note, promptName, prompt, err))
+			return render(c, 500, views.CreateNoteForm(note, promptName, prompt, circles, err))
 		}
 
-		return render(c, 200, views.CreateNoteForm(note, "random", randomPrompt(), nil))
+		notesCreated.WithLabelValues(NoteSourceComposer).Inc()
+		publishNoteEvent(notes, hub, NoteCreated, note)
+
+		return render(c, 200, views.CreateNoteForm(note, "random", randomPrompt(), circles, nil))
 	}
 }
 
-func createNoteNoPrompt(db *gorm.DB) echo.HandlerFunc {
+// startIdempotencyKeyExpiry forgets idempotency keys once clients no longer retry with them
+func startIdempotencyKeyExpiry(workers *workerGroup, notes store.NoteStore) {
+	workers.Every("idempotency-keys", time.Hour, true, func(ctx context.Context) {
+		expired, err := notes.ExpireIdempotencyKeys(ctx, time.Now().Add(-idempotencyKeyTTL))
+		if err != nil {
+			logrus.Error(err)
+		} else if expired > 0 {
+			logrus.Infof("Expired %d idempotency keys", expired)
+		}
+	})
+}
+
+func createNoteNoPrompt(users store.UserStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		promptName := c.FormValue("promptName")
 		var prompt string
@@ -64,7 +193,28 @@ func createNoteNoPrompt(db *gorm.DB) echo.HandlerFunc {
 			promptName = "random"
 			prompt = randomPrompt()
 		}
-		return render(c, 200, views.CreateNoteForm(types.Note{}, promptName, prompt, nil))
+		circles := []types.Circle{}
+		if user, ok := GetSessionUser(c); ok {
+			var err error
+			circles, err = users.Circles(c.Request().Context(), user.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return render(c, 200, views.CreateNoteForm(types.Note{}, promptName, prompt, circles, nil))
+	}
+}
+
+func previewNote() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := GetSessionUser(c); !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		if c.FormValue("preview") == "" {
+			return c.NoContent(http.StatusOK)
+		}
+		return render(c, 200, views.NotePreview(c.FormValue("content")))
 	}
 }
 
@@ -103,37 +253,120 @@ func RandomItem[T any](items []T) T {
 	return items[randomIndex]
 }
 
-func deleteNote(db *gorm.DB) echo.HandlerFunc {
+// deleteNote moves a note to the trash, attachments are kept until it is purged
+func deleteNote(notes store.NoteStore, hub *EventHub) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user, ok := GetSessionUser(c)
 		if !ok {
 			return fmt.Errorf("You must be logged in to delete a note")
 		}
 
-		noteID := c.Param("id")
-
-		var note types.Note
-		if err := db.First(&note, noteID).Error; err != nil {
-			return errors.Wrap(err, "getting note from db")
+		note, err := getNoteForUser(c.Request().Context(), notes, c.Param("id"), user.ID)
+		if err != nil {
+			return err
 		}
 
-		if note.UserID != user.ID {
+		if !note.IsUserNote {
 			return fmt.Errorf("You are not authorized to delete this note")
 		}
 
-		if err := db.Delete(&note).Error; err != nil {
-			return errors.Wrap(err, "deleting note from db")
+		if err := notes.Delete(c.Request().Context(), note); err != nil {
+			return err
+		}
+
+		publishNoteEvent(notes, hub, NoteDeleted, note)
+
+		return render(c, 200, views.NoteDeletedToast(note))
+	}
+}
+
+// getNoteForUser returns the note if it is visible to the user
+func getNoteForUser(ctx context.Context, notes store.NoteStore, noteID string, userID uint) (types.Note, error) {
+	id, err := strconv.ParseUint(noteID, 10, 64)
+	if err != nil {
+		return types.Note{}, errors.Wrapf(store.ErrNotFound, "parsing note id %q", noteID)
+	}
+	note, err := notes.GetForUser(ctx, uint(id), userID)
+	note.IsUserNote = note.UserID == userID
+	return note, err
+}
+
+func showNote(notes store.NoteStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		note, err := getNoteForUser(c.Request().Context(), notes, c.Param("id"), user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "note not found")
+		} else if err != nil {
+			return err
+		}
+
+		return render(c, 200, views.Note(note))
+	}
+}
+
+func editNote(notes store.NoteStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		note, err := getNoteForUser(c.Request().Context(), notes, c.Param("id"), user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "note not found")
+		} else if err != nil {
+			return err
+		}
+
+		if !note.IsUserNote {
+			return c.String(http.StatusForbidden, "You are not authorized to edit this note")
 		}
 
-		return c.NoContent(200)
+		return render(c, 200, views.EditNoteForm(note, nil))
 	}
 }
 
-func GetAllNotes(db *gorm.DB) ([]types.Note, error) {
-	ret := []types.Note{}
-	result := db.Preload("User").Order("created_at DESC").Limit(50).Find(&ret)
-	if result.Error != nil {
-		return nil, errors.Wrapf(result.Error, "Looking for all notes")
+// isEmptyNote reports whether a note has nothing in it, a note of only things
+// or photos is not empty
+func isEmptyNote(content string, things []string, photos int) bool {
+	return content == "" && len(things) == 0 && photos == 0
+}
+
+func updateNote(notes store.NoteStore, hub *EventHub) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		note, err := getNoteForUser(c.Request().Context(), notes, c.Param("id"), user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "note not found")
+		} else if err != nil {
+			return err
+		}
+
+		if !note.IsUserNote {
+			return c.String(http.StatusForbidden, "You are not authorized to edit this note")
+		}
+
+		note.Content = c.FormValue("content")
+		if isEmptyNote(note.Content, note.Things, len(note.Attachments)) {
+			return render(c, 422, views.EditNoteForm(note, fmt.Errorf("you cannot have an empty note")))
+		}
+
+		note.Tags = noteTags(note.Content)
+		if err := notes.Update(c.Request().Context(), &note); err != nil {
+			return render(c, 500, views.EditNoteForm(note, errors.Wrap(err, "Saving note to db")))
+		}
+
+		publishNoteEvent(notes, hub, NoteUpdated, note)
+
+		return render(c, 200, views.Note(note))
 	}
-	return ret, nil
 }
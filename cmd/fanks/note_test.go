@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/blobstore"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+)
+
+func submitNote(e *echo.Echo, key string, content string) *httptest.ResponseRecorder {
+	form := url.Values{"content": {content}, "prompt": {"Today I am grateful for..."}}
+	req := httptest.NewRequest(http.MethodPost, "/note/create", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set(idempotencyKeyHeader, key)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCreateNoteIdempotency(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			blobs, err := blobstore.NewDisk(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := createTestUser(t, stores.Users, "alice@example.com")
+
+			e := echo.New()
+			signedInAs(e, user)
+			e.POST("/note/create", createNote(types.Config{MaxUploadBytes: 1 << 20}, stores.Notes, stores.Users, NewEventHub(), blobs))
+
+			listNotes := func() []types.Note {
+				t.Helper()
+				notes, err := stores.Notes.ListForUser(t.Context(), user.ID, store.NoteFilter{}, 100)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return notes
+			}
+
+			// A double tapped submit button and the draft queue send the
+			// same submission at the same time
+			const submits = 8
+			replayed := make([]bool, submits)
+			var wg sync.WaitGroup
+			for i := range submits {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rec := submitNote(e, "draft-1", "once")
+					if rec.Code != http.StatusOK {
+						t.Errorf("submit %d answered %d: %s", i, rec.Code, rec.Body)
+					}
+					replayed[i] = rec.Header().Get("Idempotent-Replayed") == "true"
+				}()
+			}
+			wg.Wait()
+
+			created := 0
+			for _, r := range replayed {
+				if !r {
+					created++
+				}
+			}
+			if created != 1 {
+				t.Errorf("%d submits created a note, want 1", created)
+			}
+			notes := listNotes()
+			if len(notes) != 1 {
+				t.Fatalf("user has %d notes, want 1", len(notes))
+			}
+
+			// Replaying the key after the note was deleted does not create it again
+			if err := stores.Notes.Delete(t.Context(), notes[0]); err != nil {
+				t.Fatal(err)
+			}
+			rec := submitNote(e, "draft-1", "once")
+			if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "true" {
+				t.Errorf("replay after delete answered %d, replayed %q", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+			}
+			if strings.Contains(rec.Body.String(), "once") {
+				t.Error("replay after delete put the deleted note back in the feed")
+			}
+			if n := len(listNotes()); n != 0 {
+				t.Errorf("user has %d notes after replaying a deleted one, want 0", n)
+			}
+
+			// Once the key expired it can be used again
+			expired, err := stores.Notes.ExpireIdempotencyKeys(t.Context(), time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expired != 1 {
+				t.Errorf("expired %d keys, want 1", expired)
+			}
+			rec = submitNote(e, "draft-1", "again")
+			if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+				t.Errorf("submit with an expired key answered %d, replayed %q", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+			}
+			if n := len(listNotes()); n != 1 {
+				t.Errorf("user has %d notes after reusing an expired key, want 1", n)
+			}
+		})
+	}
+}
+
+func TestUpdateNoteWithoutContent(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			alice := createTestUser(t, stores.Users, "alice@example.com")
+			things := createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "and three things", Things: []string{"tea", "sun", "a nap"}}, time.Now())
+			plain := createTestNote(t, stores.Notes, types.Note{UserID: alice.ID, Content: "just words"}, time.Now())
+
+			tests := []struct {
+				name string
+				note types.Note
+				want int
+			}{
+				{name: "only things left", note: things, want: http.StatusOK},
+				{name: "nothing left", note: plain, want: http.StatusUnprocessableEntity},
+			}
+			for _, tt := range tests {
+				req := newRequest(http.MethodPut, "/note", url.Values{"content": {""}})
+				rec := call(updateNote(stores.Notes, NewEventHub()), &alice, req, "id", fmt.Sprint(tt.note.ID))
+				if rec.Code != tt.want {
+					t.Errorf("%s: clearing the content answered %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+				}
+			}
+
+			note, err := stores.Notes.GetForUser(t.Context(), things.ID, alice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if note.Content != "" || len(note.Things) != 3 {
+				t.Errorf("note is %q with things %v after clearing its content", note.Content, note.Things)
+			}
+		})
+	}
+}
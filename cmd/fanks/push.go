@@ -1,182 +1,267 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	errs "errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	webpush "github.com/SherClockHolmes/webpush-go"
 	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
 	"github.com/oliverisaac/fanks/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
-var triggerPushChan = make(chan uint)
+const (
+	// The daily reminder goes out at 9pm in reminderTimezone
+	reminderTimezone = "America/Chicago"
+	reminderHour     = 21
+)
+
+const (
+	PushKindReminder = "reminder"
+	PushKindMemories = "memories"
+	PushKindTest     = "test"
+)
+
+func reminderMessage() types.PushMessage {
+	prompt := randomPrompt()
+	return types.PushMessage{
+		Title: "Fanks",
+		Body:  prompt,
+		URL:   fmt.Sprintf("/?prompt=%s", url.QueryEscape(prompt)),
+		Topic: "fanks-daily-reminder",
 
-func startNotificationWorker(cfg types.Config, db *gorm.DB) error {
-	loc, err := time.LoadLocation("America/Chicago")
+		Prompt: prompt,
+	}
+}
+
+// startNotificationWorker triggers the scheduled pushes
+func startNotificationWorker(workers *workerGroup, queue *pushQueue) error {
+	loc, err := time.LoadLocation(reminderTimezone)
 	if err != nil {
 		return (errors.Wrap(err, "loading location"))
 	}
-	ticker := time.NewTicker(1 * time.Minute)
-	go func() {
-		for range ticker.C {
-			now := time.Now()
-			if now.In(loc).Hour() == 21 && now.In(loc).Minute() == 00 {
-				triggerPushChan <- 0
-			}
+	workers.Every("notifications", 1*time.Minute, false, func(ctx context.Context) {
+		now := time.Now().In(loc)
+		kind := ""
+		if now.Hour() == reminderHour && now.Minute() == 00 {
+			kind = PushKindReminder
 		}
-	}()
-
-	go func() {
-		for triggerUID := range triggerPushChan {
-			logrus.Info("Trigging push notifications for all users")
-			users, err := getAllUsersWithSubscriptions(db)
-			if err != nil {
-				logrus.Error(errors.Wrap(err, "getting all users"))
-				continue
-			}
-
-			for _, user := range users {
-				if triggerUID > 0 && triggerUID != user.ID {
-					continue
-				}
-				err := sendPushNotificationToUser(cfg, db, user)
-				if err != nil {
-					logrus.Error(errors.Wrap(err, "sending push notification"))
-				}
-			}
+		if now.Weekday() == time.Sunday && now.Hour() == 10 && now.Minute() == 00 {
+			kind = PushKindMemories
 		}
-	}()
+		if kind == "" {
+			return
+		}
+		if _, err := queue.Trigger(ctx, kind, 0); err != nil {
+			logrus.Error(errors.Wrapf(err, "triggering %s push notifications", kind))
+		}
+	})
 	return nil
 }
 
-func getAllUsersWithSubscriptions(db *gorm.DB) ([]types.User, error) {
-	var users []types.User
-	err := db.Preload("PushSubscriptions").Find(&users).Error
-	return users, err
+// triggerPushes sends a push to the admin's own devices. It answers as soon as
+// the batch is recorded, with the URL its progress can be polled at.
+func triggerPushes(queue *pushQueue) echo.HandlerFunc {
+	return withAdmin(func(c echo.Context, user types.User) error {
+		kind := c.FormValue("kind")
+		if kind != PushKindMemories {
+			kind = PushKindReminder
+		}
+		batch, err := queue.Trigger(c.Request().Context(), kind, user.ID)
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/push/trigger/%d", batch.ID))
+		return c.String(http.StatusAccepted, fmt.Sprintf("Triggered pushes, job %d", batch.ID))
+	})
 }
 
-func triggerPushes() echo.HandlerFunc {
-	return func(c echo.Context) error {
-		user, ok := GetSessionUser(c)
-		if !ok {
-			return c.String(http.StatusUnauthorized, "unauthorized")
+// queueRemindersForUser queues the daily reminder, with a different prompt for
+// each device and notification channel
+func queueRemindersForUser(db *gorm.DB, batch types.PushBatch, user types.User) error {
+	return queueRemindersForUserAt(db, batch, user, time.Now())
+}
+
+// queueRemindersForUserAt queues reminders which are not sent before the given
+// time, unless the user skipped the reminder of that day
+func queueRemindersForUserAt(db *gorm.DB, batch types.PushBatch, user types.User, at time.Time) error {
+	if remindersSkipped(user, at) {
+		return nil
+	}
+	for _, sub := range user.PushSubscriptions {
+		if err := enqueuePushAt(db, batch, sub, reminderMessage(), at); err != nil {
+			return err
 		}
-		if user.Role != "admin" {
-			return c.String(http.StatusUnauthorized, "unauthorized, must be admin")
+	}
+	for _, channel := range user.NotificationChannels {
+		if err := enqueueChannelPush(db, batch, channel, reminderMessage(), at); err != nil {
+			return err
 		}
-		triggerPushChan <- user.ID
-		fmt.Fprintln(c.Response().Writer, "Triggered pushes")
-		return nil
 	}
+	return nil
 }
 
-func sendPushNotificationToUser(cfg types.Config, db *gorm.DB, user types.User) error {
-	logrus := logrus.WithField("user", user.Name)
-	for _, subData := range user.PushSubscriptions {
-		logrus := logrus.WithField("subdata", subData.ID)
-		sub := &webpush.Subscription{
-			Endpoint: subData.Endpoint,
-			Keys: webpush.Keys{
-				P256dh: subData.P256DH,
-				Auth:   subData.Auth,
-			},
-		}
-
-		prompt := randomPrompt()
-		pushPayload, err := json.Marshal(map[string]interface{}{
-			"title": "Fanks",
-			"body":  prompt,
-			"icon":  fmt.Sprintf("https://%s/static/icon-192.png", cfg.Hostname),
-			"badge": fmt.Sprintf("https://%s/static/badge-128.png", cfg.Hostname),
-			"data": map[string]string{
-				"url": fmt.Sprintf("/?prompt=%s", url.QueryEscape(prompt)),
-			},
-		})
-		if err != nil {
-			return errors.Wrap(err, "marshalling push payload")
-		}
+// sendPush makes one attempt at delivering a message to a subscription, signed
+// with the VAPID key the device subscribed with. It gives up when ctx is done.
+func sendPush(ctx context.Context, cfg types.Config, subData types.PushSubscription, msg types.PushMessage) pushResult {
+	sub := &webpush.Subscription{
+		Endpoint: subData.Endpoint,
+		Keys: webpush.Keys{
+			P256dh: subData.P256DH,
+			Auth:   subData.Auth,
+		},
+	}
 
-		logrus.Debugf("sending push notification: %s", string(pushPayload))
-		resp, err := webpush.SendNotification(pushPayload, sub, &webpush.Options{
-			Topic:           "fanks-daily-reminder",
-			VAPIDPublicKey:  cfg.VapidPublicKey,
-			VAPIDPrivateKey: cfg.VapidPrivateKey,
-			TTL:             24 * 3600 * 7, // 7 days
-			Urgency:         webpush.UrgencyNormal,
-		})
-		if err != nil {
-			return errors.Wrap(err, "sending push notification")
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != 201 {
-			var deleteErr error
-			if resp.StatusCode == 410 {
-				logrus.Info("Subscriber no longer active")
-				deleteErr = db.Delete(subData).Error
-			}
-			return errs.Join(fmt.Errorf("Got status code %d", resp.StatusCode), deleteErr)
-		}
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return errors.Wrap(err, "Reading response body from push notifications")
-		}
+	payload := map[string]interface{}{
+		"title": msg.Title,
+		"body":  msg.Body,
+		"icon":  fmt.Sprintf("https://%s/static/icon-192.png", cfg.Hostname),
+		"badge": fmt.Sprintf("https://%s/static/badge-128.png", cfg.Hostname),
+		"data": map[string]string{
+			"url": msg.URL,
+		},
+	}
+	if msg.Prompt != "" {
+		addReminderActions(payload, cfg, subData.UserID, msg, time.Now())
+	}
+	if subData.VapidPublicKey != cfg.VapidPublicKey {
+		// The service worker moves the device over to the current key
+		payload["resubscribe"] = cfg.VapidPublicKey
+	}
+	privateKey, _ := cfg.VapidPrivateKeyFor(subData.VapidPublicKey)
 
-		logrus.Debugf("Got resp body (%d): %s", resp.StatusCode, string(respBody))
+	pushPayload, err := json.Marshal(payload)
+	if err != nil {
+		return pushResult{Err: errors.Wrap(err, "marshalling push payload")}
+	}
 
-		logrus.Info("Sent push notification to user")
+	logrus.Debugf("sending push notification: %s", string(pushPayload))
+	resp, err := webpush.SendNotificationWithContext(ctx, pushPayload, sub, &webpush.Options{
+		Topic:           msg.Topic,
+		VAPIDPublicKey:  subData.VapidPublicKey,
+		VAPIDPrivateKey: privateKey,
+		TTL:             24 * 3600 * 7, // 7 days
+		Urgency:         webpush.UrgencyNormal,
+	})
+	if err != nil {
+		return pushResult{Err: errors.Wrap(err, "sending push notification")}
+	}
+	defer resp.Body.Close()
+
+	// Push services explain errors in the body, only a little of it is kept
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if err != nil {
+		return pushResult{StatusCode: resp.StatusCode, Err: errors.Wrap(err, "Reading response body from push notifications")}
+	}
+	logrus.Debugf("Got resp body (%d): %s", resp.StatusCode, string(respBody))
+
+	return pushResult{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Body:       strings.TrimSpace(string(respBody)),
 	}
-	return nil
 }
 
-func removeSubscription(db *gorm.DB) echo.HandlerFunc {
+// removeSubscription stops notifications to the device with the given
+// endpoint, or to all of the user's devices if no endpoint is given
+func removeSubscription(subs store.SubscriptionStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user, ok := GetSessionUser(c)
 		if !ok {
 			return c.String(http.StatusUnauthorized, "unauthorized")
 		}
 
-		if err := db.Delete(user.PushSubscriptions).Error; err != nil {
-			return errors.Wrap(err, "removing subscription")
+		var req struct {
+			Endpoint string `json:"endpoint" form:"endpoint"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, "invalid request")
+		}
+
+		if req.Endpoint == "" {
+			if err := subs.DeleteForUser(c.Request().Context(), user.ID); err != nil {
+				return err
+			}
+			return c.String(http.StatusOK, "subscriptions removed")
 		}
 
+		err := subs.DeleteByEndpoint(c.Request().Context(), user.ID, req.Endpoint)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "subscription not found")
+		} else if err != nil {
+			return err
+		}
 		return c.String(http.StatusOK, "subscription removed")
 	}
 }
-func saveSubscription(db *gorm.DB) echo.HandlerFunc {
+
+// saveSubscription subscribes the device, subscribing again from the same
+// browser updates its existing subscription
+func saveSubscription(cfg types.Config, subs store.SubscriptionStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user, ok := GetSessionUser(c)
 		if !ok {
 			return c.String(http.StatusUnauthorized, "unauthorized")
 		}
 
-		var sub webpush.Subscription
+		var sub struct {
+			webpush.Subscription
+			// VapidPublicKey is the key the browser subscribed with, pages
+			// from before a key rotation may still use the previous key
+			VapidPublicKey string `json:"vapidPublicKey"`
+			// Replaces is the endpoint the device used before subscribing
+			// with a new key
+			Replaces string `json:"replaces"`
+		}
 		if err := c.Bind(&sub); err != nil {
 			return errors.Wrap(err, "binding subscription")
 		}
+		if sub.VapidPublicKey == "" {
+			sub.VapidPublicKey = cfg.VapidPublicKey
+		}
+		if _, ok := cfg.VapidPrivateKeyFor(sub.VapidPublicKey); !ok {
+			return c.String(http.StatusBadRequest, "subscribed with an unknown VAPID key, reload the page and subscribe again")
+		}
 
 		keys, err := json.Marshal(sub.Keys)
 		if err != nil {
 			return errors.Wrap(err, "marshalling subscription keys")
 		}
 
+		if sub.Endpoint == "" {
+			return c.String(http.StatusBadRequest, "subscription has no endpoint")
+		}
+
+		userAgent := c.Request().UserAgent()
 		pushSubscription := types.PushSubscription{
-			UserID:   user.ID,
-			Endpoint: sub.Endpoint,
-			P256DH:   sub.Keys.P256dh,
-			Auth:     sub.Keys.Auth,
-			Keys:     string(keys),
+			UserID:    user.ID,
+			Endpoint:  sub.Endpoint,
+			P256DH:    sub.Keys.P256dh,
+			Auth:      sub.Keys.Auth,
+			Keys:      string(keys),
+			Label:     deviceLabel(userAgent),
+			UserAgent: userAgent,
+
+			VapidPublicKey: sub.VapidPublicKey,
 		}
 
-		if err := db.Create(&pushSubscription).Error; err != nil {
-			return errors.Wrap(err, "saving subscription")
+		if err := subs.Save(c.Request().Context(), &pushSubscription); err != nil {
+			return err
+		}
+		if sub.Replaces != "" && sub.Replaces != sub.Endpoint {
+			err := subs.DeleteByEndpoint(c.Request().Context(), user.ID, sub.Replaces)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
 		}
 
 		return c.String(http.StatusOK, "subscription saved")
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	reminderTokenPurpose = "reminder"
+	// reminderTokenTTL outlives the push TTL, a reminder which arrives late
+	// can still be answered
+	reminderTokenTTL = 8 * 24 * time.Hour
+	reminderSnooze   = time.Hour
+)
+
+// addReminderActions lets the user write, snooze or skip the reminder from the
+// notification. The service worker has no session to rely on, so the actions
+// carry a token which is signed for the user when the push is sent.
+func addReminderActions(payload map[string]interface{}, cfg types.Config, userID uint, msg types.PushMessage, now time.Time) {
+	payload["actions"] = []map[string]string{
+		// Platforms with inline replies show a text box, others open the composer
+		{"action": "write", "title": "Write now", "type": "text", "placeholder": msg.Prompt},
+		{"action": "snooze", "title": "Snooze 1h"},
+		{"action": "skip", "title": "Skip today"},
+	}
+	payload["data"] = map[string]string{
+		"url":    msg.URL,
+		"prompt": msg.Prompt,
+		"token":  signToken(cfg.CookeSecret, reminderTokenPurpose, userID, now.Add(reminderTokenTTL)),
+	}
+}
+
+// withReminderToken runs fn as the user the bearer token of a reminder was
+// signed for
+func withReminderToken(cfg types.Config, users store.UserStore, fn func(c echo.Context, user types.User) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+		userID, err := verifyToken(cfg.CookeSecret, reminderTokenPurpose, token, time.Now())
+		if err != nil {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+		user, err := users.Get(c.Request().Context(), userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		} else if err != nil {
+			return err
+		}
+		return fn(c, user)
+	}
+}
+
+// replyToReminder saves the reply typed into the notification as a note
+func replyToReminder(cfg types.Config, notes store.NoteStore, users store.UserStore, hub *EventHub) echo.HandlerFunc {
+	return withReminderToken(cfg, users, func(c echo.Context, user types.User) error {
+		content := strings.TrimSpace(c.FormValue("content"))
+		if content == "" {
+			return c.String(http.StatusUnprocessableEntity, "you cannot have an empty note")
+		}
+
+		note := newNoteForUser(c.FormValue("prompt"), content, user)
+		note.Tags = noteTags(note.Content)
+		if err := notes.Create(c.Request().Context(), &note); err != nil {
+			return errors.Wrap(err, "Saving note to db")
+		}
+		notesCreated.WithLabelValues(NoteSourceNotification).Inc()
+		publishNoteEvent(notes, hub, NoteCreated, note)
+
+		return c.String(http.StatusCreated, "note saved")
+	})
+}
+
+// snoozeReminder sends the reminder to all of the user's devices and channels again in an hour
+func snoozeReminder(cfg types.Config, users store.UserStore, queue *pushQueue) echo.HandlerFunc {
+	return withReminderToken(cfg, users, func(c echo.Context, user types.User) error {
+		at := time.Now().Add(reminderSnooze)
+		if _, err := queue.RemindAt(c.Request().Context(), user, at); err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, "reminder snoozed until "+at.Format(time.Kitchen))
+	})
+}
+
+// skipReminder drops the reminders the user still has coming today, and keeps
+// today's reminder from being pushed or emailed if it has not gone out yet
+func skipReminder(cfg types.Config, users store.UserStore, queue *pushQueue) echo.HandlerFunc {
+	return withReminderToken(cfg, users, func(c echo.Context, user types.User) error {
+		loc, err := time.LoadLocation(reminderTimezone)
+		if err != nil {
+			return errors.Wrap(err, "loading location")
+		}
+		now := time.Now()
+		if err := users.SkipReminders(c.Request().Context(), user, endOfReminderDay(now, loc)); err != nil {
+			return err
+		}
+		if err := cancelReminders(queue.db.WithContext(c.Request().Context()), user.ID, now); err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, "reminder skipped")
+	})
+}
+
+// endOfReminderDay is midnight after now, the day of the daily reminder is
+// counted in loc
+func endOfReminderDay(now time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+}
+
+// remindersSkipped reports whether the user skipped the reminder due at
+func remindersSkipped(user types.User, at time.Time) bool {
+	return user.RemindersSkippedUntil != nil && at.Before(*user.RemindersSkippedUntil)
+}
+
+// RemindAt replaces the user's pending reminders with a new one for each of
+// their devices and channels which is sent at the given time. Asking for a
+// reminder undoes skipping today's.
+func (q *pushQueue) RemindAt(ctx context.Context, user types.User, at time.Time) (types.PushBatch, error) {
+	now := time.Now()
+	batch := types.PushBatch{Kind: PushKindReminder, UserID: user.ID, QueuedAt: &now}
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := cancelReminders(tx, user.ID, now); err != nil {
+			return err
+		}
+		if user.RemindersSkippedUntil != nil {
+			err := tx.Model(&types.User{}).Where("id = ?", user.ID).Update("reminders_skipped_until", nil).Error
+			if err != nil {
+				return errors.Wrap(err, "undoing skipped reminders")
+			}
+			user.RemindersSkippedUntil = nil
+		}
+		if err := tx.Create(&batch).Error; err != nil {
+			return errors.Wrap(err, "saving push batch")
+		}
+		return queueRemindersForUserAt(tx, batch, user, at)
+	})
+	if err != nil {
+		return batch, err
+	}
+	logrus.Infof("Snoozed reminders for user %d until %s", user.ID, at.Format(time.RFC3339))
+	return batch, nil
+}
+
+// cancelReminders cancels the user's reminders which are waiting to be sent
+// later, whether they were snoozed or are waiting for a retry
+func cancelReminders(db *gorm.DB, userID uint, now time.Time) error {
+	err := db.Model(&types.PushJob{}).Omit(clause.Associations).
+		Where("user_id = ? AND kind = ? AND status = ? AND next_attempt_at > ?", userID, PushKindReminder, types.PushJobPending, now).
+		Updates(map[string]interface{}{
+			"status":      types.PushJobCancelled,
+			"finished_at": now,
+		}).Error
+	return errors.Wrap(err, "cancelling reminders")
+}
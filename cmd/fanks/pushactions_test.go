@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestSkipReminder(t *testing.T) {
+	q, db, stores := newTestPushQueue(t)
+	q.cfg.CookeSecret = []byte("secret")
+	loc, err := time.LoadLocation(reminderTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := types.User{Name: "Alice", Email: "alice@example.com", EmailReminders: true}
+	if err := stores.Users.Create(t.Context(), &alice); err != nil {
+		t.Fatal(err)
+	}
+	createTestSubscription(t, q, alice, "https://push.example.com/alice")
+
+	action := func(h echo.HandlerFunc) {
+		t.Helper()
+		req := newRequest(http.MethodPost, "/push/actions", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+signToken(q.cfg.CookeSecret, reminderTokenPurpose, alice.ID, time.Now().Add(time.Hour)))
+		if rec := call(h, nil, req); rec.Code != http.StatusOK {
+			t.Fatalf("action answered %d: %s", rec.Code, rec.Body)
+		}
+	}
+	pending := func() int64 {
+		t.Helper()
+		var n int64
+		if err := db.Model(&types.PushJob{}).Where("user_id = ? AND status = ?", alice.ID, types.PushJobPending).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	reload := func() types.User {
+		t.Helper()
+		user, err := stores.Users.Get(t.Context(), alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	action(skipReminder(q.cfg, stores.Users, q))
+
+	// Today's 9pm push is not queued
+	if _, err := q.Trigger(t.Context(), PushKindReminder, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.queueBatches(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("%d reminders queued on the skipped day, want 0", n)
+	}
+
+	// Neither is today's email, but tomorrow's is sent
+	mailer := &fakeMailer{}
+	today := lastReminderTime(endOfReminderDay(time.Now(), loc).Add(-time.Minute), loc)
+	for _, now := range []time.Time{today.Add(time.Minute), today.AddDate(0, 0, 1).Add(time.Minute)} {
+		if err := sendDueReminderEmails(t.Context(), q.cfg, stores.Users, mailer, now, loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := mailer.Sent(); len(sent) != 1 {
+		t.Errorf("sent %d reminder emails over the skipped day and the next, want 1", len(sent))
+	}
+
+	// Tomorrow's push is queued as usual
+	batch := types.PushBatch{Kind: PushKindReminder}
+	if err := db.Create(&batch).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := queueRemindersForUserAt(db, batch, reload(), today.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if n := pending(); n != 1 {
+		t.Errorf("%d reminders queued the day after skipping, want 1", n)
+	}
+
+	// Snoozing a reminder afterwards asks for it after all
+	action(snoozeReminder(q.cfg, stores.Users, q))
+	if n := pending(); n != 1 {
+		t.Errorf("%d reminders pending after snoozing, want the snoozed one", n)
+	}
+	if user := reload(); user.RemindersSkippedUntil != nil {
+		t.Errorf("reminders are still skipped until %s after snoozing", user.RemindersSkippedUntil)
+	}
+}
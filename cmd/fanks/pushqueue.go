@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/notify"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxPushAttempts is how often a job is tried before it is dead-lettered
+	maxPushAttempts   = 8
+	pushRetryBase     = 30 * time.Second
+	pushRetryMax      = 6 * time.Hour
+	pushQueueInterval = 15 * time.Second
+	pushQueueBatch    = 100
+	// Finished jobs are kept around this long for the admin page
+	pushJobRetention = 7 * 24 * time.Hour
+)
+
+// pushResult is how the push service answered one delivery attempt
+type pushResult struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+	Err        error
+}
+
+// pushBackoff is the delay before the next attempt after the given number of
+// failed attempts: 30s, 1m, 2m and so on up to pushRetryMax
+func pushBackoff(attempts int) time.Duration {
+	delay := pushRetryBase
+	for i := 1; i < attempts && delay < pushRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, pushRetryMax)
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of
+// seconds or an HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func finishPushJob(job *types.PushJob, status string, now time.Time) {
+	job.Status = status
+	job.FinishedAt = &now
+}
+
+// recordPushAttempt updates the job with the result of an attempt. It returns
+// true if the push service no longer knows the subscription.
+func recordPushAttempt(job *types.PushJob, res pushResult, now time.Time) bool {
+	job.Attempts++
+	job.LastStatusCode = res.StatusCode
+
+	switch {
+	case res.Err != nil:
+		job.LastError = res.Err.Error()
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		job.LastError = ""
+		finishPushJob(job, types.PushJobSent, now)
+		return false
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		job.LastError = "subscription expired"
+		finishPushJob(job, types.PushJobGone, now)
+		return true
+	case res.StatusCode == http.StatusRequestEntityTooLarge:
+		// Sending the same payload again will not make it smaller
+		job.LastError = "payload too large"
+		finishPushJob(job, types.PushJobDead, now)
+		return false
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusRequestTimeout || res.StatusCode >= 500:
+		job.LastError = fmt.Sprintf("status %d: %s", res.StatusCode, res.Body)
+	default:
+		job.LastError = fmt.Sprintf("rejected with status %d: %s", res.StatusCode, res.Body)
+		finishPushJob(job, types.PushJobDead, now)
+		return false
+	}
+
+	if job.Attempts >= maxPushAttempts {
+		finishPushJob(job, types.PushJobDead, now)
+		return false
+	}
+	job.NextAttemptAt = now.Add(max(pushBackoff(job.Attempts), res.RetryAfter))
+	return false
+}
+
+// enqueuePush queues a message for one of the user's devices
+func enqueuePush(db *gorm.DB, batch types.PushBatch, sub types.PushSubscription, msg types.PushMessage) error {
+	return enqueuePushAt(db, batch, sub, msg, time.Now())
+}
+
+// enqueuePushAt queues a message which is not sent before the given time
+func enqueuePushAt(db *gorm.DB, batch types.PushBatch, sub types.PushSubscription, msg types.PushMessage, at time.Time) error {
+	job := types.PushJob{
+		UserID:         sub.UserID,
+		BatchID:        &batch.ID,
+		SubscriptionID: sub.ID,
+		Kind:           batch.Kind,
+		Message:        msg,
+		Status:         types.PushJobPending,
+		NextAttemptAt:  at,
+	}
+	return errors.Wrap(db.Omit(clause.Associations).Create(&job).Error, "queueing push")
+}
+
+// pushQueue fans triggered batches out into jobs and delivers the due jobs on
+// a bounded pool of workers in the background
+type pushQueue struct {
+	cfg      types.Config
+	db       *gorm.DB
+	notes    store.NoteStore
+	users    store.UserStore
+	subs     store.SubscriptionStore
+	channels store.ChannelStore
+	// channelClient sends to notification channels, which users point at URLs
+	// of their own choosing
+	channelClient *http.Client
+	wake          chan struct{}
+	// jobs is never closed, the stopped queue loop may still be dispatching
+	// when stopping the workers timed out. Flush closes flushed instead.
+	jobs    chan *types.PushJob
+	flushed chan struct{}
+
+	mu sync.Mutex
+	// inFlight holds the IDs of the jobs handed to a worker which it has not
+	// saved yet, so they are not handed out twice
+	inFlight map[uint]struct{}
+}
+
+func newPushQueue(cfg types.Config, db *gorm.DB, stores store.Stores) *pushQueue {
+	return &pushQueue{
+		cfg:           cfg,
+		db:            db,
+		notes:         stores.Notes,
+		users:         stores.Users,
+		subs:          stores.Subscriptions,
+		channels:      stores.Channels,
+		channelClient: notify.NewClient(cfg.Push.Timeout),
+		wake:          make(chan struct{}, 1),
+		jobs:          make(chan *types.PushJob),
+		flushed:       make(chan struct{}),
+
+		inFlight: map[uint]struct{}{},
+	}
+}
+
+// startPushQueue runs the queue until the workers are stopped. Sends which are
+// in flight then are seen through and recorded, the jobs which were not
+// started yet are tried by Flush or on the next start.
+func startPushQueue(workers *workerGroup, cfg types.Config, db *gorm.DB, stores store.Stores) *pushQueue {
+	q := newPushQueue(cfg, db, stores)
+	for i := range cfg.Push.Workers {
+		workers.Go(fmt.Sprintf("push-sender-%d", i+1), q.work)
+	}
+	workers.Loop("push-queue", pushQueueInterval, true, q.wake, func(ctx context.Context) {
+		if err := q.queueBatches(ctx); err != nil {
+			logrus.Error(errors.Wrap(err, "queueing push batches"))
+		}
+		if err := q.runDue(ctx, time.Now()); err != nil {
+			logrus.Error(errors.Wrap(err, "running push queue"))
+		}
+	})
+	return q
+}
+
+// Wake makes the queue look for new batches and due jobs right away
+func (q *pushQueue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Flush queues the batches which were triggered and attempts the jobs which
+// are due once more on a pool of its own. It is used on shutdown after the
+// queue has been stopped, the queue cannot be used afterwards.
+func (q *pushQueue) Flush(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for range q.cfg.Push.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	defer wg.Wait()
+	defer close(q.flushed)
+
+	if err := q.queueBatches(ctx); err != nil {
+		return errors.Wrap(err, "queueing push batches")
+	}
+	return errors.Wrap(q.runDue(ctx, time.Now()), "running push queue")
+}
+
+// SendTo queues a message for a single device
+func (q *pushQueue) SendTo(ctx context.Context, sub types.PushSubscription, kind string, msg types.PushMessage) (types.PushBatch, error) {
+	now := time.Now()
+	batch := types.PushBatch{Kind: kind, UserID: sub.UserID, QueuedAt: &now}
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&batch).Error; err != nil {
+			return errors.Wrap(err, "saving push batch")
+		}
+		return enqueuePush(tx, batch, sub, msg)
+	})
+	if err != nil {
+		return batch, err
+	}
+	q.Wake()
+	return batch, nil
+}
+
+// Trigger records a batch of pushes of the given kind and returns without
+// waiting for it to be queued. A userID of 0 sends to every user.
+func (q *pushQueue) Trigger(ctx context.Context, kind string, userID uint) (types.PushBatch, error) {
+	batch := types.PushBatch{Kind: kind, UserID: userID}
+	if err := q.db.WithContext(ctx).Create(&batch).Error; err != nil {
+		return batch, errors.Wrap(err, "saving push batch")
+	}
+	q.Wake()
+	return batch, nil
+}
+
+// queueBatches queues a job for each device the batches which were triggered
+// since the last run are sent to
+func (q *pushQueue) queueBatches(ctx context.Context) error {
+	batches := []types.PushBatch{}
+	if err := q.db.WithContext(ctx).Where("queued_at IS NULL").Order("id").Find(&batches).Error; err != nil {
+		return errors.Wrap(err, "finding push batches")
+	}
+	if len(batches) == 0 {
+		return nil
+	}
+
+	users, err := q.users.ListWithSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		logrus.Infof("Trigging %s push notifications for batch %d", batch.Kind, batch.ID)
+		recipients := batchRecipients(batch, users)
+
+		// The note store is not part of the transaction below, so the
+		// memories are looked up before it starts
+		memories := map[uint]types.Memories{}
+		if batch.Kind == PushKindMemories {
+			for _, user := range recipients {
+				memories[user.ID], err = getMemories(ctx, q.notes, user.ID, time.Now())
+				if err != nil {
+					return errors.Wrapf(err, "finding memories for user %d", user.ID)
+				}
+			}
+		}
+
+		// The jobs and queued_at are saved together so a batch is never queued twice
+		err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for _, user := range recipients {
+				var err error
+				switch batch.Kind {
+				case PushKindMemories:
+					err = queueMemoriesForUser(tx, batch, user, memories[user.ID])
+				default:
+					err = queueRemindersForUser(tx, batch, user)
+				}
+				if err != nil {
+					return errors.Wrapf(err, "queueing push notification for user %d", user.ID)
+				}
+			}
+			return errors.Wrap(tx.Model(&batch).Update("queued_at", time.Now()).Error, "saving push batch")
+		})
+		if err != nil {
+			return errors.Wrapf(err, "queueing push batch %d", batch.ID)
+		}
+	}
+	return nil
+}
+
+// batchRecipients returns the users a batch is sent to. Batches for every
+// user only send memories to those who asked for them.
+func batchRecipients(batch types.PushBatch, users []types.User) []types.User {
+	ret := []types.User{}
+	for _, user := range users {
+		if batch.UserID > 0 && batch.UserID != user.ID {
+			continue
+		}
+		if batch.Kind == PushKindMemories && batch.UserID == 0 && !user.WeeklyMemories {
+			continue
+		}
+		ret = append(ret, user)
+	}
+	return ret
+}
+
+// runDue hands every job which is due to the workers and drops old finished
+// jobs. It only waits for the workers when all of them are busy, so a slow
+// push service holds up the queue only once it ties up the whole pool.
+func (q *pushQueue) runDue(ctx context.Context, now time.Time) error {
+	for ctx.Err() == nil {
+		query := q.db.WithContext(ctx).
+			Where("status = ? AND next_attempt_at <= ?", types.PushJobPending, now)
+		if busy := q.inFlightIDs(); len(busy) > 0 {
+			query = query.Where("id NOT IN ?", busy)
+		}
+		jobs := []types.PushJob{}
+		err := query.Order("next_attempt_at").Limit(pushQueueBatch).Find(&jobs).Error
+		if err != nil {
+			return errors.Wrap(err, "finding due push jobs")
+		}
+
+		for i := range jobs {
+			if !q.dispatch(ctx, &jobs[i]) {
+				return nil
+			}
+		}
+		if len(jobs) < pushQueueBatch {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	err := q.db.WithContext(ctx).Unscoped().
+		Where("status <> ? AND finished_at < ?", types.PushJobPending, now.Add(-pushJobRetention)).
+		Delete(&types.PushJob{}).Error
+	return errors.Wrap(err, "removing old push jobs")
+}
+
+func (q *pushQueue) inFlightIDs() []uint {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]uint, 0, len(q.inFlight))
+	for id := range q.inFlight {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// dispatch waits for a free worker and hands it the job, it returns false if
+// ctx is done or the queue is flushed first
+func (q *pushQueue) dispatch(ctx context.Context, job *types.PushJob) bool {
+	// select picks at random when a worker is free as well
+	if ctx.Err() != nil {
+		return false
+	}
+
+	q.mu.Lock()
+	q.inFlight[job.ID] = struct{}{}
+	q.mu.Unlock()
+
+	select {
+	case q.jobs <- job:
+		return true
+	case <-ctx.Done():
+	case <-q.flushed:
+	}
+	q.done(job)
+	return false
+}
+
+func (q *pushQueue) done(job *types.PushJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, job.ID)
+}
+
+// work attempts the jobs it is handed until ctx is done or the queue is flushed
+func (q *pushQueue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.flushed:
+			return
+		case job := <-q.jobs:
+			if err := q.attempt(ctx, job, time.Now()); err != nil {
+				logrus.Error(errors.Wrapf(err, "attempting push job %d", job.ID))
+			}
+			q.done(job)
+		}
+	}
+}
+
+func (q *pushQueue) attempt(ctx context.Context, job *types.PushJob, now time.Time) error {
+	if ctx.Err() != nil {
+		// Shutting down before the job was started, it is left for Flush
+		return nil
+	}
+	// Once started the attempt is not cancelled by shutting down, otherwise a
+	// push which was delivered would stay pending and be sent again. Sends
+	// are bounded by the push timeout.
+	ctx = context.WithoutCancel(ctx)
+	logrus := logrus.WithField("job", job.ID)
+
+	var err error
+	if job.ChannelID != nil {
+		err = q.attemptChannel(ctx, logrus.WithField("channel", *job.ChannelID), job, now)
+	} else {
+		err = q.attemptPush(ctx, logrus.WithField("subscription", job.SubscriptionID), job, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch job.Status {
+	case types.PushJobSent:
+		logrus.Info("Sent push notification to user")
+	case types.PushJobDead:
+		logrus.Errorf("Giving up on push job after %d attempts: %s", job.Attempts, job.LastError)
+	case types.PushJobPending:
+		logrus.Warnf("Push attempt %d failed, retrying at %s: %s", job.Attempts, job.NextAttemptAt.Format(time.RFC3339), job.LastError)
+	}
+	return errors.Wrap(q.db.WithContext(ctx).Omit(clause.Associations).Save(job).Error, "saving push job")
+}
+
+// attemptPush sends the job to its device with web push
+func (q *pushQueue) attemptPush(ctx context.Context, logrus *logrus.Entry, job *types.PushJob, now time.Time) error {
+	sub, err := q.subs.Get(ctx, job.SubscriptionID)
+	if errors.Is(err, store.ErrNotFound) {
+		job.LastError = "subscription was removed"
+		finishPushJob(job, types.PushJobGone, now)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, ok := q.cfg.VapidPrivateKeyFor(sub.VapidPublicKey); !ok {
+		// The device has to subscribe again with the current key
+		job.LastError = "subscribed with a VAPID key which is no longer configured"
+		finishPushJob(job, types.PushJobGone, now)
+		if err := q.subs.Delete(ctx, sub); err != nil {
+			logrus.Error(err)
+		}
+		return nil
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, q.cfg.Push.Timeout)
+	res := sendPush(sendCtx, q.cfg, sub, job.Message)
+	cancel()
+	gone := recordPushAttempt(job, res, now)
+	pushSends.WithLabelValues("webpush", pushOutcome(job)).Inc()
+	if gone {
+		logrus.Info("Subscriber no longer active")
+		if err := q.subs.Delete(ctx, sub); err != nil {
+			logrus.Error(err)
+		}
+	}
+	if job.Status == types.PushJobSent {
+		if err := q.subs.MarkSucceeded(ctx, sub, now); err != nil {
+			logrus.Error(err)
+		}
+	}
+	return nil
+}
+
+// pushBatchProgress is the answer to polling a triggered batch. Status is
+// queueing until a job is queued for every device, then sending until no job
+// is pending any more and then done.
+type pushBatchProgress struct {
+	ID     uint             `json:"id"`
+	Kind   string           `json:"kind"`
+	Status string           `json:"status"`
+	Jobs   int64            `json:"jobs"`
+	Counts map[string]int64 `json:"counts"`
+}
+
+// pushBatchStatus reports how far the delivery of a triggered batch got
+func pushBatchStatus(jobs store.PushJobStore) echo.HandlerFunc {
+	return withAdmin(func(c echo.Context, user types.User) error {
+		id, err := parseUintParam(c, "id")
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid push batch")
+		}
+		batch, err := jobs.GetBatch(c.Request().Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "push batch not found")
+		} else if err != nil {
+			return err
+		}
+
+		counts, err := jobs.CountByStatus(c.Request().Context(), batch.ID)
+		if err != nil {
+			return err
+		}
+		progress := pushBatchProgress{ID: batch.ID, Kind: batch.Kind, Status: "done", Counts: counts}
+		for _, count := range counts {
+			progress.Jobs += count
+		}
+		switch {
+		case batch.QueuedAt == nil:
+			progress.Status = "queueing"
+		case counts[types.PushJobPending] > 0:
+			progress.Status = "sending"
+		}
+		return c.JSON(http.StatusOK, progress)
+	})
+}
+
+// retryPushJob gives a dead-lettered job a fresh set of attempts
+func retryPushJob(jobs store.PushJobStore, queue *pushQueue) echo.HandlerFunc {
+	return withAdmin(func(c echo.Context, user types.User) error {
+		id, err := parseUintParam(c, "id")
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid push job")
+		}
+		err = jobs.Retry(c.Request().Context(), id, time.Now())
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "dead push job not found")
+		} else if err != nil {
+			return err
+		}
+
+		queue.Wake()
+		return c.Redirect(http.StatusSeeOther, "/admin")
+	})
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/vapid"
+	"gorm.io/gorm"
+)
+
+func TestPushBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		10: 4*time.Hour + 16*time.Minute,
+		11: 6 * time.Hour,
+		50: 6 * time.Hour,
+	}
+	for attempts, want := range tests {
+		if got := pushBackoff(attempts); got != want {
+			t.Errorf("pushBackoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, time.June, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{header: "", want: 0},
+		{header: "120", want: 2 * time.Minute},
+		{header: "0", want: 0},
+		{header: "-5", want: 0},
+		{header: "soon", want: 0},
+		{header: now.Add(10 * time.Minute).Format(http.TimeFormat), want: 10 * time.Minute},
+		{header: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRecordPushAttempt(t *testing.T) {
+	now := time.Date(2026, time.June, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		attempts  int
+		res       pushResult
+		status    string
+		lastError string
+		next      time.Time
+		gone      bool
+	}{
+		{name: "delivered", res: pushResult{StatusCode: http.StatusCreated}, status: types.PushJobSent},
+		{name: "subscription not found", res: pushResult{StatusCode: http.StatusNotFound}, status: types.PushJobGone, lastError: "subscription expired", gone: true},
+		{name: "subscription gone", res: pushResult{StatusCode: http.StatusGone}, status: types.PushJobGone, lastError: "subscription expired", gone: true},
+		{name: "payload too large", res: pushResult{StatusCode: http.StatusRequestEntityTooLarge}, status: types.PushJobDead, lastError: "payload too large"},
+		{name: "rejected", res: pushResult{StatusCode: http.StatusBadRequest, Body: "bad vapid"}, status: types.PushJobDead, lastError: "rejected with status 400: bad vapid"},
+		{name: "network error", res: pushResult{Err: errors.New("connection refused")}, status: types.PushJobPending, lastError: "connection refused", next: now.Add(30 * time.Second)},
+		{name: "server error backs off", attempts: 2, res: pushResult{StatusCode: http.StatusBadGateway, Body: "oops"}, status: types.PushJobPending, lastError: "status 502: oops", next: now.Add(2 * time.Minute)},
+		{name: "rate limited waits for retry-after", res: pushResult{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}, status: types.PushJobPending, lastError: "status 429: ", next: now.Add(time.Hour)},
+		{name: "retry-after shorter than the backoff", attempts: 4, res: pushResult{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Second}, status: types.PushJobPending, lastError: "status 503: ", next: now.Add(8 * time.Minute)},
+		{name: "timeout", res: pushResult{StatusCode: http.StatusRequestTimeout}, status: types.PushJobPending, lastError: "status 408: ", next: now.Add(30 * time.Second)},
+		{name: "last attempt is dead-lettered", attempts: maxPushAttempts - 1, res: pushResult{StatusCode: http.StatusInternalServerError}, status: types.PushJobDead, lastError: "status 500: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := types.PushJob{Status: types.PushJobPending, Attempts: tt.attempts, LastError: "earlier failure"}
+			gone := recordPushAttempt(&job, tt.res, now)
+			if gone != tt.gone {
+				t.Errorf("gone = %v, want %v", gone, tt.gone)
+			}
+			if job.Attempts != tt.attempts+1 || job.LastStatusCode != tt.res.StatusCode {
+				t.Errorf("job has %d attempts and status code %d", job.Attempts, job.LastStatusCode)
+			}
+			if job.Status != tt.status || job.LastError != tt.lastError {
+				t.Errorf("job is %s with error %q, want %s with %q", job.Status, job.LastError, tt.status, tt.lastError)
+			}
+			if tt.status == types.PushJobPending {
+				if !job.NextAttemptAt.Equal(tt.next) || job.FinishedAt != nil {
+					t.Errorf("job is retried at %s, want %s", job.NextAttemptAt, tt.next)
+				}
+			} else if job.FinishedAt == nil || !job.FinishedAt.Equal(now) {
+				t.Errorf("finished job has FinishedAt %v, want %s", job.FinishedAt, now)
+			}
+		})
+	}
+}
+
+// fakePushService answers the pushes to each path with the given responses
+// in turn, repeating the last one
+type fakePushService struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string][]fakePushResponse
+	received  map[string]int
+}
+
+type fakePushResponse struct {
+	status     int
+	retryAfter string
+}
+
+func newFakePushService(t *testing.T, responses map[string][]fakePushResponse) *fakePushService {
+	f := &fakePushService{responses: responses, received: map[string]int{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.Header.Get("Authorization") == "" || r.Header.Get("Content-Encoding") != "aes128gcm" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		n := f.received[r.URL.Path]
+		f.received[r.URL.Path]++
+		answers := f.responses[r.URL.Path]
+		if len(answers) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res := answers[min(n, len(answers)-1)]
+		if res.retryAfter != "" {
+			w.Header().Set("Retry-After", res.retryAfter)
+		}
+		w.WriteHeader(res.status)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakePushService) Received(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.received[path]
+}
+
+// newTestPushQueue returns a queue with fresh VAPID keys whose workers are
+// not started, along with its stores
+func newTestPushQueue(t *testing.T) (*pushQueue, *gorm.DB, store.Stores) {
+	t.Helper()
+	publicKey, privateKey, err := vapid.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := types.Config{
+		Hostname:        "fanks.example.com",
+		VapidPublicKey:  publicKey,
+		VapidPrivateKey: privateKey,
+		Push:            types.PushConfig{Workers: 2, Timeout: 5 * time.Second},
+	}
+	db := newTestDB(t, "sqlite")
+	stores := store.NewGORM(db)
+	return newPushQueue(cfg, db, stores), db, stores
+}
+
+// createTestSubscription saves a device of the user subscribed at the endpoint
+// with keys the push can be encrypted for
+func createTestSubscription(t *testing.T, q *pushQueue, user types.User, endpoint string) types.PushSubscription {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	sub := types.PushSubscription{
+		UserID:         user.ID,
+		Endpoint:       endpoint,
+		P256DH:         base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		Auth:           base64.RawURLEncoding.EncodeToString(auth),
+		VapidPublicKey: q.cfg.VapidPublicKey,
+	}
+	if err := q.subs.Save(t.Context(), &sub); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func queueTestPush(t *testing.T, q *pushQueue, sub types.PushSubscription, at time.Time) types.PushJob {
+	t.Helper()
+	batch := types.PushBatch{Kind: PushKindReminder, UserID: sub.UserID, QueuedAt: &at}
+	if err := q.db.Create(&batch).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := enqueuePushAt(q.db, batch, sub, types.PushMessage{Title: "Fanks", Body: "What are you grateful for?"}, at); err != nil {
+		t.Fatal(err)
+	}
+	var job types.PushJob
+	if err := q.db.Last(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestPushQueueAttempts(t *testing.T) {
+	q, db, stores := newTestPushQueue(t)
+	service := newFakePushService(t, map[string][]fakePushResponse{
+		"/busy":   {{status: http.StatusTooManyRequests, retryAfter: "600"}, {status: http.StatusCreated}},
+		"/gone":   {{status: http.StatusGone}},
+		"/broken": {{status: http.StatusInternalServerError}},
+		"/bad":    {{status: http.StatusBadRequest}},
+	})
+	user := createTestUser(t, stores.Users, "alice@example.com")
+	now := time.Now().Truncate(time.Second)
+
+	// attempt tries the job the way a worker does and returns it as saved
+	attempt := func(job types.PushJob, at time.Time) types.PushJob {
+		t.Helper()
+		if err := q.attempt(t.Context(), &job, at); err != nil {
+			t.Fatal(err)
+		}
+		var saved types.PushJob
+		if err := db.First(&saved, job.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return saved
+	}
+
+	t.Run("retry after", func(t *testing.T) {
+		sub := createTestSubscription(t, q, user, service.URL+"/busy")
+		job := attempt(queueTestPush(t, q, sub, now), now)
+		if job.Status != types.PushJobPending || job.LastStatusCode != http.StatusTooManyRequests || !job.NextAttemptAt.Equal(now.Add(10*time.Minute)) {
+			t.Errorf("rate limited job is %s with status %d, next attempt at %s", job.Status, job.LastStatusCode, job.NextAttemptAt)
+		}
+
+		job = attempt(job, now.Add(10*time.Minute))
+		if job.Status != types.PushJobSent || job.Attempts != 2 || job.LastError != "" {
+			t.Errorf("job is %s after %d attempts with error %q, want sent after 2", job.Status, job.Attempts, job.LastError)
+		}
+		sub, err := stores.Subscriptions.Get(t.Context(), sub.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub.LastSuccessAt == nil {
+			t.Error("delivery was not recorded on the subscription")
+		}
+	})
+
+	t.Run("gone", func(t *testing.T) {
+		sub := createTestSubscription(t, q, user, service.URL+"/gone")
+		job := queueTestPush(t, q, sub, now)
+		other := queueTestPush(t, q, sub, now)
+
+		job = attempt(job, now)
+		if job.Status != types.PushJobGone {
+			t.Errorf("job for an expired subscription is %s, want gone", job.Status)
+		}
+		if _, err := stores.Subscriptions.Get(t.Context(), sub.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expired subscription was not removed: %v", err)
+		}
+
+		// Other jobs for the device are dropped without asking the push service
+		other = attempt(other, now)
+		if other.Status != types.PushJobGone || other.Attempts != 0 {
+			t.Errorf("job for a removed subscription is %s after %d attempts, want gone after 0", other.Status, other.Attempts)
+		}
+		if n := service.Received("/gone"); n != 1 {
+			t.Errorf("push service received %d pushes, want 1", n)
+		}
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		sub := createTestSubscription(t, q, user, service.URL+"/broken")
+		job := queueTestPush(t, q, sub, now)
+		at := now
+		for i := 1; i < maxPushAttempts; i++ {
+			job = attempt(job, at)
+			if job.Status != types.PushJobPending || !job.NextAttemptAt.Equal(at.Add(pushBackoff(i))) {
+				t.Fatalf("attempt %d left the job %s, next attempt at %s", i, job.Status, job.NextAttemptAt)
+			}
+			at = job.NextAttemptAt
+		}
+		job = attempt(job, at)
+		if job.Status != types.PushJobDead || job.Attempts != maxPushAttempts || job.FinishedAt == nil {
+			t.Errorf("job is %s after %d attempts, want dead after %d", job.Status, job.Attempts, maxPushAttempts)
+		}
+		if _, err := stores.Subscriptions.Get(t.Context(), sub.ID); err != nil {
+			t.Errorf("failing push service removed the subscription: %v", err)
+		}
+
+		// Rejected pushes are not retried
+		sub = createTestSubscription(t, q, user, service.URL+"/bad")
+		job = attempt(queueTestPush(t, q, sub, now), now)
+		if job.Status != types.PushJobDead || job.Attempts != 1 {
+			t.Errorf("rejected job is %s after %d attempts, want dead after 1", job.Status, job.Attempts)
+		}
+	})
+
+	t.Run("retired vapid key", func(t *testing.T) {
+		sub := createTestSubscription(t, q, user, service.URL+"/busy")
+		if err := db.Model(&sub).Update("vapid_public_key", "retired").Error; err != nil {
+			t.Fatal(err)
+		}
+		job := attempt(queueTestPush(t, q, sub, now), now)
+		if job.Status != types.PushJobGone {
+			t.Errorf("job for a retired key is %s, want gone", job.Status)
+		}
+		if _, err := stores.Subscriptions.Get(t.Context(), sub.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("subscription with a retired key was not removed: %v", err)
+		}
+	})
+}
+
+func TestPushQueueFlush(t *testing.T) {
+	q, db, stores := newTestPushQueue(t)
+	service := newFakePushService(t, map[string][]fakePushResponse{
+		"/ok": {{status: http.StatusCreated}},
+	})
+	user := createTestUser(t, stores.Users, "alice@example.com")
+	sub := createTestSubscription(t, q, user, service.URL+"/ok")
+
+	now := time.Now()
+	for range 5 {
+		queueTestPush(t, q, sub, now.Add(-time.Minute))
+	}
+	later := queueTestPush(t, q, sub, now.Add(time.Hour))
+
+	// A triggered batch is fanned out to the user's devices and sent too
+	if _, err := q.Trigger(t.Context(), PushKindReminder, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Flush(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if n := service.Received("/ok"); n != 6 {
+		t.Errorf("push service received %d pushes, want 6", n)
+	}
+
+	var counts []struct {
+		Status string
+		Count  int
+	}
+	if err := db.Model(&types.PushJob{}).Select("status, count(*) AS count").Group("status").Scan(&counts).Error; err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, c := range counts {
+		got[c.Status] = c.Count
+	}
+	if got[types.PushJobSent] != 6 || got[types.PushJobPending] != 1 {
+		t.Errorf("jobs by status are %v, want 6 sent and 1 pending", got)
+	}
+
+	var job types.PushJob
+	if err := db.First(&job, later.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != types.PushJobPending || job.Attempts != 0 {
+		t.Errorf("job which is not due yet is %s after %d attempts", job.Status, job.Attempts)
+	}
+}
+
+func TestPushQueueDispatchAfterStopping(t *testing.T) {
+	q, _, _ := newTestPushQueue(t)
+
+	// A worker is free, but the queue loop is being stopped
+	workerCtx, stopWorker := context.WithCancel(t.Context())
+	defer stopWorker()
+	go q.work(workerCtx)
+	stopped, cancel := context.WithCancel(t.Context())
+	cancel()
+	for range 20 {
+		if q.dispatch(stopped, &types.PushJob{}) {
+			t.Fatal("dispatched a job after the queue loop was stopped")
+		}
+	}
+
+	// Stopping the loop timed out and it is still dispatching after Flush
+	if err := q.Flush(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	dispatched := make(chan bool, 1)
+	go func() {
+		dispatched <- q.dispatch(t.Context(), &types.PushJob{})
+	}()
+	select {
+	case ok := <-dispatched:
+		if ok {
+			t.Error("dispatched a job after the queue was flushed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dispatching after the queue was flushed blocked")
+	}
+	if ids := q.inFlightIDs(); len(ids) != 0 {
+		t.Errorf("jobs %v are still in flight", ids)
+	}
+}
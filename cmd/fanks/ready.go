@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/migrations"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// readyTimeout bounds each check, a locked database should fail the probe
+// rather than hang it
+const readyTimeout = 2 * time.Second
+
+type readyCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type migrationCheck struct {
+	readyCheck
+	Latest  int `json:"latest"`
+	Pending int `json:"pending"`
+}
+
+// readiness is the body of /readyz, Status is "ok" only when every check is
+type readiness struct {
+	Status     string         `json:"status"`
+	Database   readyCheck     `json:"database"`
+	Migrations migrationCheck `json:"migrations"`
+	Workers    []workerStatus `json:"workers"`
+}
+
+func checkFailed(err error) readyCheck {
+	return readyCheck{Status: "failed", Error: err.Error()}
+}
+
+func checkDatabase(ctx context.Context, db *gorm.DB) readyCheck {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return checkFailed(err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return checkFailed(errors.Wrap(err, "pinging database"))
+	}
+	return readyCheck{Status: "ok"}
+}
+
+func checkMigrations(ctx context.Context, db *gorm.DB) migrationCheck {
+	check := migrationCheck{readyCheck: readyCheck{Status: "ok"}, Latest: migrations.Latest()}
+	// Reading schema_migrations also finds a database which answers pings
+	// but is locked. Versions are contiguous, so counting them is enough.
+	var applied int64
+	err := db.WithContext(ctx).Model(&migrations.SchemaMigration{}).
+		Where("version <= ?", check.Latest).Count(&applied).Error
+	if err != nil {
+		check.readyCheck = checkFailed(errors.Wrap(err, "reading schema_migrations"))
+		return check
+	}
+	check.Pending = check.Latest - int(applied)
+	if check.Pending > 0 {
+		check.Status = "pending"
+	}
+	return check
+}
+
+// readyz reports whether this instance can serve traffic: the database
+// answers, its schema is up to date and every background worker is running.
+// It answers 503 when any of them is not. A stale worker is only reported, as
+// a long run of a worker which is still going is no reason to stop serving.
+func readyz(db *gorm.DB, workers *workerGroup) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), readyTimeout)
+		defer cancel()
+
+		ready := readiness{
+			Status:     "ok",
+			Database:   checkDatabase(ctx, db),
+			Migrations: checkMigrations(ctx, db),
+			Workers:    workers.Statuses(time.Now()),
+		}
+		ok := ready.Database.Status == "ok" && ready.Migrations.Status == "ok"
+		for _, worker := range ready.Workers {
+			ok = ok && (worker.Status == WorkerOK || worker.Status == WorkerStale)
+		}
+
+		if !ok {
+			ready.Status = "failed"
+			return c.JSON(http.StatusServiceUnavailable, ready)
+		}
+		return c.JSON(http.StatusOK, ready)
+	}
+}
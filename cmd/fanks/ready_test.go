@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReadyzWorkers(t *testing.T) {
+	db := newTestDB(t, "sqlite")
+	workers := newWorkerGroup()
+	t.Cleanup(func() { workers.Stop(context.Background()) })
+	release := make(chan struct{})
+	defer close(release)
+	workers.Every("busy", time.Minute, true, func(ctx context.Context) {
+		// Still in the middle of its first run
+		<-release
+	})
+
+	check := func() (int, readiness) {
+		t.Helper()
+		rec := call(readyz(db, workers), nil, newRequest(http.MethodGet, "/readyz", nil))
+		var ready readiness
+		if err := json.Unmarshal(rec.Body.Bytes(), &ready); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, ready
+	}
+
+	if code, ready := check(); code != http.StatusOK || ready.Status != "ok" {
+		t.Errorf("readyz answered %d %s with a running worker", code, ready.Status)
+	}
+
+	// A worker which missed its heartbeats is reported but does not fail
+	workers.mu.Lock()
+	workers.workers[0].lastBeat = time.Now().Add(-time.Hour)
+	workers.mu.Unlock()
+	code, ready := check()
+	if code != http.StatusOK || ready.Status != "ok" {
+		t.Errorf("readyz answered %d %s with a stale worker", code, ready.Status)
+	}
+	if len(ready.Workers) != 1 || ready.Workers[0].Status != WorkerStale {
+		t.Errorf("readyz reported workers %+v, want busy as stale", ready.Workers)
+	}
+
+	// A worker whose goroutine returned does
+	workers.Go("crashed", func(ctx context.Context) {})
+	waitFor(t, "the worker to stop", func() bool {
+		return workers.Statuses(time.Now())[1].Status == WorkerStopped
+	})
+	if code, ready := check(); code != http.StatusServiceUnavailable || ready.Status != "failed" {
+		t.Errorf("readyz answered %d %s with a stopped worker", code, ready.Status)
+	}
+}
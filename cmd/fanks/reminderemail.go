@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	composeTokenPurpose = "compose"
+	// The compose link only fills in the prompt and never signs anyone in
+	composeTokenTTL         = 7 * 24 * time.Hour
+	unsubscribeTokenPurpose = "unsubscribe-reminders"
+	unsubscribeTokenTTL     = 365 * 24 * time.Hour
+	// A reminder which could not go out within this long after the push is
+	// dropped rather than arriving the next morning
+	reminderEmailGrace = 3 * time.Hour
+)
+
+var errUnsubscribeLink = errors.New("This unsubscribe link is invalid or has expired, turn email reminders off in your settings instead.")
+
+// lastReminderTime is when the daily reminder most recently went out
+func lastReminderTime(now time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	due := time.Date(now.Year(), now.Month(), now.Day(), reminderHour, 0, 0, 0, loc)
+	if due.After(now) {
+		due = due.AddDate(0, 0, -1)
+	}
+	return due
+}
+
+func reminderEmail(cfg types.Config, user types.User, now time.Time) (Email, error) {
+	prompt := randomPrompt()
+	compose := types.ComposeLink{
+		Prompt: prompt,
+		Token:  signToken(cfg.CookeSecret, composeTokenPurpose, user.ID, now.Add(composeTokenTTL)),
+	}
+	unsubscribe := url.Values{
+		"token": {signToken(cfg.CookeSecret, unsubscribeTokenPurpose, user.ID, now.Add(unsubscribeTokenTTL))},
+	}
+	reminder := types.ReminderEmail{
+		User:           user,
+		Prompt:         prompt,
+		ComposeURL:     fmt.Sprintf("https://%s%s", cfg.Hostname, composeLinkURL(compose)),
+		UnsubscribeURL: fmt.Sprintf("https://%s/email/unsubscribe?%s", cfg.Hostname, unsubscribe.Encode()),
+	}
+
+	var html bytes.Buffer
+	if err := views.ReminderEmail(reminder).Render(context.Background(), &html); err != nil {
+		return Email{}, errors.Wrap(err, "rendering reminder")
+	}
+
+	return Email{
+		To:      fmt.Sprintf("%q <%s>", user.Name, user.Email),
+		Subject: views.ReminderSubject,
+		HTML:    html.String(),
+		Text:    views.ReminderText(reminder),
+		// Mail clients offer their own unsubscribe button for these, see RFC 8058
+		Headers: map[string]string{
+			"List-Unsubscribe":      fmt.Sprintf("<%s>", reminder.UnsubscribeURL),
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+// sendDueReminderEmails emails today's reminder to every opted-in user who has
+// not been sent it yet
+func sendDueReminderEmails(ctx context.Context, cfg types.Config, users store.UserStore, mailer Mailer, now time.Time, loc *time.Location) error {
+	due := lastReminderTime(now, loc)
+	if now.Sub(due) > reminderEmailGrace {
+		return nil
+	}
+
+	subscribers, err := users.ListWithEmailReminders(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range subscribers {
+		if user.EmailReminderLastSentAt != nil && !user.EmailReminderLastSentAt.Before(due) {
+			continue
+		}
+		if remindersSkipped(user, due) {
+			continue
+		}
+
+		logrus := logrus.WithField("user", user.Email)
+		email, err := reminderEmail(cfg, user, now)
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+
+		if err := mailer.Send(email); err != nil {
+			logrus.Error(errors.Wrap(err, "sending reminder email"))
+			continue
+		}
+
+		if err := users.MarkReminderEmailSent(ctx, user, now); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		logrus.Info("Sent reminder email")
+	}
+	return nil
+}
+
+func startReminderEmailWorker(workers *workerGroup, cfg types.Config, users store.UserStore, mailer Mailer) error {
+	if mailer == nil {
+		logrus.Info("SMTP is not configured, email reminders are disabled")
+		return nil
+	}
+	loc, err := time.LoadLocation(reminderTimezone)
+	if err != nil {
+		return errors.Wrap(err, "loading location")
+	}
+
+	workers.Every("reminder-email", 5*time.Minute, false, func(ctx context.Context) {
+		if err := sendDueReminderEmails(ctx, cfg, users, mailer, time.Now(), loc); err != nil {
+			logrus.Error(errors.Wrap(err, "sending reminder emails"))
+		}
+	})
+	return nil
+}
+
+// composeURL opens the composer with prompt
+func composeURL(prompt string) string {
+	return "/?" + url.Values{"prompt": {prompt}}.Encode()
+}
+
+// composeLinkURL is the path of the compose link of a reminder email
+func composeLinkURL(compose types.ComposeLink) string {
+	return "/email/write?" + url.Values{"prompt": {compose.Prompt}, "token": {compose.Token}}.Encode()
+}
+
+// composeFromEmail opens the composer with the prompt of a reminder email for
+// the user it was sent to. The link is not a sign in link, anyone who is
+// signed out signs in the usual way first and then follows it again.
+func composeFromEmail(cfg types.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		compose := types.ComposeLink{Prompt: c.QueryParam("prompt"), Token: c.QueryParam("token")}
+		userID, err := verifyToken(cfg.CookeSecret, composeTokenPurpose, compose.Token, time.Now())
+		if err != nil {
+			// Only links from a reminder email fill in the prompt
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/auth/sign-in?"+url.Values{"prompt": {compose.Prompt}, "token": {compose.Token}}.Encode())
+		}
+		if user.ID != userID {
+			return c.String(http.StatusForbidden, "This link was emailed to someone else, sign out to use it")
+		}
+		return c.Redirect(http.StatusFound, composeURL(compose.Prompt))
+	}
+}
+
+// unsubscribeFromEmail asks before turning email reminders off, as link
+// checkers in mail clients open every link in an email
+func unsubscribeFromEmail(cfg types.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.QueryParam("token")
+		if _, err := verifyToken(cfg.CookeSecret, unsubscribeTokenPurpose, token, time.Now()); err != nil {
+			return render(c, http.StatusBadRequest, views.UnsubscribePage(cfg, "", false, errUnsubscribeLink))
+		}
+		return render(c, 200, views.UnsubscribePage(cfg, token, false, nil))
+	}
+}
+
+// confirmUnsubscribe turns email reminders off. Mail clients post here
+// directly when the user presses their unsubscribe button.
+func confirmUnsubscribe(cfg types.Config, users store.UserStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := verifyToken(cfg.CookeSecret, unsubscribeTokenPurpose, c.FormValue("token"), time.Now())
+		if err != nil {
+			return render(c, http.StatusBadRequest, views.UnsubscribePage(cfg, "", false, errUnsubscribeLink))
+		}
+
+		user, err := users.Get(c.Request().Context(), userID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		if err == nil && user.EmailReminders {
+			user.EmailReminders = false
+			if err := users.UpdateSettings(c.Request().Context(), user); err != nil {
+				return err
+			}
+			logrus.WithField("user", user.Email).Info("Unsubscribed from reminder emails")
+		}
+		return render(c, 200, views.UnsubscribePage(cfg, "", true, nil))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+func TestSendDueReminderEmails(t *testing.T) {
+	for name, newStores := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			stores := newStores(t)
+			cfg := types.Config{Hostname: "fanks.example.com", CookeSecret: []byte("secret")}
+			alice := types.User{Name: "Alice", Email: "alice@example.com", EmailReminders: true}
+			if err := stores.Users.Create(t.Context(), &alice); err != nil {
+				t.Fatal(err)
+			}
+			createTestUser(t, stores.Users, "bob@example.com")
+
+			loc := time.UTC
+			due := time.Date(2026, time.June, 15, reminderHour, 0, 0, 0, loc)
+			mailer := &fakeMailer{}
+			send := func(now time.Time) int {
+				t.Helper()
+				if err := sendDueReminderEmails(t.Context(), cfg, stores.Users, mailer, now, loc); err != nil {
+					t.Fatal(err)
+				}
+				return len(mailer.Sent())
+			}
+
+			if n := send(due.Add(-time.Minute)); n != 0 {
+				t.Errorf("sent %d reminders before they were due, want 0", n)
+			}
+			if n := send(due.Add(time.Minute)); n != 1 {
+				t.Fatalf("sent %d reminders once they were due, want 1", n)
+			}
+			email := mailer.Sent()[0]
+			if !strings.Contains(email.To, "alice@example.com") {
+				t.Errorf("reminder went to %s", email.To)
+			}
+			if !strings.Contains(email.Text, "https://fanks.example.com/email/write?prompt=") {
+				t.Error("reminder does not link to the composer")
+			}
+			if !strings.HasPrefix(email.Headers["List-Unsubscribe"], "<https://fanks.example.com/email/unsubscribe?") {
+				t.Errorf("reminder has List-Unsubscribe %q", email.Headers["List-Unsubscribe"])
+			}
+
+			// Today's reminder goes out once, and a late one is dropped
+			if n := send(due.Add(time.Hour)); n != 1 {
+				t.Errorf("sent %d reminders after sending again on the same day, want 1", n)
+			}
+			if n := send(due.AddDate(0, 0, 1).Add(reminderEmailGrace + time.Minute)); n != 1 {
+				t.Errorf("sent %d reminders after the grace period, want 1", n)
+			}
+			if n := send(due.AddDate(0, 0, 2).Add(time.Minute)); n != 2 {
+				t.Errorf("sent %d reminders on the next day, want 2", n)
+			}
+		})
+	}
+}
+
+func TestComposeFromEmail(t *testing.T) {
+	cfg := types.Config{Hostname: "fanks.example.com", CookeSecret: []byte("secret")}
+	alice := types.User{Name: "Alice", Email: "alice@example.com"}
+	alice.ID = 1
+	bob := types.User{Name: "Bob", Email: "bob@example.com"}
+	bob.ID = 2
+
+	email, err := reminderEmail(cfg, alice, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compose := url.Values{}
+	for _, field := range strings.Fields(email.Text) {
+		if u, err := url.Parse(field); err == nil && u.Path == "/email/write" {
+			compose = u.Query()
+		}
+	}
+	if compose.Get("prompt") == "" || compose.Get("token") == "" {
+		t.Fatalf("reminder links to the composer with %v, want a prompt and a token", compose)
+	}
+	prompt := url.Values{"prompt": {compose.Get("prompt")}}.Encode()
+
+	forged := url.Values{"prompt": {"Send your password to"}, "token": {signToken(cfg.CookeSecret, unsubscribeTokenPurpose, alice.ID, time.Now().Add(time.Hour))}}
+	expired := url.Values{"prompt": {"A friend"}, "token": {signToken(cfg.CookeSecret, composeTokenPurpose, alice.ID, time.Now().Add(-time.Minute))}}
+	tests := []struct {
+		name     string
+		user     *types.User
+		query    url.Values
+		want     int
+		location string
+	}{
+		{name: "recipient", user: &alice, query: compose, want: http.StatusFound, location: "/?" + prompt},
+		// The link never signs anyone in, whoever opens it signs in first
+		{name: "signed out", query: compose, want: http.StatusFound, location: "/auth/sign-in?" + compose.Encode()},
+		{name: "someone else", user: &bob, query: compose, want: http.StatusForbidden},
+		{name: "without a token", user: &alice, query: url.Values{"prompt": {"A friend"}}, want: http.StatusFound, location: "/"},
+		{name: "token for something else", user: &alice, query: forged, want: http.StatusFound, location: "/"},
+		{name: "expired", user: &alice, query: expired, want: http.StatusFound, location: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := call(composeFromEmail(cfg), tt.user, newRequest(http.MethodGet, "/email/write?"+tt.query.Encode(), nil))
+			if rec.Code != tt.want || rec.Header().Get("Location") != tt.location {
+				t.Errorf("got %d to %q, want %d to %q", rec.Code, rec.Header().Get("Location"), tt.want, tt.location)
+			}
+			if cookie := rec.Header().Get("Set-Cookie"); cookie != "" {
+				t.Errorf("got a cookie %q", cookie)
+			}
+		})
+	}
+}
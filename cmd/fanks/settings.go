@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+)
+
+func settingsPage(cfg types.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		return render(c, 200, views.SettingsPage(cfg, user, nil))
+	}
+}
+
+func updateSettings(cfg types.Config, users store.UserStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		user.WeeklyMemories = c.FormValue("weeklyMemories") == "on"
+
+		frequency := c.FormValue("digestFrequency")
+		if !cfg.SMTP.Enabled() {
+			frequency = user.DigestFrequency
+		}
+		if !slices.Contains([]string{types.DigestOff, types.DigestWeekly, types.DigestMonthly}, frequency) {
+			return render(c, 422, views.SettingsForm(cfg, user, fmt.Errorf("Unknown digest frequency %q", frequency)))
+		}
+		if frequency != user.DigestFrequency {
+			// Start with the next digest rather than immediately sending the one for the period which just ended
+			now := time.Now()
+			user.DigestFrequency = frequency
+			user.DigestLastSentAt = &now
+		}
+
+		emailReminders := c.FormValue("emailReminders") == "on"
+		if !cfg.SMTP.Enabled() {
+			emailReminders = user.EmailReminders
+		}
+		if emailReminders && !user.EmailReminders {
+			// Start with tomorrow's reminder if today's has gone out already
+			now := time.Now()
+			user.EmailReminderLastSentAt = &now
+		}
+		user.EmailReminders = emailReminders
+
+		if err := users.UpdateSettings(c.Request().Context(), user); err != nil {
+			return render(c, 500, views.SettingsForm(cfg, user, err))
+		}
+
+		return render(c, 200, views.SettingsForm(cfg, user, nil))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/dbtest"
+	"github.com/oliverisaac/fanks/migrations"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	// init logs at debug level, which buries test failures
+	logrus.SetLevel(logrus.WarnLevel)
+	os.Exit(m.Run())
+}
+
+// newTestDB returns a migrated database of the dialect which is removed
+// after the test
+func newTestDB(t *testing.T, dialect string) *gorm.DB {
+	t.Helper()
+	db := dbtest.Dialects[dialect](t)
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// storeBackends are the stores handler tests run against
+var storeBackends = map[string]func(t *testing.T) store.Stores{
+	"memory":   func(t *testing.T) store.Stores { return store.NewMemory().Stores() },
+	"sqlite":   func(t *testing.T) store.Stores { return store.NewGORM(newTestDB(t, "sqlite")) },
+	"postgres": func(t *testing.T) store.Stores { return store.NewGORM(newTestDB(t, "postgres")) },
+}
+
+// createTestUser saves a user with the given email
+func createTestUser(t *testing.T, users store.UserStore, email string) types.User {
+	t.Helper()
+	user := types.User{Name: email, Email: email}
+	if err := users.Create(t.Context(), &user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// signedInAs makes every request of e come from the user, as if they had a session
+func signedInAs(e *echo.Echo, user types.User) {
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(UserKey, user)
+			return next(c)
+		}
+	})
+}
+
+// newRequest builds a request, with the form as its body when there is one
+func newRequest(method, target string, form url.Values) *http.Request {
+	if form == nil {
+		return httptest.NewRequest(method, target, nil)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	return req
+}
+
+// call runs the handler for the request as the user, or signed out when user
+// is nil. params are the path parameters as name and value pairs.
+func call(h echo.HandlerFunc, user *types.User, req *http.Request, params ...string) *httptest.ResponseRecorder {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if user != nil {
+		c.Set(UserKey, *user)
+	}
+	names, values := []string{}, []string{}
+	for i := 0; i+1 < len(params); i += 2 {
+		names = append(names, params[i])
+		values = append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	if err := h(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+// createTestNote saves a note of the user written at the given time
+func createTestNote(t *testing.T, notes store.NoteStore, note types.Note, at time.Time) types.Note {
+	t.Helper()
+	note.CreatedAt = at
+	note.Tags = noteTags(note.Content)
+	if err := notes.Create(t.Context(), &note); err != nil {
+		t.Fatal(err)
+	}
+	return note
+}
+
+// createTestCircle saves a circle the owner created and the others joined
+func createTestCircle(t *testing.T, circles store.CircleStore, name string, owner types.User, members ...types.User) types.Circle {
+	t.Helper()
+	circle := types.Circle{Name: name, Members: []types.CircleMember{{UserID: owner.ID, Role: types.CircleRoleOwner}}}
+	for _, m := range members {
+		circle.Members = append(circle.Members, types.CircleMember{UserID: m.ID, Role: types.CircleRoleMember})
+	}
+	if err := circles.Create(t.Context(), &circle); err != nil {
+		t.Fatal(err)
+	}
+	return circle
+}
+
+// fakeMailer keeps the emails instead of sending them
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []Email
+}
+
+func (m *fakeMailer) Send(email Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+func (m *fakeMailer) Sent() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.sent)
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
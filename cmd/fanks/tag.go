@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+)
+
+// hashtagRe matches a # which starts a word, so anchors in urls and things like "C#" are ignored
+var hashtagRe = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/#])#([\p{L}\p{N}_][\p{L}\p{N}_-]*)`)
+
+// ParseHashtags returns the unique, lowercased hashtags in content in the order they first appear
+func ParseHashtags(content string) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, match := range hashtagRe.FindAllStringSubmatch(content, -1) {
+		tag := strings.ToLower(strings.TrimRight(match[1], "-_"))
+		if !strings.ContainsFunc(tag, unicode.IsLetter) || seen[tag] {
+			// Skip things like "#1"
+			continue
+		}
+		seen[tag] = true
+		ret = append(ret, tag)
+	}
+	return ret
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// noteTags returns the tags for the hashtags in content, the note store looks them up by name
+func noteTags(content string) []types.Tag {
+	ret := []types.Tag{}
+	for _, name := range ParseHashtags(content) {
+		ret = append(ret, types.Tag{Name: name})
+	}
+	return ret
+}
+
+func tagsPage(cfg types.Config, notes store.NoteStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		counts, err := notes.TagCounts(c.Request().Context(), user.ID)
+		return render(c, 200, views.TagsPage(cfg, user, counts, err))
+	}
+}
+
+func tagTimelinePage(cfg types.Config, notes store.NoteStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		tag := normalizeTag(c.Param("name"))
+		timeline, err := notes.ListForUser(c.Request().Context(), user.ID, store.NoteFilter{Tag: tag}, 0)
+		for i, note := range timeline {
+			timeline[i].IsUserNote = note.UserID == user.ID
+		}
+
+		return render(c, 200, views.TagTimelinePage(cfg, user, tag, timeline, err))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// signToken returns a token which lets whoever holds it act as the user, for
+// one purpose only, until it expires. It is signed with the cookie secret so
+// it needs no storage.
+func signToken(secret []byte, purpose string, userID uint, expires time.Time) string {
+	payload := fmt.Sprintf("%s:%d:%d", purpose, userID, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(tokenMAC(secret, payload))
+}
+
+// verifyToken returns the user a token was signed for if it is for the
+// purpose and has not expired
+func verifyToken(secret []byte, purpose string, token string, now time.Time) (uint, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, tokenMAC(secret, string(payload))) {
+		return 0, errInvalidToken
+	}
+
+	parts := strings.Split(string(payload), ":")
+	if len(parts) != 3 || parts[0] != purpose {
+		return 0, errInvalidToken
+	}
+	userID, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return 0, errInvalidToken
+	}
+	return uint(userID), nil
+}
+
+func tokenMAC(secret []byte, payload string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/blobstore"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/oliverisaac/fanks/views"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func trashPage(cfg types.Config, notes store.NoteStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		// Only the notes which can still be restored
+		trash, err := notes.Trash(c.Request().Context(), user.ID, time.Now().Add(-cfg.TrashRetention))
+		return render(c, 200, views.TrashPage(cfg, user, trash, err))
+	}
+}
+
+func restoreNote(cfg types.Config, notes store.NoteStore, hub *EventHub) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		ctx := c.Request().Context()
+		id, err := parseUintParam(c, "id")
+		if err != nil {
+			return c.String(http.StatusNotFound, "note not found in trash")
+		}
+
+		err = notes.Restore(ctx, id, user.ID, time.Now().Add(-cfg.TrashRetention))
+		if errors.Is(err, store.ErrNotFound) {
+			return c.String(http.StatusNotFound, "note not found in trash")
+		} else if err != nil {
+			return err
+		}
+
+		note, err := getNoteForUser(ctx, notes, c.Param("id"), user.ID)
+		if err != nil {
+			return err
+		}
+
+		publishNoteEvent(notes, hub, NoteCreated, note)
+
+		// The undo toast puts the note back in the feed, the trash page
+		// just drops it from the list
+		if c.Request().Header.Get("HX-Target") == "toast" {
+			return render(c, 200, views.NoteRestored(note))
+		}
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// purgeTrash removes notes which have been in the trash for longer than the
+// retention, along with their attachments
+func purgeTrash(ctx context.Context, db *gorm.DB, blobs blobstore.Store, retention time.Duration, now time.Time) error {
+	var notes []types.Note
+	err := db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at <= ?", now.Add(-retention)).
+		Find(&notes).Error
+	if err != nil {
+		return errors.Wrap(err, "finding expired notes")
+	}
+
+	for _, note := range notes {
+		if err := deleteAttachments(ctx, db, blobs, note.ID); err != nil {
+			return errors.Wrapf(err, "deleting attachments of note %d", note.ID)
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&note).Association("Circles").Clear(); err != nil {
+				return err
+			}
+			if err := tx.Model(&note).Association("Tags").Clear(); err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&note).Error
+		})
+		if err != nil {
+			return errors.Wrapf(err, "purging note %d", note.ID)
+		}
+	}
+
+	if len(notes) > 0 {
+		logrus.Infof("Purged %d notes from the trash", len(notes))
+	}
+	return nil
+}
+
+func startTrashPurger(workers *workerGroup, cfg types.Config, db *gorm.DB, blobs blobstore.Store) {
+	workers.Every("trash", time.Hour, true, func(ctx context.Context) {
+		if err := purgeTrash(ctx, db, blobs, cfg.TrashRetention, time.Now()); err != nil {
+			logrus.Error(errors.Wrap(err, "purging trash"))
+		}
+	})
+}
@@ -11,34 +11,20 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
 	"github.com/oliverisaac/fanks/types"
 	"github.com/oliverisaac/fanks/views"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
-func getUserByID(db *gorm.DB, id uint) (types.User, error) {
-	var user types.User
-	err := db.Preload("PushSubscriptions").First(&user, "id = ?", id).Error
-
-	return user, errors.Wrap(err, "Finding user")
-}
-
-func userExists(email string, db *gorm.DB) bool {
-	var user types.User
-	err := db.First(&user, "email = ?", email).Error
-
-	return err != gorm.ErrRecordNotFound
-}
-
 func signUp() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		return render(c, 200, views.SignUpForm(nil))
 	}
 }
 
-func signUpWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc {
+func signUpWithEmailAndPassword(users store.UserStore, cfg types.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		name := c.FormValue("name")
 		email := c.FormValue("email")
@@ -54,8 +40,11 @@ func signUpWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc
 			return render(c, 422, views.SignUpForm(fmt.Errorf("Oops! That email address is banned")))
 		}
 
-		if userExists(email, db) {
+		_, err = users.GetByEmail(c.Request().Context(), email)
+		if err == nil {
 			return render(c, 422, views.SignUpForm(fmt.Errorf("Oops! It appears you are already registered")))
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return render(c, 422, views.SignUpForm(errors.Wrap(err, "Internal server error")))
 		}
 
 		hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
@@ -64,8 +53,8 @@ func signUpWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc
 		}
 
 		// Check if this is the first user
-		var count int64
-		if err := db.Model(&types.User{}).Count(&count).Error; err != nil {
+		count, err := users.Count(c.Request().Context())
+		if err != nil {
 			err := errors.Wrap(err, "Internal server error")
 			return render(c, 422, views.SignUpForm(err))
 		}
@@ -83,8 +72,7 @@ func signUpWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc
 			CreatedAt: time.Now(),
 		}
 
-		if err := db.Create(&user).Error; err != nil {
-			err := errors.Wrap(err, "Create user error")
+		if err := users.Create(c.Request().Context(), &user); err != nil {
 			return render(c, 422, views.SignUpForm(err))
 		}
 
@@ -94,44 +82,57 @@ func signUpWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc
 
 func signIn(cfg types.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		return render(c, 200, views.SignInForm(cfg, nil))
+		compose := types.ComposeLink{Prompt: c.QueryParam("prompt"), Token: c.QueryParam("token")}
+		// Links from outside the app, like reminder emails, need the whole page
+		if c.Request().Header.Get("HX-Request") == "" {
+			return render(c, 200, views.SignInPage(cfg, compose))
+		}
+		return render(c, 200, views.SignInForm(cfg, compose, nil))
 	}
 }
 
-func signInWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc {
+func signInWithEmailAndPassword(users store.UserStore, cfg types.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		email := c.FormValue("email")
 		password := c.FormValue("password")
+		compose := types.ComposeLink{Prompt: c.FormValue("prompt"), Token: c.FormValue("token")}
 
 		_, err := mail.ParseAddress(email)
 		if err != nil {
-			return render(c, 422, views.SignInForm(cfg, fmt.Errorf("Invalid email")))
+			return render(c, 422, views.SignInForm(cfg, compose, fmt.Errorf("Invalid email")))
 		}
 
-		var user types.User
-		db.First(&user, "email = ?", email)
+		user, _ := users.GetByEmail(c.Request().Context(), email)
 		if compareErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); compareErr != nil {
-			return render(c, 422, views.SignInForm(cfg, fmt.Errorf("Invalid email or password")))
+			return render(c, 422, views.SignInForm(cfg, compose, fmt.Errorf("Invalid email or password")))
 		}
 
-		sess, _ := session.Get(SessionKey, c)
-		sess.Options = &sessions.Options{
-			Path:     "/",
-			MaxAge:   3600 * 24 * 365,
-			HttpOnly: true,
+		if err := startSession(c, user); err != nil {
+			return render(c, 422, views.SignInForm(cfg, compose, errors.Wrap(err, "Internal server error")))
 		}
 
-		sess.Values[SessionUserIDKey] = user.ID
-
-		err = sess.Save(c.Request(), c.Response())
-		if err != nil {
-			return render(c, 422, views.SignInForm(cfg, errors.Wrap(err, "Internal server error")))
+		// The compose link checks it was sent to the user who signed in
+		if compose.Prompt != "" {
+			return c.Redirect(http.StatusFound, composeLinkURL(compose))
 		}
-
 		return c.Redirect(http.StatusFound, "/")
 	}
 }
 
+// startSession signs the user in on this browser
+func startSession(c echo.Context, user types.User) error {
+	sess, _ := session.Get(SessionKey, c)
+	sess.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   3600 * 24 * 365,
+		HttpOnly: true,
+	}
+
+	sess.Values[SessionUserIDKey] = user.ID
+
+	return sess.Save(c.Request(), c.Response())
+}
+
 func signOut() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		sess, _ := session.Get("session", c)
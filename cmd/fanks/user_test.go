@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/fanks/store"
+	"github.com/oliverisaac/fanks/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSignInKeepsComposeLink(t *testing.T) {
+	users := store.NewMemory().Stores().Users
+	cfg := types.Config{CookeSecret: []byte("secret")}
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Create(t.Context(), &types.User{Name: "Alice", Email: "alice@example.com", Password: string(hash)}); err != nil {
+		t.Fatal(err)
+	}
+	compose := url.Values{"prompt": {"A friend"}, "token": {"signed"}}
+	hidden := []string{`<input type="hidden" name="prompt" value="A friend">`, `<input type="hidden" name="token" value="signed">`}
+	carriesLink := func(body string) bool {
+		return strings.Contains(body, hidden[0]) && strings.Contains(body, hidden[1])
+	}
+
+	// Opened from a reminder email, the form comes with the page around it
+	rec := call(signIn(cfg), nil, newRequest(http.MethodGet, "/auth/sign-in?"+compose.Encode(), nil))
+	if body := rec.Body.String(); !strings.Contains(body, "<html") || !carriesLink(body) {
+		t.Errorf("sign in page does not carry the compose link:\n%s", body)
+	}
+	req := newRequest(http.MethodGet, "/auth/sign-in", nil)
+	req.Header.Set("HX-Request", "true")
+	if body := call(signIn(cfg), nil, req).Body.String(); strings.Contains(body, "<html") || strings.Contains(body, `name="prompt"`) {
+		t.Errorf("sign in form from the app is not the bare form:\n%s", body)
+	}
+
+	e := echo.New()
+	e.Use(session.Middleware(sessions.NewCookieStore(cfg.CookeSecret)))
+	e.POST("/auth/sign-in", signInWithEmailAndPassword(users, cfg))
+	post := func(password string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		form := url.Values{"email": {"alice@example.com"}, "password": {password}, "prompt": compose["prompt"], "token": compose["token"]}
+		e.ServeHTTP(rec, newRequest(http.MethodPost, "/auth/sign-in", form))
+		return rec
+	}
+
+	rec = post("wrong")
+	if rec.Code != 422 || !carriesLink(rec.Body.String()) {
+		t.Errorf("a wrong password got %d and lost the compose link:\n%s", rec.Code, rec.Body.String())
+	}
+	// Signed in, the compose link checks who it was sent to
+	rec = post("hunter22")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/email/write?"+compose.Encode() {
+		t.Errorf("signing in got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec.Header().Get("Set-Cookie") == "" {
+		t.Error("signing in did not start a session")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// workerGrace is how long past its interval a worker may take before it is
+// reported as stale, a single run can take a while when there is a lot to do
+const workerGrace = time.Minute
+
+// workerGroup runs the background workers and lets shutdown stop them and
+// wait for whatever they are in the middle of
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	workers []*worker
+}
+
+// worker is the heartbeat of a single background goroutine
+type worker struct {
+	name     string
+	interval time.Duration
+	lastBeat time.Time
+	stopped  bool
+}
+
+// workerStatus is how a worker is doing, as /readyz reports it
+type workerStatus struct {
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Interval string    `json:"interval,omitempty"`
+	LastBeat time.Time `json:"lastBeat"`
+}
+
+const (
+	WorkerOK       = "ok"
+	WorkerStale    = "stale"
+	WorkerStopped  = "stopped"
+	WorkerStopping = "stopping"
+)
+
+func newWorkerGroup() *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerGroup{ctx: ctx, cancel: cancel}
+}
+
+func (w *workerGroup) register(name string, interval time.Duration) *worker {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wk := &worker{name: name, interval: interval, lastBeat: time.Now()}
+	w.workers = append(w.workers, wk)
+	return wk
+}
+
+func (w *workerGroup) beat(wk *worker) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wk.lastBeat = time.Now()
+}
+
+func (w *workerGroup) run(wk *worker, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			wk.stopped = true
+		}()
+		fn(w.ctx)
+	}()
+}
+
+// Go runs fn in the background, fn must return once ctx is done. The worker
+// is healthy for as long as fn has not returned.
+func (w *workerGroup) Go(name string, fn func(ctx context.Context)) {
+	w.run(w.register(name, 0), fn)
+}
+
+// Every calls fn every interval until the group is stopped, and once right
+// away if runNow is set
+func (w *workerGroup) Every(name string, interval time.Duration, runNow bool, fn func(ctx context.Context)) {
+	w.Loop(name, interval, runNow, nil, fn)
+}
+
+// Loop is Every which also calls fn whenever wake receives. The worker beats
+// each time fn returns and is stale once it misses two intervals.
+func (w *workerGroup) Loop(name string, interval time.Duration, runNow bool, wake <-chan struct{}, fn func(ctx context.Context)) {
+	wk := w.register(name, interval)
+	w.run(wk, func(ctx context.Context) {
+		if runNow {
+			fn(ctx)
+			w.beat(wk)
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wake:
+			}
+			fn(ctx)
+			w.beat(wk)
+		}
+	})
+}
+
+// Statuses reports every worker, in the order they were started
+func (w *workerGroup) Statuses(now time.Time) []workerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ret := make([]workerStatus, 0, len(w.workers))
+	for _, wk := range w.workers {
+		s := workerStatus{Name: wk.name, Status: WorkerOK, LastBeat: wk.lastBeat}
+		if wk.interval > 0 {
+			s.Interval = wk.interval.String()
+		}
+		switch {
+		case w.ctx.Err() != nil:
+			s.Status = WorkerStopping
+		case wk.stopped:
+			s.Status = WorkerStopped
+		case wk.interval > 0 && now.Sub(wk.lastBeat) > 2*wk.interval+workerGrace:
+			s.Status = WorkerStale
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// Stop cancels the workers and waits for them to return, giving up once ctx
+// is done. Stopping a stopped group returns right away.
+func (w *workerGroup) Stop(ctx context.Context) error {
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "waiting for workers %s", strings.Join(w.running(), ", "))
+	}
+}
+
+// running returns the names of the workers which have not returned yet
+func (w *workerGroup) running() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ret := []string{}
+	for _, wk := range w.workers {
+		if !wk.stopped {
+			ret = append(ret, wk.name)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerGroupStop(t *testing.T) {
+	workers := newWorkerGroup()
+	release := make(chan struct{})
+	workers.Go("polite", func(ctx context.Context) {
+		<-ctx.Done()
+	})
+	workers.Every("stuck", time.Hour, true, func(ctx context.Context) {
+		// Ignores ctx, like a send without a deadline
+		<-release
+	})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	err := workers.Stop(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stopping a stuck worker returned %v, want the deadline", err)
+	}
+	if !strings.Contains(err.Error(), "stuck") || strings.Contains(err.Error(), "polite") {
+		t.Errorf("error %q does not name just the stuck worker", err)
+	}
+
+	close(release)
+	if err := workers.Stop(t.Context()); err != nil {
+		t.Errorf("stopping once the worker returned gave %v", err)
+	}
+	for _, s := range workers.Statuses(time.Now()) {
+		if s.Status != WorkerStopping {
+			t.Errorf("worker %s is %s after stopping", s.Name, s.Status)
+		}
+	}
+}
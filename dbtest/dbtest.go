@@ -0,0 +1,110 @@
+// Package dbtest opens throwaway databases for tests. SQLite always runs in
+// memory, Postgres only runs when FANKS_TEST_POSTGRES_DSN points at a server
+// the tests may create schemas in.
+package dbtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	_ "github.com/ncruces/go-sqlite3/embed"
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+)
+
+const PostgresDSNEnv = "FANKS_TEST_POSTGRES_DSN"
+
+// Dialects are the databases the tests run against, by name
+var Dialects = map[string]func(t testing.TB) *gorm.DB{
+	"sqlite":   SQLite,
+	"postgres": Postgres,
+}
+
+// SQLite returns an empty in-memory database
+func SQLite(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// Postgres returns a connection to a new empty schema which is dropped after
+// the test. The test is skipped unless FANKS_TEST_POSTGRES_DSN is set.
+func Postgres(t testing.TB) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv(PostgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("set %s to run the tests against Postgres", PostgresDSNEnv)
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminDB, err := admin.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "fanks_test_" + randomSuffix(t)
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		adminDB.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := admin.Exec("DROP SCHEMA " + schema + " CASCADE").Error; err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+		adminDB.Close()
+	})
+
+	db, err := gorm.Open(postgres.Open(withSearchPath(dsn, schema)), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registered after the drop so it runs first
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// withSearchPath sets the schema every connection of dsn starts in, dsn may
+// be a URL or key=value pairs
+func withSearchPath(dsn string, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			q := u.Query()
+			q.Set("search_path", schema)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
+
+func randomSuffix(t testing.TB) string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
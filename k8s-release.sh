@@ -21,6 +21,23 @@ BUILDKIT_PROGRESS=plain docker buildx build --build-arg VERSION_TAG="$(git rev-p
 
 base_image_name="${IMAGE_NAME%:*}"
 
+# Liveness restarts a pod whose server stopped answering, readiness takes it
+# out of service while its database or background workers are unhealthy. The
+# startup probe leaves time for migrations before liveness starts counting.
+function ensure_probes() {
+  local namespace="$1" resource="$2" container
+  container=$(kubectl get -n "$namespace" "$resource" -o jsonpath='{range .spec.template.spec.containers[*]}{.name} {.image}{"\n"}{end}' |
+    awk -v image="$base_image_name" 'index($2, image) == 1 { print $1; exit }')
+  echo_do kubectl patch -n "$namespace" "$resource" --type strategic -p "$(
+    jq -n --arg name "$container" '{spec: {template: {spec: {containers: [{
+      name: $name,
+      startupProbe: {httpGet: {path: "/healthz", port: 8080}, periodSeconds: 5, failureThreshold: 60},
+      livenessProbe: {httpGet: {path: "/healthz", port: 8080}, periodSeconds: 10, failureThreshold: 3},
+      readinessProbe: {httpGet: {path: "/readyz", port: 8080}, periodSeconds: 10, timeoutSeconds: 5, failureThreshold: 3}
+    }]}}}}'
+  )"
+}
+
 image_hash=$(docker inspect "${IMAGE_NAME}" | jq -r '.[].RepoDigests[]' | grep sha256 | head -n 1)
 image_hash="@sha256${image_hash#*@sha256}"
 
@@ -29,6 +46,7 @@ resources=$(kubectl get sts,deployments --all-namespaces -o jsonpath='{range .it
 echo "$resources" |
   while read namespace resource image; do
     if [[ $image == ${IMAGE_NAME}* ]]; then
+      ensure_probes "$namespace" "$resource"
       deployed=false
       for ((i = 0; i < 60; i++)); do
         echo_do kubectl rollout restart -n "$namespace" "$resource"
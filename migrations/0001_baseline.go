@@ -0,0 +1,34 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// baseline creates the tables the first release of fanks had
+func baseline(tx *gorm.DB) error {
+	type PushSubscription struct {
+		gorm.Model
+		UserID   uint
+		Endpoint string
+		P256DH   string
+		Auth     string
+		Keys     string
+	}
+
+	type Note struct {
+		gorm.Model
+		UserID  uint
+		Content string
+		Prompt  string `gorm:"default:'Today I am grateful for...'"`
+	}
+
+	type User struct {
+		gorm.Model
+		Name              string
+		Email             string
+		Password          string
+		Role              string
+		Notes             []Note
+		PushSubscriptions []PushSubscription
+	}
+
+	return tx.AutoMigrate(&User{}, &Note{}, &PushSubscription{})
+}
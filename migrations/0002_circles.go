@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// circles adds circles for sharing notes. Instances that predate circles keep
+// their "everyone sees everything" behavior: all existing notes are shared
+// with a circle containing every existing user.
+func circles(tx *gorm.DB) error {
+	type User struct {
+		gorm.Model
+		Role string
+	}
+
+	type CircleMember struct {
+		CircleID  uint `gorm:"primaryKey"`
+		UserID    uint `gorm:"primaryKey;index"`
+		User      User
+		Role      string
+		CreatedAt time.Time `gorm:"autoCreateTime"`
+	}
+
+	type CircleInvite struct {
+		gorm.Model
+		CircleID    uint
+		Token       string `gorm:"uniqueIndex"`
+		InvitedByID uint
+		InvitedBy   User
+		ExpiresAt   time.Time
+	}
+
+	type Circle struct {
+		gorm.Model
+		Name    string
+		Members []CircleMember
+		Invites []CircleInvite
+	}
+
+	type Note struct {
+		gorm.Model
+		Circles []Circle `gorm:"many2many:note_circles;"`
+	}
+
+	if err := tx.AutoMigrate(&Circle{}, &CircleMember{}, &CircleInvite{}, &Note{}); err != nil {
+		return err
+	}
+
+	var users []User
+	if err := tx.Order("id").Find(&users).Error; err != nil {
+		return errors.Wrap(err, "finding users")
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	owner := users[0]
+	for _, u := range users {
+		if u.Role == "admin" {
+			owner = u
+			break
+		}
+	}
+
+	circle := Circle{Name: "Everyone"}
+	for _, u := range users {
+		role := "member"
+		if u.ID == owner.ID {
+			role = "owner"
+		}
+		circle.Members = append(circle.Members, CircleMember{UserID: u.ID, Role: role})
+	}
+
+	if err := tx.Create(&circle).Error; err != nil {
+		return errors.Wrap(err, "creating default circle")
+	}
+	err := tx.Exec("INSERT INTO note_circles (note_id, circle_id) SELECT id, ? FROM notes", circle.ID).Error
+	return errors.Wrap(err, "sharing existing notes with default circle")
+}
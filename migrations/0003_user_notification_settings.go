@@ -0,0 +1,19 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// userNotificationSettings adds the weekly memories and email digest settings
+func userNotificationSettings(tx *gorm.DB) error {
+	type User struct {
+		gorm.Model
+		WeeklyMemories   bool
+		DigestFrequency  string
+		DigestLastSentAt *time.Time
+	}
+
+	return tx.AutoMigrate(&User{})
+}
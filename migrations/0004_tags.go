@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// tags adds hashtags and extracts them from the notes written before tags existed
+func tags(tx *gorm.DB) error {
+	type Tag struct {
+		ID        uint   `gorm:"primarykey"`
+		Name      string `gorm:"uniqueIndex"`
+		CreatedAt time.Time
+	}
+
+	type Note struct {
+		gorm.Model
+		Content string
+		Tags    []Tag `gorm:"many2many:note_tags;"`
+	}
+
+	if err := tx.AutoMigrate(&Tag{}, &Note{}); err != nil {
+		return err
+	}
+
+	var notes []Note
+	if err := tx.Find(&notes).Error; err != nil {
+		return errors.Wrap(err, "finding notes")
+	}
+
+	for _, note := range notes {
+		for _, name := range parseHashtags(note.Content) {
+			tag := Tag{Name: name}
+			if err := tx.Where(Tag{Name: name}).FirstOrCreate(&tag).Error; err != nil {
+				return errors.Wrapf(err, "finding tag %q", name)
+			}
+			err := tx.Exec("INSERT INTO note_tags (note_id, tag_id) VALUES (?, ?)", note.ID, tag.ID).Error
+			if err != nil {
+				return errors.Wrapf(err, "tagging note %d", note.ID)
+			}
+		}
+	}
+	return nil
+}
+
+var hashtagRe = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/#])#([\p{L}\p{N}_][\p{L}\p{N}_-]*)`)
+
+// parseHashtags is a copy of how hashtags were parsed when tags were added
+func parseHashtags(content string) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, match := range hashtagRe.FindAllStringSubmatch(content, -1) {
+		tag := strings.ToLower(strings.TrimRight(match[1], "-_"))
+		if !strings.ContainsFunc(tag, unicode.IsLetter) || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		ret = append(ret, tag)
+	}
+	return ret
+}
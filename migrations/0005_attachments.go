@@ -0,0 +1,24 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// attachments adds photos to notes
+func attachments(tx *gorm.DB) error {
+	type Attachment struct {
+		gorm.Model
+		NoteID      uint `gorm:"index"`
+		UserID      uint
+		ContentType string
+		Key         string
+		ThumbKey    string
+		Width       int
+		Height      int
+	}
+
+	type Note struct {
+		gorm.Model
+		Attachments []Attachment
+	}
+
+	return tx.AutoMigrate(&Note{}, &Attachment{})
+}
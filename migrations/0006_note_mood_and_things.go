@@ -0,0 +1,14 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// noteMoodAndThings adds the mood rating and "three good things" to notes
+func noteMoodAndThings(tx *gorm.DB) error {
+	type Note struct {
+		gorm.Model
+		Mood   int
+		Things []string `gorm:"serializer:json"`
+	}
+
+	return tx.AutoMigrate(&Note{})
+}
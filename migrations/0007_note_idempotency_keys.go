@@ -0,0 +1,15 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// noteIdempotencyKeys lets clients safely retry creating a note. Keys are
+// unique per user.
+func noteIdempotencyKeys(tx *gorm.DB) error {
+	type Note struct {
+		gorm.Model
+		UserID         uint    `gorm:"uniqueIndex:idx_notes_user_idempotency_key"`
+		IdempotencyKey *string `gorm:"uniqueIndex:idx_notes_user_idempotency_key"`
+	}
+
+	return tx.AutoMigrate(&Note{})
+}
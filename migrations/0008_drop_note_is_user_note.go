@@ -0,0 +1,13 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// dropNoteIsUserNote removes a column the first release's AutoMigrate created
+// from a malformed struct tag. The value was never meant to be stored, and
+// databases created by the baseline migration never had it.
+func dropNoteIsUserNote(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn("notes", "is_user_note") {
+		return nil
+	}
+	return tx.Exec("ALTER TABLE notes DROP COLUMN is_user_note").Error
+}
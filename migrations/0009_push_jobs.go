@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// pushJobs queues push notifications so failed deliveries can be retried
+func pushJobs(tx *gorm.DB) error {
+	type PushJob struct {
+		gorm.Model
+		UserID         uint
+		SubscriptionID uint
+		Kind           string
+		Message        string
+		Status         string `gorm:"index:idx_push_jobs_status_next_attempt_at"`
+		Attempts       int
+		NextAttemptAt  time.Time `gorm:"index:idx_push_jobs_status_next_attempt_at"`
+		LastStatusCode int
+		LastError      string
+		FinishedAt     *time.Time
+	}
+
+	return tx.AutoMigrate(&PushJob{})
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// pushBatches records each trigger of a push so its progress can be polled
+func pushBatches(tx *gorm.DB) error {
+	type PushBatch struct {
+		gorm.Model
+		Kind     string
+		UserID   uint
+		QueuedAt *time.Time
+	}
+	type PushJob struct {
+		gorm.Model
+		BatchID *uint `gorm:"index"`
+	}
+
+	return tx.AutoMigrate(&PushBatch{}, &PushJob{})
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// pushSubscriptionDevices keeps a single subscription per endpoint and records
+// which device each one is
+func pushSubscriptionDevices(tx *gorm.DB) error {
+	type PushSubscription struct {
+		gorm.Model
+		Endpoint      string `gorm:"uniqueIndex"`
+		Label         string
+		UserAgent     string
+		LastSuccessAt *time.Time
+	}
+
+	// Removed subscriptions and older copies of an endpoint would break the unique index
+	if err := tx.Exec("DELETE FROM push_subscriptions WHERE deleted_at IS NOT NULL").Error; err != nil {
+		return err
+	}
+	err := tx.Exec("DELETE FROM push_subscriptions WHERE id NOT IN (SELECT MAX(id) FROM push_subscriptions GROUP BY endpoint)").Error
+	if err != nil {
+		return err
+	}
+	return tx.AutoMigrate(&PushSubscription{})
+}
@@ -0,0 +1,15 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// pushSubscriptionVapidKeys records which VAPID key each device subscribed
+// with. Existing subscriptions are given the configured key on startup, the
+// migration does not know it.
+func pushSubscriptionVapidKeys(tx *gorm.DB) error {
+	type PushSubscription struct {
+		gorm.Model
+		VapidPublicKey string
+	}
+
+	return tx.AutoMigrate(&PushSubscription{})
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// notificationChannels lets users get reminders through ntfy, Gotify, Matrix
+// or a webhook, with a push job for each channel like for each device
+func notificationChannels(tx *gorm.DB) error {
+	type NotificationChannel struct {
+		gorm.Model
+		UserID        uint `gorm:"index"`
+		Kind          string
+		Label         string
+		URL           string
+		Token         string
+		RoomID        string
+		LastSuccessAt *time.Time
+	}
+	type PushJob struct {
+		gorm.Model
+		ChannelID *uint
+	}
+
+	return tx.AutoMigrate(&NotificationChannel{}, &PushJob{})
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// userEmailReminders lets users get the daily reminder by email
+func userEmailReminders(tx *gorm.DB) error {
+	type User struct {
+		gorm.Model
+		EmailReminders          bool
+		EmailReminderLastSentAt *time.Time
+	}
+
+	return tx.AutoMigrate(&User{})
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// userRemindersSkipped records the day a user skipped the reminder on
+func userRemindersSkipped(tx *gorm.DB) error {
+	type User struct {
+		gorm.Model
+		RemindersSkippedUntil *time.Time
+	}
+
+	return tx.AutoMigrate(&User{})
+}
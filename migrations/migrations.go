@@ -0,0 +1,160 @@
+// Package migrations evolves the database schema through numbered up
+// migrations. Each migration runs in a transaction together with the row that
+// records it in schema_migrations, so it is applied exactly once.
+//
+// Migrations describe the tables they touch with model structs declared inside
+// the migration. Those snapshots must never change, even when the models in
+// the types package do.
+//
+// Databases created before this package existed have the schema AutoMigrate
+// made for the first release. The baseline migration is that same AutoMigrate,
+// so it adopts them and every later migration runs on them as on a new one.
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// SchemaMigration records that a migration has been applied
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// Status is a migration and when it was applied, if it has been
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+func (s Status) Applied() bool {
+	return s.AppliedAt != nil
+}
+
+// all lists every migration in the order they are applied. Append new
+// migrations to the end and never reorder or remove existing ones.
+var all = []Migration{
+	{1, "baseline", baseline},
+	{2, "circles", circles},
+	{3, "user notification settings", userNotificationSettings},
+	{4, "tags", tags},
+	{5, "attachments", attachments},
+	{6, "note mood and things", noteMoodAndThings},
+	{7, "note idempotency keys", noteIdempotencyKeys},
+	{8, "drop notes.is_user_note", dropNoteIsUserNote},
+	{9, "push jobs", pushJobs},
+	{10, "push batches", pushBatches},
+	{11, "push subscription devices", pushSubscriptionDevices},
+	{12, "push subscription vapid keys", pushSubscriptionVapidKeys},
+	{13, "notification channels", notificationChannels},
+	{14, "user email reminders", userEmailReminders},
+	{15, "user reminders skipped", userRemindersSkipped},
+}
+
+// Latest returns the version of the newest migration
+func Latest() int {
+	return all[len(all)-1].Version
+}
+
+func validate() error {
+	for i, m := range all {
+		if m.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, expected %d", m.Name, m.Version, i+1)
+		}
+	}
+	return nil
+}
+
+func applied(db *gorm.DB) (map[int]SchemaMigration, error) {
+	ret := map[int]SchemaMigration{}
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return ret, nil
+	}
+
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, errors.Wrap(err, "reading schema_migrations")
+	}
+	for _, row := range rows {
+		ret[row.Version] = row
+	}
+	return ret, nil
+}
+
+// GetStatus returns every migration along with whether it has been applied
+func GetStatus(db *gorm.DB) ([]Status, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []Status{}
+	for _, m := range all {
+		s := Status{Migration: m}
+		if row, ok := done[m.Version]; ok {
+			s.AppliedAt = &row.AppliedAt
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// Pending returns the migrations which have not been applied yet
+func Pending(db *gorm.DB) ([]Migration, error) {
+	statuses, err := GetStatus(db)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []Migration{}
+	for _, s := range statuses {
+		if !s.Applied() {
+			ret = append(ret, s.Migration)
+		}
+	}
+	return ret, nil
+}
+
+// Up applies all pending migrations in order and returns the ones it applied
+func Up(db *gorm.DB) ([]Migration, error) {
+	if err := validate(); err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, errors.Wrap(err, "creating schema_migrations")
+	}
+
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []Migration{}
+	for _, m := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return ret, errors.Wrapf(err, "applying migration %d %s", m.Version, m.Name)
+		}
+		logrus.Infof("Applied migration %d %s", m.Version, m.Name)
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
@@ -0,0 +1,325 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/dbtest"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func init() {
+	logrus.SetLevel(logrus.WarnLevel)
+}
+
+// schema describes the tables of a database in a way which does not depend on
+// the order columns and indexes were added in
+func schema(t *testing.T, db *gorm.DB) map[string][]string {
+	t.Helper()
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := map[string][]string{}
+	for _, table := range tables {
+		columns, err := db.Migrator().ColumnTypes(table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc := []string{}
+		for _, c := range columns {
+			nullable, _ := c.Nullable()
+			desc = append(desc, fmt.Sprintf("column %s %s null=%v", c.Name(), strings.ToLower(c.DatabaseTypeName()), nullable))
+		}
+		desc = append(desc, indexes(t, db, table)...)
+		slices.Sort(desc)
+		ret[table] = desc
+	}
+	return ret
+}
+
+// indexes describes the indexes of a table. gorm's Postgres migrator looks
+// indexes up by table name in every schema, so Postgres reads its own catalog
+// instead.
+func indexes(t *testing.T, db *gorm.DB, table string) []string {
+	t.Helper()
+	desc := []string{}
+	if db.Dialector.Name() == "postgres" {
+		var schema string
+		var defs []string
+		if err := db.Raw("SELECT current_schema()").Scan(&schema).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Raw("SELECT indexdef FROM pg_indexes WHERE schemaname = ? AND tablename = ?", schema, table).Scan(&defs).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, def := range defs {
+			desc = append(desc, "index "+strings.ReplaceAll(def, schema+".", ""))
+		}
+		return desc
+	}
+
+	indexes, err := db.Migrator().GetIndexes(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, idx := range indexes {
+		unique, _ := idx.Unique()
+		desc = append(desc, fmt.Sprintf("index %s (%s) unique=%v", idx.Name(), strings.Join(idx.Columns(), ", "), unique))
+	}
+	return desc
+}
+
+func assertSameSchema(t *testing.T, got, want map[string][]string) {
+	t.Helper()
+	for table, wantDesc := range want {
+		gotDesc, ok := got[table]
+		if !ok {
+			t.Errorf("table %s is missing", table)
+			continue
+		}
+		for _, d := range wantDesc {
+			if !slices.Contains(gotDesc, d) {
+				t.Errorf("%s: missing %s", table, d)
+			}
+		}
+		for _, d := range gotDesc {
+			if !slices.Contains(wantDesc, d) {
+				t.Errorf("%s: unexpected %s", table, d)
+			}
+		}
+	}
+	for table := range got {
+		if _, ok := want[table]; !ok {
+			t.Errorf("unexpected table %s", table)
+		}
+	}
+}
+
+func assertAllApplied(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	pending, err := Pending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) > 0 {
+		t.Errorf("%d migrations are pending after migrating, the first is %d %s", len(pending), pending[0].Version, pending[0].Name)
+	}
+}
+
+func TestUpFromEmpty(t *testing.T) {
+	for name, open := range dbtest.Dialects {
+		t.Run(name, func(t *testing.T) {
+			db := open(t)
+
+			applied, err := Up(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) != Latest() {
+				t.Errorf("applied %d migrations, want %d", len(applied), Latest())
+			}
+			assertAllApplied(t, db)
+
+			for _, table := range []string{"users", "notes", "circles", "note_circles", "tags", "note_tags", "attachments", "push_jobs", "push_batches", "notification_channels"} {
+				if !db.Migrator().HasTable(table) {
+					t.Errorf("table %s is missing", table)
+				}
+			}
+
+			again, err := Up(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(again) != 0 {
+				t.Errorf("migrating again applied %d migrations, want none", len(again))
+			}
+		})
+	}
+}
+
+// The dialects treat NULL in unique indexes and duplicate keys differently
+// enough that the indexes are checked by what they let in
+func TestUniqueIndexes(t *testing.T) {
+	tests := []struct {
+		name string
+		// first is inserted, then second must fail if duplicate is set
+		first, second string
+		duplicate     bool
+	}{
+		{
+			name:      "idempotency key of the same user",
+			first:     "INSERT INTO notes (created_at, updated_at, user_id, content, idempotency_key) VALUES (?, ?, 1, 'a', 'key')",
+			second:    "INSERT INTO notes (created_at, updated_at, user_id, content, idempotency_key) VALUES (?, ?, 1, 'b', 'key')",
+			duplicate: true,
+		},
+		{
+			name:   "idempotency key of another user",
+			first:  "INSERT INTO notes (created_at, updated_at, user_id, content, idempotency_key) VALUES (?, ?, 1, 'a', 'key')",
+			second: "INSERT INTO notes (created_at, updated_at, user_id, content, idempotency_key) VALUES (?, ?, 2, 'b', 'key')",
+		},
+		{
+			name:   "notes without idempotency keys",
+			first:  "INSERT INTO notes (created_at, updated_at, user_id, content) VALUES (?, ?, 1, 'a')",
+			second: "INSERT INTO notes (created_at, updated_at, user_id, content) VALUES (?, ?, 1, 'b')",
+		},
+		{
+			name:      "tag name",
+			first:     "INSERT INTO tags (created_at, name) VALUES (?, 'outside')",
+			second:    "INSERT INTO tags (created_at, name) VALUES (?, 'outside')",
+			duplicate: true,
+		},
+		{
+			name:      "push subscription endpoint",
+			first:     "INSERT INTO push_subscriptions (created_at, updated_at, user_id, endpoint) VALUES (?, ?, 1, 'https://push.example.com/1')",
+			second:    "INSERT INTO push_subscriptions (created_at, updated_at, user_id, endpoint) VALUES (?, ?, 2, 'https://push.example.com/1')",
+			duplicate: true,
+		},
+	}
+	for name, open := range dbtest.Dialects {
+		t.Run(name, func(t *testing.T) {
+			db := open(t)
+			if _, err := Up(db); err != nil {
+				t.Fatal(err)
+			}
+			for _, email := range []string{"alice@example.com", "bob@example.com"} {
+				if err := db.Exec("INSERT INTO users (created_at, updated_at, email) VALUES (?, ?, ?)", time.Now(), time.Now(), email).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					err := db.Transaction(func(tx *gorm.DB) error {
+						if err := tx.Exec(tt.first, args(tt.first)...).Error; err != nil {
+							t.Fatal(err)
+						}
+						err := tx.Exec(tt.second, args(tt.second)...).Error
+						if tt.duplicate && err == nil {
+							t.Error("the duplicate was inserted")
+						}
+						if !tt.duplicate && err != nil {
+							t.Errorf("inserting failed: %v", err)
+						}
+						// Roll back so the cases do not see each other
+						return errRollback
+					})
+					if err != errRollback {
+						t.Fatal(err)
+					}
+				})
+			}
+		})
+	}
+}
+
+var errRollback = errors.New("rollback")
+
+// args fills the timestamps of a statement
+func args(stmt string) []any {
+	ret := []any{}
+	for range strings.Count(stmt, "?") {
+		ret = append(ret, time.Now())
+	}
+	return ret
+}
+
+// firstRelease creates the schema AutoMigrate left behind in the first
+// release. Its malformed `gorm:-` tag stored is_user_note.
+func firstRelease(tx *gorm.DB) error {
+	type PushSubscription struct {
+		gorm.Model
+		UserID   uint
+		Endpoint string
+		P256DH   string
+		Auth     string
+		Keys     string
+	}
+	type Note struct {
+		gorm.Model
+		UserID     uint
+		IsUserNote bool
+		Content    string
+		Prompt     string `gorm:"default:'Today I am grateful for...'"`
+	}
+	type User struct {
+		gorm.Model
+		Name              string
+		Email             string
+		Password          string
+		Role              string
+		Notes             []Note
+		PushSubscriptions []PushSubscription
+	}
+	return tx.AutoMigrate(&User{}, &Note{}, &PushSubscription{})
+}
+
+func TestUpFromFirstRelease(t *testing.T) {
+	for name, open := range dbtest.Dialects {
+		t.Run(name, func(t *testing.T) {
+			testUpFromFirstRelease(t, open)
+		})
+	}
+}
+
+func testUpFromFirstRelease(t *testing.T, open func(t testing.TB) *gorm.DB) {
+	fresh := open(t)
+	if _, err := Up(fresh); err != nil {
+		t.Fatal(err)
+	}
+	want := schema(t, fresh)
+
+	db := open(t)
+	if err := firstRelease(db); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, stmt := range []string{
+		"INSERT INTO users (id, created_at, updated_at, name, email, role) VALUES (1, ?, ?, 'Alice', 'alice@example.com', 'admin')",
+		"INSERT INTO users (id, created_at, updated_at, name, email, role) VALUES (2, ?, ?, 'Bob', 'bob@example.com', '')",
+		"INSERT INTO notes (id, created_at, updated_at, user_id, content) VALUES (1, ?, ?, 2, 'a sunny day #outside')",
+	} {
+		if err := db.Exec(stmt, now, now).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	assertAllApplied(t, db)
+	assertSameSchema(t, schema(t, db), want)
+
+	var content string
+	if err := db.Raw("SELECT content FROM notes WHERE id = 1").Scan(&content).Error; err != nil {
+		t.Fatal(err)
+	}
+	if content != "a sunny day #outside" {
+		t.Errorf("note content is %q after migrating", content)
+	}
+
+	var shared, tagged int64
+	if err := db.Raw("SELECT COUNT(*) FROM note_circles WHERE note_id = 1").Scan(&shared).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Raw("SELECT COUNT(*) FROM note_tags JOIN tags ON tags.id = note_tags.tag_id WHERE note_id = 1 AND tags.name = 'outside'").Scan(&tagged).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Notes from before circles and tags get them backfilled
+	if shared != 1 || tagged != 1 {
+		t.Errorf("note is shared with %d circles and tagged %d times, want it backfilled into both", shared, tagged)
+	}
+	var owner string
+	err := db.Raw("SELECT users.name FROM circle_members JOIN users ON users.id = circle_members.user_id WHERE circle_members.role = 'owner'").Scan(&owner).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != "Alice" {
+		t.Errorf("the default circle is owned by %q, want the admin", owner)
+	}
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPrivateAddress is returned when a channel points at an address which is
+// not on the public internet, such as the server's own network
+var ErrPrivateAddress = errors.New("notifications can only be sent to public addresses")
+
+// nonPublicPrefixes are the special purpose ranges netip does not already
+// report as private, loopback or link-local
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, which may reach private IPv4 addresses
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// PublicAddress reports whether ip is on the public internet
+func PublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClient returns the client to send to the URLs users enter. It checks the
+// address each connection is made to after the host is resolved, so neither a
+// hostname nor a redirect can point it at a private address, and it gives up
+// on services which take longer than timeout.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   checkAddress,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be the address checked rather than the service
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// checkAddress is the dialer's Control hook, it runs for every address a
+// connection is attempted to
+func checkAddress(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return errors.Wrapf(err, "parsing address %s", address)
+	}
+	if !PublicAddress(addrPort.Addr()) {
+		return errors.Wrapf(ErrPrivateAddress, "refusing to connect to %s", addrPort.Addr())
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// gotifyPriority shows the message as a notification on Android
+const gotifyPriority = 5
+
+// Gotify posts a message to a Gotify server with an application token
+type Gotify struct {
+	ServerURL string
+	Token     string
+	Client    *http.Client
+}
+
+func (g Gotify) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    msg.Title,
+		"message":  msg.Body,
+		"priority": gotifyPriority,
+		"extras": map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": msg.URL},
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshalling gotify message")
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Gotify-Key", g.Token)
+	return send(ctx, g.Client, http.MethodPost, strings.TrimSuffix(g.ServerURL, "/")+"/message", body, header)
+}
@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Matrix sends a text message to a room as the user the access token belongs to
+type Matrix struct {
+	HomeserverURL string
+	AccessToken   string
+	// RoomID is the internal ID of the room, like !abc:example.org
+	RoomID string
+	Client *http.Client
+}
+
+func (m Matrix) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]string{
+		"msgtype": "m.text",
+		"body":    fmt.Sprintf("%s\n%s\n%s", msg.Title, msg.Body, msg.URL),
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshalling matrix message")
+	}
+
+	// The homeserver drops a message sent again with the same transaction ID
+	txnID := msg.ID
+	if txnID == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return errors.Wrap(err, "creating transaction id")
+		}
+		txnID = hex.EncodeToString(b)
+	}
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(m.HomeserverURL, "/"), url.PathEscape(m.RoomID), url.PathEscape(txnID))
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Authorization", "Bearer "+m.AccessToken)
+	return send(ctx, m.Client, http.MethodPut, endpoint, body, header)
+}
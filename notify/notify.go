@@ -0,0 +1,73 @@
+// Package notify delivers notifications through services other than web push,
+// for devices where web push is unreliable.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Message is a notification. URL is absolute, it is opened when the
+// notification is clicked.
+type Message struct {
+	// ID stays the same when a message is sent again, services which can use
+	// it to drop duplicates are given it
+	ID    string
+	Title string
+	Body  string
+	URL   string
+}
+
+// Notifier sends messages to one channel of a user
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// StatusError is returned when the service answers with a status other than
+// 2xx. RetryAfter is the Retry-After header of the answer.
+type StatusError struct {
+	StatusCode int
+	RetryAfter string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+// send makes the request with client, or http.DefaultClient if client is nil
+func send(ctx context.Context, client *http.Client, method string, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sending notification")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Services explain errors in the body, only a little of it is kept
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: resp.Header.Get("Retry-After"),
+			Body:       strings.TrimSpace(string(respBody)),
+		}
+	}
+	return nil
+}
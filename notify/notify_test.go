@@ -0,0 +1,261 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// received is a request as the fake service saw it
+type received struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// fakeService records the requests it receives and answers them with status
+func fakeService(t *testing.T, status int, header http.Header) (*httptest.Server, chan received) {
+	t.Helper()
+	requests := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- received{Method: r.Method, Path: r.URL.EscapedPath(), Header: r.Header, Body: body}
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("  service says no  "))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+var testMessage = Message{ID: "fanks-7", Title: "Fanks", Body: "What are you grateful for?", URL: "https://fanks.example.com/?prompt=x"}
+
+func TestNtfy(t *testing.T) {
+	srv, requests := fakeService(t, http.StatusOK, nil)
+	n := Ntfy{TopicURL: srv.URL + "/my-topic", Token: "tk_secret", Client: srv.Client()}
+	if err := n.Notify(t.Context(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if r.Method != http.MethodPost || r.Path != "/my-topic" {
+		t.Errorf("sent %s %s", r.Method, r.Path)
+	}
+	if string(r.Body) != testMessage.Body {
+		t.Errorf("sent body %q", r.Body)
+	}
+	for k, want := range map[string]string{"Title": "Fanks", "Click": testMessage.URL, "Authorization": "Bearer tk_secret"} {
+		if got := r.Header.Get(k); got != want {
+			t.Errorf("sent %s %q, want %q", k, got, want)
+		}
+	}
+
+	// Public topics are sent without a token
+	n.Token = ""
+	if err := n.Notify(t.Context(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r.Header.Get("Authorization") != "" {
+		t.Errorf("sent Authorization %q without a token", r.Header.Get("Authorization"))
+	}
+}
+
+func TestGotify(t *testing.T) {
+	srv, requests := fakeService(t, http.StatusOK, nil)
+	g := Gotify{ServerURL: srv.URL + "/", Token: "app-token", Client: srv.Client()}
+	if err := g.Notify(t.Context(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if r.Method != http.MethodPost || r.Path != "/message" || r.Header.Get("X-Gotify-Key") != "app-token" {
+		t.Errorf("sent %s %s with key %q", r.Method, r.Path, r.Header.Get("X-Gotify-Key"))
+	}
+	var body struct {
+		Title    string
+		Message  string
+		Priority int
+		Extras   map[string]map[string]map[string]string
+	}
+	if err := json.Unmarshal(r.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Title != testMessage.Title || body.Message != testMessage.Body || body.Priority != gotifyPriority {
+		t.Errorf("sent %+v", body)
+	}
+	if got := body.Extras["client::notification"]["click"]["url"]; got != testMessage.URL {
+		t.Errorf("sent click url %q", got)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	srv, requests := fakeService(t, http.StatusOK, nil)
+	m := Matrix{HomeserverURL: srv.URL, AccessToken: "syt_token", RoomID: "!room:example.org", Client: srv.Client()}
+	if err := m.Notify(t.Context(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if want := "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/fanks-7"; r.Method != http.MethodPut || r.Path != want {
+		t.Errorf("sent %s %s, want PUT %s", r.Method, r.Path, want)
+	}
+	if r.Header.Get("Authorization") != "Bearer syt_token" {
+		t.Errorf("sent Authorization %q", r.Header.Get("Authorization"))
+	}
+	var body map[string]string
+	if err := json.Unmarshal(r.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["msgtype"] != "m.text" || !strings.Contains(body["body"], testMessage.Body) || !strings.Contains(body["body"], testMessage.URL) {
+		t.Errorf("sent %v", body)
+	}
+
+	// Messages without an ID get a transaction ID of their own
+	msg := testMessage
+	msg.ID = ""
+	if err := m.Notify(t.Context(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; strings.HasSuffix(r.Path, "/") || strings.HasSuffix(r.Path, "fanks-7") {
+		t.Errorf("sent %s without a fresh transaction id", r.Path)
+	}
+}
+
+// verifyWebhook checks a webhook the way a receiver would
+func verifyWebhook(secret string, r received, now time.Time) bool {
+	timestamp := r.Header.Get("X-Fanks-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(sent, 0)).Abs() > 5*time.Minute {
+		return false
+	}
+	want := "sha256=" + WebhookSignature(secret, timestamp, r.Body)
+	return hmac.Equal([]byte(r.Header.Get("X-Fanks-Signature")), []byte(want))
+}
+
+func TestWebhook(t *testing.T) {
+	srv, requests := fakeService(t, http.StatusNoContent, nil)
+	w := Webhook{URL: srv.URL + "/hooks/fanks", Secret: "shh", Client: srv.Client()}
+	if err := w.Notify(t.Context(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if r.Method != http.MethodPost || r.Path != "/hooks/fanks" || r.Header.Get("X-Fanks-Delivery") != "fanks-7" {
+		t.Errorf("sent %s %s with delivery %q", r.Method, r.Path, r.Header.Get("X-Fanks-Delivery"))
+	}
+	var body map[string]string
+	if err := json.Unmarshal(r.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"id": "fanks-7", "title": "Fanks", "body": testMessage.Body, "url": testMessage.URL}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("sent %s %q, want %q", k, body[k], v)
+		}
+	}
+
+	now := time.Now()
+	if !verifyWebhook("shh", r, now) {
+		t.Error("signature does not verify with the secret")
+	}
+	if verifyWebhook("other secret", r, now) {
+		t.Error("signature verifies with another secret")
+	}
+	if verifyWebhook("shh", r, now.Add(time.Hour)) {
+		t.Error("an hour old webhook verifies")
+	}
+	tampered := r
+	tampered.Body = []byte(strings.Replace(string(r.Body), "grateful", "hateful", 1))
+	if verifyWebhook("shh", tampered, now) {
+		t.Error("signature verifies a changed body")
+	}
+	replayed := r
+	replayed.Header = r.Header.Clone()
+	replayed.Header.Set("X-Fanks-Timestamp", strconv.FormatInt(now.Unix()+1, 10))
+	if verifyWebhook("shh", replayed, now) {
+		t.Error("signature verifies with another timestamp")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	// echo -n '1700000000.{"a":1}' | openssl dgst -sha256 -hmac secret
+	got := WebhookSignature("secret", "1700000000", []byte(`{"a":1}`))
+	if want := "49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686"; got != want {
+		t.Errorf("signature is %s, want %s", got, want)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	srv, _ := fakeService(t, http.StatusTooManyRequests, http.Header{"Retry-After": {"120"}})
+	err := Ntfy{TopicURL: srv.URL + "/topic", Client: srv.Client()}.Notify(t.Context(), testMessage)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("Notify returned %v, want a StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusTooManyRequests || statusErr.RetryAfter != "120" || statusErr.Body != "service says no" {
+		t.Errorf("got %+v", statusErr)
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"1.1.1.1":                true,
+		"93.184.215.14":          true,
+		"2606:4700:4700::1111":   true,
+		"::ffff:1.1.1.1":         true,
+		"127.0.0.1":              false,
+		"127.1.2.3":              false,
+		"::1":                    false,
+		"10.0.0.1":               false,
+		"172.16.5.4":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false, // cloud metadata
+		"fd00:ec2::254":          false, // cloud metadata over IPv6
+		"fe80::1":                false,
+		"0.0.0.0":                false,
+		"::":                     false,
+		"100.64.0.1":             false,
+		"224.0.0.1":              false,
+		"255.255.255.255":        false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:169.254.169.254": false,
+		"64:ff9b::a00:1":         false,
+	}
+	for addr, want := range tests {
+		if got := PublicAddress(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("PublicAddress(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	srv, requests := fakeService(t, http.StatusOK, nil)
+	client := NewClient(time.Second)
+
+	targets := []string{
+		srv.URL + "/topic",
+		strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/topic",
+	}
+	for _, target := range targets {
+		err := Ntfy{TopicURL: target, Client: client}.Notify(t.Context(), testMessage)
+		if !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("sending to %s returned %v, want ErrPrivateAddress", target, err)
+		}
+	}
+
+	select {
+	case r := <-requests:
+		t.Errorf("service received %s %s", r.Method, r.Path)
+	default:
+	}
+}
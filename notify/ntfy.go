@@ -0,0 +1,26 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+)
+
+// Ntfy publishes to a topic on an ntfy server, see https://docs.ntfy.sh/publish/
+type Ntfy struct {
+	// TopicURL is the server and topic, like https://ntfy.sh/my-topic
+	TopicURL string
+	// Token is an access token for protected topics, it may be empty
+	Token  string
+	Client *http.Client
+}
+
+func (n Ntfy) Notify(ctx context.Context, msg Message) error {
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Title", msg.Title)
+	header.Set("Click", msg.URL)
+	if n.Token != "" {
+		header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return send(ctx, n.Client, http.MethodPost, n.TopicURL, []byte(msg.Body), header)
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook posts the message as JSON to any URL. The body is signed so the
+// receiver can check it came from fanks:
+//
+//	X-Fanks-Signature: sha256=hex(HMAC-SHA256(secret, X-Fanks-Timestamp + "." + body))
+//
+// Receivers should reject timestamps which are more than a few minutes old.
+type Webhook struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (w Webhook) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]string{
+		"id":    msg.ID,
+		"title": msg.Title,
+		"body":  msg.Body,
+		"url":   msg.URL,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshalling webhook message")
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Fanks-Timestamp", timestamp)
+	header.Set("X-Fanks-Signature", "sha256="+WebhookSignature(w.Secret, timestamp, body))
+	if msg.ID != "" {
+		header.Set("X-Fanks-Delivery", msg.ID)
+	}
+	return send(ctx, w.Client, http.MethodPost, w.URL, body, header)
+}
+
+// WebhookSignature is the hex encoded signature of a webhook body sent at timestamp
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp + "."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
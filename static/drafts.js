@@ -0,0 +1,194 @@
+// Notes written while offline are queued in IndexedDB and posted to the
+// server once the connection returns. This file is loaded by both the page
+// and the service worker so they share the same queue.
+(function (scope) {
+	const DB_NAME = 'fanks';
+	const STORE = 'drafts';
+	const SYNC_TAG = 'fanks-drafts';
+
+	function openDB() {
+		return new Promise(function (resolve, reject) {
+			const req = indexedDB.open(DB_NAME, 1);
+			req.onupgradeneeded = function () {
+				req.result.createObjectStore(STORE, { keyPath: 'key' });
+			};
+			req.onsuccess = function () { resolve(req.result); };
+			req.onerror = function () { reject(req.error); };
+		});
+	}
+
+	// withStore runs fn against the drafts store and resolves with the result
+	// of the request it returns once the transaction completes
+	function withStore(mode, fn) {
+		return openDB().then(function (db) {
+			return new Promise(function (resolve, reject) {
+				const tx = db.transaction(STORE, mode);
+				const req = fn(tx.objectStore(STORE));
+				tx.oncomplete = function () { db.close(); resolve(req.result); };
+				tx.onerror = function () { db.close(); reject(tx.error); };
+			});
+		});
+	}
+
+	function newKey() {
+		if (scope.crypto && scope.crypto.randomUUID) {
+			return scope.crypto.randomUUID();
+		}
+		return Date.now().toString(36) + '-' + Math.random().toString(36).slice(2);
+	}
+
+	// add queues a draft. The key of the form submission is reused so a
+	// draft whose first attempt reached the server is not posted twice.
+	function add(fields) {
+		const formKey = fields.find(function (field) { return field[0] === 'idempotencyKey'; });
+		const key = formKey && formKey[1] ? formKey[1] : newKey();
+		const draft = { key: key, fields: fields, createdAt: new Date().toISOString() };
+		return withStore('readwrite', function (store) { return store.put(draft); }).then(function () { return draft; });
+	}
+
+	function all() {
+		return withStore('readonly', function (store) { return store.getAll(); });
+	}
+
+	function remove(key) {
+		return withStore('readwrite', function (store) { return store.delete(key); });
+	}
+
+	// done reports whether a draft should leave the queue after the server
+	// answered. Drafts are kept when retrying later might succeed.
+	function done(resp) {
+		if (resp.ok) {
+			return true;
+		}
+		return resp.status >= 400 && resp.status < 500 && [401, 408, 429].indexOf(resp.status) === -1;
+	}
+
+	// sync posts the queued drafts oldest first. The idempotency key makes it
+	// safe to send a draft again if an earlier attempt was cut off.
+	function sync() {
+		return all().then(function (drafts) {
+			drafts.sort(function (a, b) { return a.createdAt < b.createdAt ? -1 : 1; });
+			return drafts.reduce(function (prev, draft) {
+				return prev.then(function () {
+					return fetch('/note/create', {
+						method: 'POST',
+						credentials: 'same-origin',
+						headers: {
+							'Content-Type': 'application/x-www-form-urlencoded',
+							'Idempotency-Key': draft.key,
+						},
+						body: new URLSearchParams(draft.fields),
+					}).then(function (resp) {
+						if (!done(resp)) {
+							throw new Error('posting draft failed with status ' + resp.status);
+						}
+						if (!resp.ok) {
+							console.error('Dropping draft rejected by the server', draft, resp.status);
+						}
+						return remove(draft.key);
+					});
+				});
+			}, Promise.resolve());
+		});
+	}
+
+	function requestSync() {
+		if (!('serviceWorker' in navigator)) {
+			return sync();
+		}
+		return navigator.serviceWorker.ready.then(function (reg) {
+			if (reg.sync) {
+				return reg.sync.register(SYNC_TAG);
+			}
+			return navigator.onLine ? sync() : null;
+		});
+	}
+
+	function syncNow() {
+		return requestSync().catch(function (err) {
+			console.error('Failed to sync drafts:', err);
+		}).then(showStatus);
+	}
+
+	function formFields(form) {
+		const fields = [];
+		new FormData(form).forEach(function (value, name) {
+			if (typeof value === 'string' && name !== 'preview') {
+				fields.push([name, value]);
+			}
+		});
+		return fields;
+	}
+
+	function showStatus() {
+		const status = document.getElementById('drafts-status');
+		if (!status) {
+			return;
+		}
+		all().then(function (drafts) {
+			if (drafts.length === 0) {
+				status.classList.add('hidden');
+				return;
+			}
+			status.textContent = drafts.length === 1
+				? '1 note is saved on this device and will be posted when you are back online.'
+				: drafts.length + ' notes are saved on this device and will be posted when you are back online.';
+			status.classList.remove('hidden');
+		});
+	}
+
+	function queueForm(form) {
+		return add(formFields(form)).then(function () {
+			form.reset();
+			const content = form.querySelector('textarea[name=content]');
+			if (content) {
+				content.style.height = 'auto';
+			}
+			showStatus();
+			return syncNow();
+		}).catch(function (err) {
+			console.error('Failed to save draft:', err);
+		});
+	}
+
+	function isCreateForm(elt) {
+		return elt && elt.matches && elt.matches('form[hx-post="/note/create"]');
+	}
+
+	// setupPage queues notes which cannot be posted and keeps the pending
+	// drafts message up to date
+	function setupPage() {
+		document.body.addEventListener('htmx:beforeRequest', function (evt) {
+			if (isCreateForm(evt.detail.elt) && !navigator.onLine) {
+				evt.preventDefault();
+				queueForm(evt.detail.elt);
+			}
+		});
+		document.body.addEventListener('htmx:sendError', function (evt) {
+			if (isCreateForm(evt.detail.elt)) {
+				queueForm(evt.detail.elt);
+			}
+		});
+
+		// Browsers without background sync only get to send drafts while
+		// the page is open
+		scope.addEventListener('online', syncNow);
+		if ('serviceWorker' in navigator) {
+			navigator.serviceWorker.addEventListener('message', function (evt) {
+				if (evt.data && evt.data.type === 'drafts-synced') {
+					showStatus();
+				}
+			});
+		}
+		syncNow();
+	}
+
+	scope.FanksDrafts = {
+		SYNC_TAG: SYNC_TAG,
+		add: add,
+		all: all,
+		remove: remove,
+		sync: sync,
+		setupPage: setupPage,
+	};
+})(self);
@@ -1,26 +1,224 @@
+// The worker is registered as /serviceWorker.js?version=..., so the query
+// string changes with every release and is reused to bust cached assets
+const VERSION = self.location.search;
+const SHELL_CACHE = 'fanks-shell' + VERSION;
+const PAGE_CACHE = 'fanks-pages';
+
+importScripts('/static/drafts.js' + VERSION);
+
+const SHELL = [
+  '/static/css/style.min.css' + VERSION,
+  '/static/htmx-2.0.6.min.js',
+  '/static/htmx-ext-sse-2.2.3.js',
+  '/static/drafts.js' + VERSION,
+  '/static/manifest.json' + VERSION,
+  '/static/icon-128.png',
+  '/static/icon-512.png',
+];
+
+// precache stores what it can, a missing asset should not stop the worker installing
+function precache(name, urls) {
+  return caches.open(name).then(function(cache) {
+    return Promise.all(urls.map(function(url) {
+      return cache.add(url).catch(function(err) {
+        console.error('Failed to cache ' + url, err);
+      });
+    }));
+  });
+}
+
 self.addEventListener('install', function(event) {
   self.skipWaiting();
+  event.waitUntil(
+    Promise.all([
+      precache(SHELL_CACHE, SHELL),
+      precache(PAGE_CACHE, ['/']),
+    ])
+  );
 });
 
 self.addEventListener('activate', function(event) {
-  event.waitUntil(clients.claim());
+  event.waitUntil(
+    caches.keys().then(function(keys) {
+      return Promise.all(keys.filter(function(key) {
+        return key.startsWith('fanks-shell') && key !== SHELL_CACHE;
+      }).map(function(key) {
+        return caches.delete(key);
+      }));
+    }).then(function() {
+      return clients.claim();
+    })
+  );
 });
 
+function offlineResponse() {
+  return new Response('You are offline and this page has not been saved on this device yet.', {
+    status: 503,
+    headers: { 'Content-Type': 'text/plain; charset=utf-8' },
+  });
+}
+
+self.addEventListener('fetch', function(event) {
+  const request = event.request;
+  const url = new URL(request.url);
+  if (url.origin !== self.location.origin) {
+    return;
+  }
+
+  // Pages cached for offline use belong to whoever was signed in
+  if (request.method === 'POST' && url.pathname === '/auth/sign-out') {
+    event.waitUntil(caches.delete(PAGE_CACHE));
+    return;
+  }
+  if (request.method !== 'GET') {
+    return;
+  }
+
+  // Static assets are versioned, so the cached copy is always good
+  if (url.pathname.startsWith('/static/')) {
+    event.respondWith(
+      caches.match(request).then(function(cached) {
+        return cached || fetch(request).then(function(response) {
+          if (response.ok) {
+            const copy = response.clone();
+            caches.open(SHELL_CACHE).then(function(cache) { cache.put(request, copy); });
+          }
+          return response;
+        });
+      })
+    );
+    return;
+  }
+
+  // Pages come from the network when possible and from the last copy we saw otherwise
+  if (request.mode === 'navigate') {
+    event.respondWith(
+      fetch(request).then(function(response) {
+        if (response.ok) {
+          const copy = response.clone();
+          caches.open(PAGE_CACHE).then(function(cache) { cache.put(request, copy); });
+        }
+        return response;
+      }).catch(function() {
+        return caches.match(request).then(function(cached) {
+          return cached || caches.match('/');
+        }).then(function(cached) {
+          return cached || offlineResponse();
+        });
+      })
+    );
+  }
+});
+
+self.addEventListener('sync', function(event) {
+  if (event.tag !== FanksDrafts.SYNC_TAG) {
+    return;
+  }
+  event.waitUntil(
+    FanksDrafts.sync().then(function() {
+      return clients.matchAll({ type: 'window' });
+    }).then(function(clientList) {
+      clientList.forEach(function(client) {
+        client.postMessage({ type: 'drafts-synced' });
+      });
+    })
+  );
+});
+
+// resubscribe moves the device to the server's new VAPID key. The server asks
+// for it in the pushes it still signs with the previous key.
+function resubscribe(vapidPublicKey) {
+  return self.registration.pushManager.getSubscription().then(function(old) {
+    const replaces = old ? old.endpoint : '';
+    return (old ? old.unsubscribe() : Promise.resolve()).then(function() {
+      return self.registration.pushManager.subscribe({
+        userVisibleOnly: true,
+        applicationServerKey: vapidPublicKey,
+      });
+    }).then(function(subscription) {
+      return fetch('/push/subscribe', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(Object.assign(subscription.toJSON(), {
+          vapidPublicKey: vapidPublicKey,
+          replaces: replaces,
+        })),
+      });
+    });
+  }).catch(function(err) {
+    console.error('Failed to move to the new VAPID key', err);
+  });
+}
+
 self.addEventListener('push', function(event) {
     const data = event.data.json();
-    event.waitUntil(
-        self.registration.showNotification(data.title, {
-            body: data.body,
-            icon: data.icon,
-            badge: data.badge,
-            data: data.data
-        })
-    );
+    const shown = self.registration.showNotification(data.title, {
+        body: data.body,
+        icon: data.icon,
+        badge: data.badge,
+        data: data.data,
+        actions: data.actions || []
+    });
+    event.waitUntil(data.resubscribe ? Promise.all([shown, resubscribe(data.resubscribe)]) : shown);
 });
 
+// reminderAction answers a reminder without opening fanks, the token in the
+// notification stands in for the session
+function reminderAction(notification, action, fields) {
+  const data = notification.data || {};
+  return fetch('/push/actions/' + action, {
+    method: 'POST',
+    headers: { 'Authorization': 'Bearer ' + data.token },
+    body: new URLSearchParams(Object.assign({ prompt: data.prompt || '' }, fields)),
+  }).then(function(response) {
+    if (!response.ok) {
+      throw new Error(response.status + ' ' + response.statusText);
+    }
+    return response.text();
+  });
+}
+
+function showConfirmation(body) {
+  return self.registration.showNotification('Fanks', {
+    body: body,
+    icon: '/static/icon-192.png',
+    tag: 'fanks-reminder-action',
+  });
+}
+
 self.addEventListener('notificationclick', function(event) {
   event.notification.close();
 
+  const notification = event.notification;
+  if (event.action === 'write' && event.reply) {
+    event.waitUntil(
+      reminderAction(notification, 'reply', { content: event.reply }).then(function() {
+        return showConfirmation('Saved your note.');
+      }).catch(function(err) {
+        console.error('Failed to save the reply', err);
+        // Queue the reply as a draft, it is posted with the next sync
+        return FanksDrafts.add([['prompt', notification.data.prompt || ''], ['content', event.reply]]).then(function() {
+          return self.registration.sync ? self.registration.sync.register(FanksDrafts.SYNC_TAG) : null;
+        }).then(function() {
+          return showConfirmation('Could not save your note yet, it is kept on this device.');
+        });
+      })
+    );
+    return;
+  }
+  if (event.action === 'snooze' || event.action === 'skip') {
+    event.waitUntil(
+      reminderAction(notification, event.action, {}).then(function() {
+        if (event.action === 'snooze') {
+          return showConfirmation('We will remind you again in an hour.');
+        }
+      }).catch(function(err) {
+        console.error('Failed to ' + event.action + ' the reminder', err);
+      })
+    );
+    return;
+  }
+
   event.waitUntil(
     clients.matchAll({ type: 'window', includeUncontrolled: true }).then(function(clientList) {
       if (clientList.length > 0) {
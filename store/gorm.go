@@ -0,0 +1,653 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/oliverisaac/fanks/backup"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGORM returns stores which keep everything in the database
+func NewGORM(db *gorm.DB) Stores {
+	return Stores{
+		Notes:         gormNotes{db: db},
+		Users:         gormUsers{db: db},
+		Circles:       gormCircles{db: db},
+		Subscriptions: gormSubscriptions{db: db},
+		Channels:      gormChannels{db: db},
+		PushJobs:      gormPushJobs{db: db},
+		Snapshots:     gormSnapshots{db: db},
+	}
+}
+
+// notFound turns gorm's missing record error into ErrNotFound
+func notFound(err error, msg string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Wrap(ErrNotFound, msg)
+	}
+	return errors.Wrap(err, msg)
+}
+
+// withNoteAssociations preloads everything needed to render a note
+func withNoteAssociations(db *gorm.DB) *gorm.DB {
+	return db.Preload("User").Preload("Circles").Preload("Tags").Preload("Attachments")
+}
+
+// visibleNotes scopes a note query to the notes the user wrote or which were
+// shared with a circle the user belongs to
+func visibleNotes(userID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		shared := db.Session(&gorm.Session{NewDB: true}).
+			Table("note_circles").
+			Select("note_circles.note_id").
+			Joins("JOIN circle_members ON circle_members.circle_id = note_circles.circle_id").
+			Where("circle_members.user_id = ?", userID)
+		return db.Where("notes.user_id = ? OR notes.id IN (?)", userID, shared)
+	}
+}
+
+// inCircle scopes a note query to notes shared with the given circle
+func inCircle(circleID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		shared := db.Session(&gorm.Session{NewDB: true}).
+			Table("note_circles").
+			Select("note_circles.note_id").
+			Where("note_circles.circle_id = ?", circleID)
+		return db.Where("notes.id IN (?)", shared)
+	}
+}
+
+// withTag scopes a note query to notes with the given tag
+func withTag(name string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		tagged := db.Session(&gorm.Session{NewDB: true}).
+			Table("note_tags").
+			Select("note_tags.note_id").
+			Joins("JOIN tags ON tags.id = note_tags.tag_id").
+			Where("tags.name = ?", name)
+		return db.Where("notes.id IN (?)", tagged)
+	}
+}
+
+func (f NoteFilter) scopes() []func(*gorm.DB) *gorm.DB {
+	ret := []func(*gorm.DB) *gorm.DB{}
+	if f.CircleID > 0 {
+		ret = append(ret, inCircle(f.CircleID))
+	}
+	if f.Tag != "" {
+		ret = append(ret, withTag(f.Tag))
+	}
+	if f.AuthorID > 0 {
+		ret = append(ret, func(db *gorm.DB) *gorm.DB { return db.Where("notes.user_id = ?", f.AuthorID) })
+	}
+	if f.NotAuthorID > 0 {
+		ret = append(ret, func(db *gorm.DB) *gorm.DB { return db.Where("notes.user_id <> ?", f.NotAuthorID) })
+	}
+	if !f.From.IsZero() {
+		ret = append(ret, func(db *gorm.DB) *gorm.DB { return db.Where("notes.created_at >= ?", f.From) })
+	}
+	if !f.Until.IsZero() {
+		ret = append(ret, func(db *gorm.DB) *gorm.DB { return db.Where("notes.created_at < ?", f.Until) })
+	}
+	return ret
+}
+
+type gormNotes struct {
+	db *gorm.DB
+}
+
+func (s gormNotes) ListForUser(ctx context.Context, userID uint, filter NoteFilter, limit int) ([]types.Note, error) {
+	ret := []types.Note{}
+	query := s.db.WithContext(ctx).
+		Scopes(withNoteAssociations, visibleNotes(userID)).
+		Scopes(filter.scopes()...).
+		Order("notes.created_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&ret).Error
+	return ret, errors.Wrapf(err, "Looking for notes for user %d", userID)
+}
+
+func (s gormNotes) GetForUser(ctx context.Context, noteID uint, userID uint) (types.Note, error) {
+	var note types.Note
+	err := s.db.WithContext(ctx).
+		Scopes(withNoteAssociations, visibleNotes(userID)).
+		First(&note, "notes.id = ?", noteID).Error
+	return note, notFound(err, "getting note from db")
+}
+
+func (s gormNotes) GetByIdempotencyKey(ctx context.Context, userID uint, key string) (*types.Note, error) {
+	notes := []types.Note{}
+	// The unique index covers deleted notes too
+	err := s.db.WithContext(ctx).Unscoped().
+		Scopes(withNoteAssociations).
+		Where("notes.user_id = ? AND notes.idempotency_key = ?", userID, key).
+		Limit(1).
+		Find(&notes).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "finding note by idempotency key")
+	}
+	if len(notes) == 0 {
+		return nil, nil
+	}
+	return &notes[0], nil
+}
+
+func (s gormNotes) ExpireIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Unscoped().Model(&types.Note{}).
+		Where("idempotency_key IS NOT NULL AND created_at < ?", before).
+		UpdateColumn("idempotency_key", nil)
+	return result.RowsAffected, errors.Wrap(result.Error, "expiring idempotency keys")
+}
+
+func (s gormNotes) Create(ctx context.Context, note *types.Note) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tags, err := findOrCreateTags(tx, note.Tags)
+		if err != nil {
+			return err
+		}
+		note.Tags = tags
+		return errors.Wrap(tx.Create(note).Error, "saving note")
+	})
+}
+
+func (s gormNotes) Update(ctx context.Context, note *types.Note) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(note).Update("content", note.Content).Error; err != nil {
+			return errors.Wrap(err, "saving note")
+		}
+		tags, err := findOrCreateTags(tx, note.Tags)
+		if err != nil {
+			return err
+		}
+		note.Tags = tags
+		return errors.Wrap(tx.Model(note).Association("Tags").Replace(tags), "saving note tags")
+	})
+}
+
+func (s gormNotes) Delete(ctx context.Context, note types.Note) error {
+	return errors.Wrap(s.db.WithContext(ctx).Delete(&note).Error, "deleting note from db")
+}
+
+func (s gormNotes) Trash(ctx context.Context, userID uint, since time.Time) ([]types.Note, error) {
+	ret := []types.Note{}
+	err := s.db.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL AND deleted_at > ?", userID, since).
+		Order("deleted_at DESC").
+		Find(&ret).Error
+	return ret, errors.Wrap(err, "finding deleted notes")
+}
+
+func (s gormNotes) Restore(ctx context.Context, noteID uint, userID uint, since time.Time) error {
+	result := s.db.WithContext(ctx).Unscoped().Model(&types.Note{}).
+		Where("id = ? AND user_id = ? AND deleted_at > ?", noteID, userID, since).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "restoring note")
+	}
+	if result.RowsAffected == 0 {
+		return errors.Wrap(ErrNotFound, "finding note in trash")
+	}
+	return nil
+}
+
+func (s gormNotes) Audience(ctx context.Context, note types.Note) ([]uint, error) {
+	ret := []uint{}
+	err := s.db.WithContext(ctx).Model(&types.CircleMember{}).
+		Distinct("circle_members.user_id").
+		Joins("JOIN note_circles ON note_circles.circle_id = circle_members.circle_id").
+		Where("note_circles.note_id = ?", note.ID).
+		Pluck("circle_members.user_id", &ret).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "finding note audience")
+	}
+	if !slices.Contains(ret, note.UserID) {
+		ret = append(ret, note.UserID)
+	}
+	return ret, nil
+}
+
+func (s gormNotes) OnThisDay(ctx context.Context, userID uint, day time.Time) ([]types.Note, error) {
+	ret := []types.Note{}
+	db := s.db.WithContext(ctx)
+
+	var first types.Note
+	err := db.Where("user_id = ?", userID).Order("created_at").Limit(1).Find(&first).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "finding first note")
+	}
+	if first.ID == 0 {
+		return ret, nil
+	}
+
+	// One range per year keeps this to plain comparisons, which SQLite and
+	// Postgres agree on
+	ranges := db.Session(&gorm.Session{NewDB: true}).Where("1 = 0")
+	found := false
+	for year := first.CreatedAt.In(day.Location()).Year(); year < day.Year(); year++ {
+		start := time.Date(year, day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+		if start.Month() != day.Month() {
+			// Feb 29th does not exist this year
+			continue
+		}
+		ranges = ranges.Or("notes.created_at >= ? AND notes.created_at < ?", start, start.AddDate(0, 0, 1))
+		found = true
+	}
+	if !found {
+		return ret, nil
+	}
+
+	err = db.Scopes(withNoteAssociations).
+		Where("notes.user_id = ?", userID).
+		Where(ranges).
+		Order("notes.created_at DESC").
+		Find(&ret).Error
+	return ret, errors.Wrap(err, "finding notes on this day")
+}
+
+func (s gormNotes) RandomBefore(ctx context.Context, userID uint, before time.Time) (*types.Note, error) {
+	notes := []types.Note{}
+	err := s.db.WithContext(ctx).Scopes(withNoteAssociations).
+		Where("notes.user_id = ? AND notes.created_at < ?", userID, before).
+		Order("RANDOM()").
+		Limit(1).
+		Find(&notes).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "finding random past note")
+	}
+	if len(notes) == 0 {
+		return nil, nil
+	}
+	return &notes[0], nil
+}
+
+func (s gormNotes) Moods(ctx context.Context, userID uint, since time.Time) ([]types.Note, error) {
+	ret := []types.Note{}
+	err := s.db.WithContext(ctx).Select("created_at", "mood").
+		Where("user_id = ? AND mood > 0 AND created_at >= ?", userID, since).
+		Order("created_at").
+		Find(&ret).Error
+	return ret, errors.Wrap(err, "finding moods")
+}
+
+func (s gormNotes) CreationTimes(ctx context.Context, userID uint) ([]time.Time, error) {
+	var ret []time.Time
+	err := s.db.WithContext(ctx).Model(&types.Note{}).Where("user_id = ?", userID).Pluck("created_at", &ret).Error
+	return ret, errors.Wrap(err, "finding note dates")
+}
+
+func (s gormNotes) TagCounts(ctx context.Context, userID uint) ([]types.TagCount, error) {
+	ret := []types.TagCount{}
+	err := s.db.WithContext(ctx).Model(&types.Note{}).
+		Scopes(visibleNotes(userID)).
+		Select("tags.name AS name, COUNT(*) AS count").
+		Joins("JOIN note_tags ON note_tags.note_id = notes.id").
+		Joins("JOIN tags ON tags.id = note_tags.tag_id").
+		Group("tags.name").
+		Order("count DESC, tags.name").
+		Scan(&ret).Error
+	return ret, errors.Wrap(err, "counting tags")
+}
+
+func (s gormNotes) GetAttachment(ctx context.Context, id uint) (types.Attachment, error) {
+	var attachment types.Attachment
+	err := s.db.WithContext(ctx).First(&attachment, "id = ?", id).Error
+	return attachment, notFound(err, "finding attachment")
+}
+
+// findOrCreateTags looks up tags by name, creating the ones which do not exist yet
+func findOrCreateTags(db *gorm.DB, tags []types.Tag) ([]types.Tag, error) {
+	ret := []types.Tag{}
+	for _, t := range tags {
+		tag := types.Tag{Name: t.Name}
+		if err := db.Where(types.Tag{Name: t.Name}).FirstOrCreate(&tag).Error; err != nil {
+			return nil, errors.Wrapf(err, "finding tag %q", t.Name)
+		}
+		ret = append(ret, tag)
+	}
+	return ret, nil
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (s gormUsers) Get(ctx context.Context, id uint) (types.User, error) {
+	var user types.User
+	err := s.db.WithContext(ctx).Preload("PushSubscriptions").Preload("NotificationChannels").First(&user, "id = ?", id).Error
+	return user, notFound(err, "Finding user")
+}
+
+func (s gormUsers) GetByEmail(ctx context.Context, email string) (types.User, error) {
+	var user types.User
+	err := s.db.WithContext(ctx).First(&user, "email = ?", email).Error
+	return user, notFound(err, "Finding user by email")
+}
+
+func (s gormUsers) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&types.User{}).Count(&count).Error
+	return count, errors.Wrap(err, "counting users")
+}
+
+func (s gormUsers) Create(ctx context.Context, user *types.User) error {
+	return errors.Wrap(s.db.WithContext(ctx).Create(user).Error, "Create user error")
+}
+
+func (s gormUsers) UpdateSettings(ctx context.Context, user types.User) error {
+	err := s.db.WithContext(ctx).Model(&user).
+		Select("weekly_memories", "digest_frequency", "digest_last_sent_at", "email_reminders", "email_reminder_last_sent_at").
+		Updates(&user).Error
+	return errors.Wrap(err, "saving settings")
+}
+
+func (s gormUsers) Circles(ctx context.Context, userID uint) ([]types.Circle, error) {
+	ret := []types.Circle{}
+	err := s.db.WithContext(ctx).
+		Preload("Members.User").
+		Joins("JOIN circle_members ON circle_members.circle_id = circles.id").
+		Where("circle_members.user_id = ?", userID).
+		Order("circles.name").
+		Find(&ret).Error
+	return ret, errors.Wrap(err, "finding circles for user")
+}
+
+func (s gormUsers) ListWithSubscriptions(ctx context.Context) ([]types.User, error) {
+	var users []types.User
+	err := s.db.WithContext(ctx).Preload("PushSubscriptions").Preload("NotificationChannels").Find(&users).Error
+	return users, errors.Wrap(err, "getting all users")
+}
+
+func (s gormUsers) ListWithDigests(ctx context.Context) ([]types.User, error) {
+	var users []types.User
+	err := s.db.WithContext(ctx).Where("digest_frequency IN ?", []string{types.DigestWeekly, types.DigestMonthly}).Find(&users).Error
+	return users, errors.Wrap(err, "finding digest users")
+}
+
+func (s gormUsers) ListWithEmailReminders(ctx context.Context) ([]types.User, error) {
+	var users []types.User
+	err := s.db.WithContext(ctx).Where("email_reminders = ?", true).Find(&users).Error
+	return users, errors.Wrap(err, "finding email reminder users")
+}
+
+func (s gormUsers) MarkDigestSent(ctx context.Context, user types.User, at time.Time) error {
+	err := s.db.WithContext(ctx).Model(&types.User{}).Where("id = ?", user.ID).Update("digest_last_sent_at", at).Error
+	return errors.Wrap(err, "recording digest as sent")
+}
+
+func (s gormUsers) MarkReminderEmailSent(ctx context.Context, user types.User, at time.Time) error {
+	err := s.db.WithContext(ctx).Model(&types.User{}).Where("id = ?", user.ID).Update("email_reminder_last_sent_at", at).Error
+	return errors.Wrap(err, "recording reminder email as sent")
+}
+
+func (s gormUsers) SkipReminders(ctx context.Context, user types.User, until time.Time) error {
+	err := s.db.WithContext(ctx).Model(&types.User{}).Where("id = ?", user.ID).Update("reminders_skipped_until", until).Error
+	return errors.Wrap(err, "skipping reminders")
+}
+
+type gormCircles struct {
+	db *gorm.DB
+}
+
+func (s gormCircles) GetForUser(ctx context.Context, circleID uint, userID uint) (types.Circle, types.CircleMember, error) {
+	var circle types.Circle
+	err := s.db.WithContext(ctx).
+		Preload("Members", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Preload("Members.User").
+		Preload("Invites", "expires_at > ?", time.Now()).
+		First(&circle, "id = ?", circleID).Error
+	if err != nil {
+		return circle, types.CircleMember{}, notFound(err, "finding circle")
+	}
+
+	member, ok := circle.Member(userID)
+	if !ok {
+		return circle, member, errors.Wrap(ErrNotFound, "finding circle membership")
+	}
+	return circle, member, nil
+}
+
+func (s gormCircles) Create(ctx context.Context, circle *types.Circle) error {
+	return errors.Wrap(s.db.WithContext(ctx).Create(circle).Error, "creating circle")
+}
+
+func (s gormCircles) Rename(ctx context.Context, circle types.Circle, name string) error {
+	// Updating through the loaded circle would save its members again
+	err := s.db.WithContext(ctx).Model(&types.Circle{}).Where("id = ?", circle.ID).Update("name", name).Error
+	return errors.Wrap(err, "renaming circle")
+}
+
+func (s gormCircles) Delete(ctx context.Context, circle types.Circle) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM note_circles WHERE circle_id = ?", circle.ID).Error; err != nil {
+			return errors.Wrap(err, "removing shared notes")
+		}
+		if err := tx.Where("circle_id = ?", circle.ID).Delete(&types.CircleMember{}).Error; err != nil {
+			return errors.Wrap(err, "removing members")
+		}
+		if err := tx.Unscoped().Where("circle_id = ?", circle.ID).Delete(&types.CircleInvite{}).Error; err != nil {
+			return errors.Wrap(err, "removing invites")
+		}
+		return errors.Wrap(tx.Unscoped().Delete(&circle).Error, "removing circle")
+	})
+	return errors.Wrap(err, "deleting circle")
+}
+
+func (s gormCircles) AddMember(ctx context.Context, member types.CircleMember) error {
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error
+	return errors.Wrap(err, "joining circle")
+}
+
+func (s gormCircles) SetRole(ctx context.Context, circleID uint, userID uint, role string) error {
+	err := s.db.WithContext(ctx).Model(&types.CircleMember{}).
+		Where("circle_id = ? AND user_id = ?", circleID, userID).
+		Update("role", role).Error
+	return errors.Wrap(err, "updating member role")
+}
+
+func (s gormCircles) RemoveMember(ctx context.Context, circleID uint, userID uint) error {
+	err := s.db.WithContext(ctx).Where("circle_id = ? AND user_id = ?", circleID, userID).Delete(&types.CircleMember{}).Error
+	return errors.Wrap(err, "removing member")
+}
+
+func (s gormCircles) CreateInvite(ctx context.Context, invite *types.CircleInvite) error {
+	return errors.Wrap(s.db.WithContext(ctx).Create(invite).Error, "creating invite")
+}
+
+func (s gormCircles) GetInvite(ctx context.Context, token string) (types.CircleInvite, error) {
+	var invite types.CircleInvite
+	err := s.db.WithContext(ctx).Preload("Circle").Preload("InvitedBy").First(&invite, "token = ?", token).Error
+	if err == nil && invite.Expired() {
+		err = gorm.ErrRecordNotFound
+	}
+	return invite, notFound(err, "finding invite")
+}
+
+func (s gormCircles) DeleteInvite(ctx context.Context, circleID uint, inviteID uint) error {
+	err := s.db.WithContext(ctx).Unscoped().Where("id = ? AND circle_id = ?", inviteID, circleID).Delete(&types.CircleInvite{}).Error
+	return errors.Wrap(err, "revoking invite")
+}
+
+type gormSubscriptions struct {
+	db *gorm.DB
+}
+
+func (s gormSubscriptions) Get(ctx context.Context, id uint) (types.PushSubscription, error) {
+	var sub types.PushSubscription
+	err := s.db.WithContext(ctx).First(&sub, "id = ?", id).Error
+	return sub, notFound(err, "finding subscription")
+}
+
+func (s gormSubscriptions) GetForUser(ctx context.Context, id uint, userID uint) (types.PushSubscription, error) {
+	var sub types.PushSubscription
+	err := s.db.WithContext(ctx).First(&sub, "id = ? AND user_id = ?", id, userID).Error
+	return sub, notFound(err, "finding subscription")
+}
+
+func (s gormSubscriptions) Save(ctx context.Context, sub *types.PushSubscription) error {
+	// A removed subscription still holds its endpoint, so it is brought back
+	keepIfSameUser := func(column string) clause.Expr {
+		return gorm.Expr(fmt.Sprintf(
+			"CASE WHEN push_subscriptions.user_id = excluded.user_id AND push_subscriptions.deleted_at IS NULL THEN push_subscriptions.%[1]s ELSE excluded.%[1]s END",
+			column))
+	}
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "endpoint"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"label":            keepIfSameUser("label"),
+			"last_success_at":  keepIfSameUser("last_success_at"),
+			"user_id":          sub.UserID,
+			"p256_dh":          sub.P256DH,
+			"auth":             sub.Auth,
+			"keys":             sub.Keys,
+			"user_agent":       sub.UserAgent,
+			"vapid_public_key": sub.VapidPublicKey,
+			"updated_at":       time.Now(),
+			"deleted_at":       nil,
+		}),
+	}).Create(sub).Error
+	if err != nil {
+		return errors.Wrap(err, "saving subscription")
+	}
+	return errors.Wrap(s.db.WithContext(ctx).First(sub, "endpoint = ?", sub.Endpoint).Error, "loading saved subscription")
+}
+
+func (s gormSubscriptions) Rename(ctx context.Context, sub types.PushSubscription, label string) error {
+	return errors.Wrap(s.db.WithContext(ctx).Model(&sub).Update("label", label).Error, "renaming subscription")
+}
+
+func (s gormSubscriptions) AssignVapidKey(ctx context.Context, publicKey string) error {
+	err := s.db.WithContext(ctx).Model(&types.PushSubscription{}).
+		Where("vapid_public_key = '' OR vapid_public_key IS NULL").
+		Update("vapid_public_key", publicKey).Error
+	return errors.Wrap(err, "assigning VAPID key to subscriptions")
+}
+
+func (s gormSubscriptions) MarkSucceeded(ctx context.Context, sub types.PushSubscription, at time.Time) error {
+	err := s.db.WithContext(ctx).Model(&sub).UpdateColumn("last_success_at", at).Error
+	return errors.Wrap(err, "saving subscription success")
+}
+
+func (s gormSubscriptions) Delete(ctx context.Context, sub types.PushSubscription) error {
+	return errors.Wrap(s.db.WithContext(ctx).Delete(&sub).Error, "removing subscription")
+}
+
+func (s gormSubscriptions) DeleteByEndpoint(ctx context.Context, userID uint, endpoint string) error {
+	result := s.db.WithContext(ctx).Where("user_id = ? AND endpoint = ?", userID, endpoint).Delete(&types.PushSubscription{})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "removing subscription")
+	}
+	if result.RowsAffected == 0 {
+		return errors.Wrap(ErrNotFound, "finding subscription")
+	}
+	return nil
+}
+
+func (s gormSubscriptions) DeleteForUser(ctx context.Context, userID uint) error {
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&types.PushSubscription{}).Error
+	return errors.Wrap(err, "removing subscriptions")
+}
+
+func (s gormSubscriptions) CountByVapidKey(ctx context.Context, publicKey string) (int64, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&types.PushSubscription{}).Where("vapid_public_key = ?", publicKey).Count(&count).Error
+	return count, errors.Wrap(err, "counting devices on VAPID key")
+}
+
+type gormChannels struct {
+	db *gorm.DB
+}
+
+func (s gormChannels) Get(ctx context.Context, id uint) (types.NotificationChannel, error) {
+	var channel types.NotificationChannel
+	err := s.db.WithContext(ctx).First(&channel, "id = ?", id).Error
+	return channel, notFound(err, "finding notification channel")
+}
+
+func (s gormChannels) GetForUser(ctx context.Context, id uint, userID uint) (types.NotificationChannel, error) {
+	var channel types.NotificationChannel
+	err := s.db.WithContext(ctx).First(&channel, "id = ? AND user_id = ?", id, userID).Error
+	return channel, notFound(err, "finding notification channel")
+}
+
+func (s gormChannels) Create(ctx context.Context, channel *types.NotificationChannel) error {
+	return errors.Wrap(s.db.WithContext(ctx).Create(channel).Error, "saving notification channel")
+}
+
+func (s gormChannels) MarkSucceeded(ctx context.Context, channel types.NotificationChannel, at time.Time) error {
+	err := s.db.WithContext(ctx).Model(&channel).UpdateColumn("last_success_at", at).Error
+	return errors.Wrap(err, "saving notification channel success")
+}
+
+func (s gormChannels) Delete(ctx context.Context, channel types.NotificationChannel) error {
+	return errors.Wrap(s.db.WithContext(ctx).Delete(&channel).Error, "removing notification channel")
+}
+
+type gormPushJobs struct {
+	db *gorm.DB
+}
+
+func (s gormPushJobs) List(ctx context.Context, limit int) ([]types.PushJob, error) {
+	jobs := []types.PushJob{}
+	err := s.db.WithContext(ctx).Preload("User").Order("id DESC").Limit(limit).Find(&jobs).Error
+	return jobs, errors.Wrap(err, "finding push jobs")
+}
+
+func (s gormPushJobs) CountByStatus(ctx context.Context, batchID uint) (map[string]int64, error) {
+	rows := []struct {
+		Status string
+		Count  int64
+	}{}
+	query := s.db.WithContext(ctx).Model(&types.PushJob{})
+	if batchID > 0 {
+		query = query.Where("batch_id = ?", batchID)
+	}
+	err := query.Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "counting push jobs")
+	}
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+func (s gormPushJobs) GetBatch(ctx context.Context, id uint) (types.PushBatch, error) {
+	var batch types.PushBatch
+	err := s.db.WithContext(ctx).First(&batch, "id = ?", id).Error
+	return batch, notFound(err, "finding push batch")
+}
+
+func (s gormPushJobs) Retry(ctx context.Context, id uint, at time.Time) error {
+	result := s.db.WithContext(ctx).Model(&types.PushJob{}).
+		Where("id = ? AND status = ?", id, types.PushJobDead).
+		Updates(map[string]interface{}{
+			"status":          types.PushJobPending,
+			"attempts":        0,
+			"next_attempt_at": at,
+			"finished_at":     nil,
+		})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "retrying push job")
+	}
+	if result.RowsAffected == 0 {
+		return errors.Wrap(ErrNotFound, "finding dead push job")
+	}
+	return nil
+}
+
+type gormSnapshots struct {
+	db *gorm.DB
+}
+
+func (s gormSnapshots) Snapshot(ctx context.Context, dest string) error {
+	return backup.Snapshot(ctx, s.db, dest)
+}
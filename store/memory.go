@@ -0,0 +1,1002 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oliverisaac/fanks/backup"
+	"github.com/oliverisaac/fanks/types"
+	"gorm.io/gorm"
+)
+
+// Memory keeps everything in memory. It is a fake for tests, so it only
+// checks the constraints the handlers rely on.
+type Memory struct {
+	mu       sync.Mutex
+	lastID   uint
+	notes    map[uint]types.Note
+	users    map[uint]types.User
+	subs     map[uint]types.PushSubscription
+	channels map[uint]types.NotificationChannel
+	tags     map[string]types.Tag
+	circles  map[uint]types.Circle
+	invites  map[uint]types.CircleInvite
+	jobs     map[uint]types.PushJob
+	batches  map[uint]types.PushBatch
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		notes:    map[uint]types.Note{},
+		users:    map[uint]types.User{},
+		subs:     map[uint]types.PushSubscription{},
+		channels: map[uint]types.NotificationChannel{},
+		tags:     map[string]types.Tag{},
+		circles:  map[uint]types.Circle{},
+		invites:  map[uint]types.CircleInvite{},
+		jobs:     map[uint]types.PushJob{},
+		batches:  map[uint]types.PushBatch{},
+	}
+}
+
+// Stores returns the stores backed by m. Snapshots are not supported.
+func (m *Memory) Stores() Stores {
+	return Stores{
+		Notes:         memoryNotes{m},
+		Users:         memoryUsers{m},
+		Circles:       memoryCircles{m},
+		Subscriptions: memorySubscriptions{m},
+		Channels:      memoryChannels{m},
+		PushJobs:      memoryPushJobs{m},
+		Snapshots:     memorySnapshots{},
+	}
+}
+
+// AddPushBatch saves a batch, the push queue creates them in the database
+func (m *Memory) AddPushBatch(batch *types.PushBatch) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch.ID = m.nextID()
+	batch.CreatedAt = time.Now()
+	m.batches[batch.ID] = *batch
+}
+
+// AddPushJob saves a job, the push queue creates them in the database
+func (m *Memory) AddPushJob(job *types.PushJob) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job.ID = m.nextID()
+	job.CreatedAt = time.Now()
+	m.jobs[job.ID] = *job
+}
+
+func (m *Memory) nextID() uint {
+	m.lastID++
+	return m.lastID
+}
+
+func (m *Memory) isMember(circleID uint, userID uint) bool {
+	_, ok := m.circles[circleID].Member(userID)
+	return ok
+}
+
+func (m *Memory) visible(note types.Note, userID uint) bool {
+	return note.UserID == userID || slices.ContainsFunc(note.Circles, func(c types.Circle) bool {
+		return m.isMember(c.ID, userID)
+	})
+}
+
+// loadNote fills in the associations the database would preload
+func (m *Memory) loadNote(note types.Note) types.Note {
+	note.User = m.users[note.UserID]
+	note.User.PushSubscriptions = nil
+	circles := []types.Circle{}
+	for _, c := range note.Circles {
+		if circle, ok := m.circles[c.ID]; ok {
+			circles = append(circles, circle)
+		}
+	}
+	note.Circles = circles
+	note.Tags = slices.Clone(note.Tags)
+	note.Attachments = slices.Clone(note.Attachments)
+	return note
+}
+
+// loadCircle fills in the users of the members, oldest member first
+func (m *Memory) loadCircle(circle types.Circle) types.Circle {
+	members := slices.Clone(circle.Members)
+	for i := range members {
+		members[i].User = m.users[members[i].UserID]
+	}
+	sort.SliceStable(members, func(i, j int) bool { return members[i].CreatedAt.Before(members[j].CreatedAt) })
+	circle.Members = members
+	return circle
+}
+
+func (m *Memory) resolveTags(tags []types.Tag) []types.Tag {
+	ret := []types.Tag{}
+	for _, t := range tags {
+		tag, ok := m.tags[t.Name]
+		if !ok {
+			tag = types.Tag{Name: t.Name}
+			tag.ID = m.nextID()
+			m.tags[t.Name] = tag
+		}
+		ret = append(ret, tag)
+	}
+	return ret
+}
+
+func newestFirst(notes []types.Note) {
+	sort.SliceStable(notes, func(i, j int) bool {
+		if notes[i].CreatedAt.Equal(notes[j].CreatedAt) {
+			return notes[i].ID > notes[j].ID
+		}
+		return notes[i].CreatedAt.After(notes[j].CreatedAt)
+	})
+}
+
+type memoryNotes struct {
+	m *Memory
+}
+
+func (s memoryNotes) ListForUser(ctx context.Context, userID uint, filter NoteFilter, limit int) ([]types.Note, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []types.Note{}
+	for _, note := range s.m.notes {
+		if note.DeletedAt.Valid || !s.m.visible(note, userID) || !filter.Matches(note) {
+			continue
+		}
+		ret = append(ret, s.m.loadNote(note))
+	}
+	newestFirst(ret)
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
+
+func (s memoryNotes) GetForUser(ctx context.Context, noteID uint, userID uint) (types.Note, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	note, ok := s.m.notes[noteID]
+	if !ok || note.DeletedAt.Valid || !s.m.visible(note, userID) {
+		return types.Note{}, ErrNotFound
+	}
+	return s.m.loadNote(note), nil
+}
+
+func (s memoryNotes) GetByIdempotencyKey(ctx context.Context, userID uint, key string) (*types.Note, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, note := range s.m.notes {
+		if note.UserID == userID && note.IdempotencyKey != nil && *note.IdempotencyKey == key {
+			note = s.m.loadNote(note)
+			return &note, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s memoryNotes) ExpireIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var expired int64
+	for id, note := range s.m.notes {
+		if note.IdempotencyKey != nil && note.CreatedAt.Before(before) {
+			note.IdempotencyKey = nil
+			s.m.notes[id] = note
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (s memoryNotes) Create(ctx context.Context, note *types.Note) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if note.UserID == 0 {
+		note.UserID = note.User.ID
+	}
+	if note.IdempotencyKey != nil {
+		for _, n := range s.m.notes {
+			if n.UserID == note.UserID && n.IdempotencyKey != nil && *n.IdempotencyKey == *note.IdempotencyKey {
+				return fmt.Errorf("saving note: idempotency key %q is already used", *note.IdempotencyKey)
+			}
+		}
+	}
+
+	now := time.Now()
+	note.ID = s.m.nextID()
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = now
+	}
+	note.UpdatedAt = now
+	note.Tags = s.m.resolveTags(note.Tags)
+	for i := range note.Attachments {
+		note.Attachments[i].ID = s.m.nextID()
+		note.Attachments[i].NoteID = note.ID
+	}
+	s.m.notes[note.ID] = *note
+	return nil
+}
+
+func (s memoryNotes) Update(ctx context.Context, note *types.Note) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	saved, ok := s.m.notes[note.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	note.Tags = s.m.resolveTags(note.Tags)
+	saved.Content = note.Content
+	saved.Tags = note.Tags
+	saved.UpdatedAt = time.Now()
+	s.m.notes[note.ID] = saved
+	return nil
+}
+
+func (s memoryNotes) Delete(ctx context.Context, note types.Note) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	saved, ok := s.m.notes[note.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	saved.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.m.notes[note.ID] = saved
+	return nil
+}
+
+func (s memoryNotes) Trash(ctx context.Context, userID uint, since time.Time) ([]types.Note, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []types.Note{}
+	for _, note := range s.m.notes {
+		if note.UserID == userID && note.DeletedAt.Valid && note.DeletedAt.Time.After(since) {
+			ret = append(ret, note)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].DeletedAt.Time.After(ret[j].DeletedAt.Time) })
+	return ret, nil
+}
+
+func (s memoryNotes) Restore(ctx context.Context, noteID uint, userID uint, since time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	note, ok := s.m.notes[noteID]
+	if !ok || note.UserID != userID || !note.DeletedAt.Valid || !note.DeletedAt.Time.After(since) {
+		return ErrNotFound
+	}
+	note.DeletedAt = gorm.DeletedAt{}
+	s.m.notes[noteID] = note
+	return nil
+}
+
+func (s memoryNotes) Audience(ctx context.Context, note types.Note) ([]uint, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []uint{}
+	for _, c := range note.Circles {
+		for _, member := range s.m.circles[c.ID].Members {
+			if !slices.Contains(ret, member.UserID) {
+				ret = append(ret, member.UserID)
+			}
+		}
+	}
+	if !slices.Contains(ret, note.UserID) {
+		ret = append(ret, note.UserID)
+	}
+	return ret, nil
+}
+
+func (s memoryNotes) OnThisDay(ctx context.Context, userID uint, day time.Time) ([]types.Note, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []types.Note{}
+	for _, note := range s.m.notes {
+		created := note.CreatedAt.In(day.Location())
+		if note.DeletedAt.Valid || note.UserID != userID || created.Year() >= day.Year() ||
+			created.Month() != day.Month() || created.Day() != day.Day() {
+			continue
+		}
+		ret = append(ret, s.m.loadNote(note))
+	}
+	newestFirst(ret)
+	return ret, nil
+}
+
+func (s memoryNotes) RandomBefore(ctx context.Context, userID uint, before time.Time) (*types.Note, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	// Map order is random enough for a fake
+	for _, note := range s.m.notes {
+		if !note.DeletedAt.Valid && note.UserID == userID && note.CreatedAt.Before(before) {
+			note = s.m.loadNote(note)
+			return &note, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s memoryNotes) Moods(ctx context.Context, userID uint, since time.Time) ([]types.Note, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []types.Note{}
+	for _, note := range s.m.notes {
+		if !note.DeletedAt.Valid && note.UserID == userID && note.Mood > 0 && !note.CreatedAt.Before(since) {
+			ret = append(ret, note)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].CreatedAt.Before(ret[j].CreatedAt) })
+	return ret, nil
+}
+
+func (s memoryNotes) CreationTimes(ctx context.Context, userID uint) ([]time.Time, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []time.Time{}
+	for _, note := range s.m.notes {
+		if !note.DeletedAt.Valid && note.UserID == userID {
+			ret = append(ret, note.CreatedAt)
+		}
+	}
+	return ret, nil
+}
+
+func (s memoryNotes) TagCounts(ctx context.Context, userID uint) ([]types.TagCount, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	counts := map[string]int64{}
+	for _, note := range s.m.notes {
+		if note.DeletedAt.Valid || !s.m.visible(note, userID) {
+			continue
+		}
+		for _, tag := range note.Tags {
+			counts[tag.Name]++
+		}
+	}
+	ret := []types.TagCount{}
+	for name, count := range counts {
+		ret = append(ret, types.TagCount{Name: name, Count: count})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count == ret[j].Count {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Count > ret[j].Count
+	})
+	return ret, nil
+}
+
+func (s memoryNotes) GetAttachment(ctx context.Context, id uint) (types.Attachment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, note := range s.m.notes {
+		for _, a := range note.Attachments {
+			if a.ID == id {
+				return a, nil
+			}
+		}
+	}
+	return types.Attachment{}, ErrNotFound
+}
+
+type memoryUsers struct {
+	m *Memory
+}
+
+// loadUser fills in the push subscriptions and notification channels of the user
+func (m *Memory) loadUser(user types.User) types.User {
+	user.PushSubscriptions = []types.PushSubscription{}
+	for _, sub := range m.subs {
+		if sub.UserID == user.ID {
+			user.PushSubscriptions = append(user.PushSubscriptions, sub)
+		}
+	}
+	sort.Slice(user.PushSubscriptions, func(i, j int) bool {
+		return user.PushSubscriptions[i].ID < user.PushSubscriptions[j].ID
+	})
+	user.NotificationChannels = []types.NotificationChannel{}
+	for _, channel := range m.channels {
+		if channel.UserID == user.ID {
+			user.NotificationChannels = append(user.NotificationChannels, channel)
+		}
+	}
+	sort.Slice(user.NotificationChannels, func(i, j int) bool {
+		return user.NotificationChannels[i].ID < user.NotificationChannels[j].ID
+	})
+	return user
+}
+
+func (s memoryUsers) Get(ctx context.Context, id uint) (types.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	user, ok := s.m.users[id]
+	if !ok {
+		return types.User{}, ErrNotFound
+	}
+	return s.m.loadUser(user), nil
+}
+
+func (s memoryUsers) GetByEmail(ctx context.Context, email string) (types.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, user := range s.m.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return types.User{}, ErrNotFound
+}
+
+func (s memoryUsers) Count(ctx context.Context) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	return int64(len(s.m.users)), nil
+}
+
+func (s memoryUsers) Create(ctx context.Context, user *types.User) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, u := range s.m.users {
+		if u.Email == user.Email {
+			return fmt.Errorf("Create user error: email %q is already used", user.Email)
+		}
+	}
+	user.ID = s.m.nextID()
+	s.m.users[user.ID] = *user
+	return nil
+}
+
+func (s memoryUsers) UpdateSettings(ctx context.Context, user types.User) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	saved, ok := s.m.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	saved.WeeklyMemories = user.WeeklyMemories
+	saved.DigestFrequency = user.DigestFrequency
+	saved.DigestLastSentAt = user.DigestLastSentAt
+	saved.EmailReminders = user.EmailReminders
+	saved.EmailReminderLastSentAt = user.EmailReminderLastSentAt
+	s.m.users[user.ID] = saved
+	return nil
+}
+
+func (s memoryUsers) Circles(ctx context.Context, userID uint) ([]types.Circle, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []types.Circle{}
+	for _, circle := range s.m.circles {
+		if s.m.isMember(circle.ID, userID) {
+			ret = append(ret, circle)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	for i, circle := range ret {
+		ret[i] = s.m.loadCircle(circle)
+	}
+	return ret, nil
+}
+
+func (s memoryUsers) ListWithSubscriptions(ctx context.Context) ([]types.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []types.User{}
+	for _, user := range s.m.users {
+		ret = append(ret, s.m.loadUser(user))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret, nil
+}
+
+// listUsers returns the users which pass keep, by ID
+func (m *Memory) listUsers(keep func(types.User) bool) []types.User {
+	ret := []types.User{}
+	for _, user := range m.users {
+		if keep(user) {
+			ret = append(ret, user)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+func (s memoryUsers) ListWithDigests(ctx context.Context) ([]types.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	return s.m.listUsers(func(u types.User) bool {
+		return u.DigestFrequency == types.DigestWeekly || u.DigestFrequency == types.DigestMonthly
+	}), nil
+}
+
+func (s memoryUsers) ListWithEmailReminders(ctx context.Context) ([]types.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	return s.m.listUsers(func(u types.User) bool { return u.EmailReminders }), nil
+}
+
+func (s memoryUsers) MarkDigestSent(ctx context.Context, user types.User, at time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	saved, ok := s.m.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	saved.DigestLastSentAt = &at
+	s.m.users[user.ID] = saved
+	return nil
+}
+
+func (s memoryUsers) MarkReminderEmailSent(ctx context.Context, user types.User, at time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	saved, ok := s.m.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	saved.EmailReminderLastSentAt = &at
+	s.m.users[user.ID] = saved
+	return nil
+}
+
+func (s memoryUsers) SkipReminders(ctx context.Context, user types.User, until time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	saved, ok := s.m.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	saved.RemindersSkippedUntil = &until
+	s.m.users[user.ID] = saved
+	return nil
+}
+
+type memoryCircles struct {
+	m *Memory
+}
+
+func (s memoryCircles) GetForUser(ctx context.Context, circleID uint, userID uint) (types.Circle, types.CircleMember, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	circle, ok := s.m.circles[circleID]
+	if !ok {
+		return circle, types.CircleMember{}, ErrNotFound
+	}
+	circle = s.m.loadCircle(circle)
+	circle.Invites = []types.CircleInvite{}
+	for _, invite := range s.m.invites {
+		if invite.CircleID == circle.ID && !invite.Expired() {
+			circle.Invites = append(circle.Invites, invite)
+		}
+	}
+	sort.Slice(circle.Invites, func(i, j int) bool { return circle.Invites[i].ID < circle.Invites[j].ID })
+
+	member, ok := circle.Member(userID)
+	if !ok {
+		return circle, member, ErrNotFound
+	}
+	return circle, member, nil
+}
+
+func (s memoryCircles) Create(ctx context.Context, circle *types.Circle) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := time.Now()
+	circle.ID = s.m.nextID()
+	circle.CreatedAt = now
+	for i := range circle.Members {
+		circle.Members[i].CircleID = circle.ID
+		circle.Members[i].CreatedAt = now
+	}
+	s.m.circles[circle.ID] = *circle
+	return nil
+}
+
+func (s memoryCircles) Rename(ctx context.Context, circle types.Circle, name string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	saved, ok := s.m.circles[circle.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	saved.Name = name
+	s.m.circles[circle.ID] = saved
+	return nil
+}
+
+func (s memoryCircles) Delete(ctx context.Context, circle types.Circle) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for id, note := range s.m.notes {
+		note.Circles = slices.DeleteFunc(slices.Clone(note.Circles), func(c types.Circle) bool { return c.ID == circle.ID })
+		s.m.notes[id] = note
+	}
+	for id, invite := range s.m.invites {
+		if invite.CircleID == circle.ID {
+			delete(s.m.invites, id)
+		}
+	}
+	delete(s.m.circles, circle.ID)
+	return nil
+}
+
+func (s memoryCircles) AddMember(ctx context.Context, member types.CircleMember) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	circle, ok := s.m.circles[member.CircleID]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := circle.Member(member.UserID); ok {
+		return nil
+	}
+	member.CreatedAt = time.Now()
+	circle.Members = append(slices.Clone(circle.Members), member)
+	s.m.circles[circle.ID] = circle
+	return nil
+}
+
+func (s memoryCircles) SetRole(ctx context.Context, circleID uint, userID uint, role string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	circle := s.m.circles[circleID]
+	circle.Members = slices.Clone(circle.Members)
+	for i, member := range circle.Members {
+		if member.UserID == userID {
+			circle.Members[i].Role = role
+		}
+	}
+	s.m.circles[circleID] = circle
+	return nil
+}
+
+func (s memoryCircles) RemoveMember(ctx context.Context, circleID uint, userID uint) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	circle, ok := s.m.circles[circleID]
+	if !ok {
+		return nil
+	}
+	circle.Members = slices.DeleteFunc(slices.Clone(circle.Members), func(m types.CircleMember) bool { return m.UserID == userID })
+	s.m.circles[circleID] = circle
+	return nil
+}
+
+func (s memoryCircles) CreateInvite(ctx context.Context, invite *types.CircleInvite) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, existing := range s.m.invites {
+		if existing.Token == invite.Token {
+			return fmt.Errorf("creating invite: token is already used")
+		}
+	}
+	invite.ID = s.m.nextID()
+	invite.CreatedAt = time.Now()
+	s.m.invites[invite.ID] = *invite
+	return nil
+}
+
+func (s memoryCircles) GetInvite(ctx context.Context, token string) (types.CircleInvite, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, invite := range s.m.invites {
+		if invite.Token != token {
+			continue
+		}
+		if invite.Expired() {
+			return invite, ErrNotFound
+		}
+		invite.Circle = s.m.circles[invite.CircleID]
+		invite.InvitedBy = s.m.users[invite.InvitedByID]
+		return invite, nil
+	}
+	return types.CircleInvite{}, ErrNotFound
+}
+
+func (s memoryCircles) DeleteInvite(ctx context.Context, circleID uint, inviteID uint) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if invite, ok := s.m.invites[inviteID]; ok && invite.CircleID == circleID {
+		delete(s.m.invites, inviteID)
+	}
+	return nil
+}
+
+type memorySubscriptions struct {
+	m *Memory
+}
+
+func (s memorySubscriptions) Get(ctx context.Context, id uint) (types.PushSubscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	sub, ok := s.m.subs[id]
+	if !ok {
+		return types.PushSubscription{}, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (s memorySubscriptions) GetForUser(ctx context.Context, id uint, userID uint) (types.PushSubscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	sub, ok := s.m.subs[id]
+	if !ok || sub.UserID != userID {
+		return types.PushSubscription{}, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (s memorySubscriptions) Save(ctx context.Context, sub *types.PushSubscription) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, existing := range s.m.subs {
+		if existing.Endpoint != sub.Endpoint {
+			continue
+		}
+		sub.ID, sub.CreatedAt = existing.ID, existing.CreatedAt
+		if existing.UserID == sub.UserID {
+			sub.Label, sub.LastSuccessAt = existing.Label, existing.LastSuccessAt
+		}
+		s.m.subs[sub.ID] = *sub
+		return nil
+	}
+
+	sub.ID = s.m.nextID()
+	sub.CreatedAt = time.Now()
+	s.m.subs[sub.ID] = *sub
+	return nil
+}
+
+func (s memorySubscriptions) Rename(ctx context.Context, sub types.PushSubscription, label string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if existing, ok := s.m.subs[sub.ID]; ok {
+		existing.Label = label
+		s.m.subs[sub.ID] = existing
+	}
+	return nil
+}
+
+func (s memorySubscriptions) AssignVapidKey(ctx context.Context, publicKey string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for id, sub := range s.m.subs {
+		if sub.VapidPublicKey == "" {
+			sub.VapidPublicKey = publicKey
+			s.m.subs[id] = sub
+		}
+	}
+	return nil
+}
+
+func (s memorySubscriptions) MarkSucceeded(ctx context.Context, sub types.PushSubscription, at time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if existing, ok := s.m.subs[sub.ID]; ok {
+		existing.LastSuccessAt = &at
+		s.m.subs[sub.ID] = existing
+	}
+	return nil
+}
+
+func (s memorySubscriptions) Delete(ctx context.Context, sub types.PushSubscription) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	delete(s.m.subs, sub.ID)
+	return nil
+}
+
+func (s memorySubscriptions) DeleteByEndpoint(ctx context.Context, userID uint, endpoint string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for id, sub := range s.m.subs {
+		if sub.UserID == userID && sub.Endpoint == endpoint {
+			delete(s.m.subs, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s memorySubscriptions) DeleteForUser(ctx context.Context, userID uint) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for id, sub := range s.m.subs {
+		if sub.UserID == userID {
+			delete(s.m.subs, id)
+		}
+	}
+	return nil
+}
+
+func (s memorySubscriptions) CountByVapidKey(ctx context.Context, publicKey string) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var count int64
+	for _, sub := range s.m.subs {
+		if sub.VapidPublicKey == publicKey {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type memoryChannels struct {
+	m *Memory
+}
+
+func (s memoryChannels) Get(ctx context.Context, id uint) (types.NotificationChannel, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	channel, ok := s.m.channels[id]
+	if !ok {
+		return types.NotificationChannel{}, ErrNotFound
+	}
+	return channel, nil
+}
+
+func (s memoryChannels) GetForUser(ctx context.Context, id uint, userID uint) (types.NotificationChannel, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	channel, ok := s.m.channels[id]
+	if !ok || channel.UserID != userID {
+		return types.NotificationChannel{}, ErrNotFound
+	}
+	return channel, nil
+}
+
+func (s memoryChannels) Create(ctx context.Context, channel *types.NotificationChannel) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	channel.ID = s.m.nextID()
+	channel.CreatedAt = time.Now()
+	s.m.channels[channel.ID] = *channel
+	return nil
+}
+
+func (s memoryChannels) MarkSucceeded(ctx context.Context, channel types.NotificationChannel, at time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if existing, ok := s.m.channels[channel.ID]; ok {
+		existing.LastSuccessAt = &at
+		s.m.channels[channel.ID] = existing
+	}
+	return nil
+}
+
+func (s memoryChannels) Delete(ctx context.Context, channel types.NotificationChannel) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	delete(s.m.channels, channel.ID)
+	return nil
+}
+
+type memoryPushJobs struct {
+	m *Memory
+}
+
+func (s memoryPushJobs) List(ctx context.Context, limit int) ([]types.PushJob, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	ret := []types.PushJob{}
+	for _, job := range s.m.jobs {
+		job.User = s.m.users[job.UserID]
+		ret = append(ret, job)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID > ret[j].ID })
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
+
+func (s memoryPushJobs) CountByStatus(ctx context.Context, batchID uint) (map[string]int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	counts := map[string]int64{}
+	for _, job := range s.m.jobs {
+		if batchID > 0 && (job.BatchID == nil || *job.BatchID != batchID) {
+			continue
+		}
+		counts[job.Status]++
+	}
+	return counts, nil
+}
+
+func (s memoryPushJobs) GetBatch(ctx context.Context, id uint) (types.PushBatch, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	batch, ok := s.m.batches[id]
+	if !ok {
+		return types.PushBatch{}, ErrNotFound
+	}
+	return batch, nil
+}
+
+func (s memoryPushJobs) Retry(ctx context.Context, id uint, at time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	job, ok := s.m.jobs[id]
+	if !ok || job.Status != types.PushJobDead {
+		return ErrNotFound
+	}
+	job.Status = types.PushJobPending
+	job.Attempts = 0
+	job.NextAttemptAt = at
+	job.FinishedAt = nil
+	s.m.jobs[id] = job
+	return nil
+}
+
+type memorySnapshots struct{}
+
+func (memorySnapshots) Snapshot(ctx context.Context, dest string) error {
+	return backup.ErrUnsupported
+}
@@ -0,0 +1,212 @@
+// Package store loads and saves notes, users, circles, push subscriptions,
+// notification channels and push jobs for the handlers. GORM implements it for the real database and Memory is a fake
+// which keeps everything in memory.
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+// NoteFilter narrows a list of notes to those shared with a circle, with a
+// tag, written by or not by a user and/or created in a period
+type NoteFilter struct {
+	CircleID uint
+	Tag      string
+	AuthorID uint
+	// NotAuthorID leaves out the notes the user wrote
+	NotAuthorID uint
+	// From and Until limit the notes to those created at or after From and
+	// before Until, when they are set
+	From  time.Time
+	Until time.Time
+}
+
+// Matches reports whether a loaded note passes the filter
+func (f NoteFilter) Matches(note types.Note) bool {
+	if f.CircleID > 0 && !slices.ContainsFunc(note.Circles, func(c types.Circle) bool { return c.ID == f.CircleID }) {
+		return false
+	}
+	if f.Tag != "" && !slices.ContainsFunc(note.Tags, func(t types.Tag) bool { return t.Name == f.Tag }) {
+		return false
+	}
+	if f.AuthorID > 0 && note.UserID != f.AuthorID {
+		return false
+	}
+	if f.NotAuthorID > 0 && note.UserID == f.NotAuthorID {
+		return false
+	}
+	if !f.From.IsZero() && note.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.Until.IsZero() && !note.CreatedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+type NoteStore interface {
+	// ListForUser returns the latest notes visible to the user which match
+	// the filter, newest first. A limit of 0 returns all of them.
+	ListForUser(ctx context.Context, userID uint, filter NoteFilter, limit int) ([]types.Note, error)
+	// GetForUser returns ErrNotFound unless the user wrote the note or it was
+	// shared with a circle the user belongs to
+	GetForUser(ctx context.Context, noteID uint, userID uint) (types.Note, error)
+	// GetByIdempotencyKey returns the note the user created with the key, or
+	// nil. The note may have been moved to the trash since.
+	GetByIdempotencyKey(ctx context.Context, userID uint, key string) (*types.Note, error)
+	// ExpireIdempotencyKeys forgets the keys of notes created before the
+	// given time, so they can be used again
+	ExpireIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+	// Create saves a new note with its attachments, circles and tags. Tags are
+	// matched by name and created when they do not exist yet.
+	Create(ctx context.Context, note *types.Note) error
+	// Update saves the content of a note and replaces its tags
+	Update(ctx context.Context, note *types.Note) error
+	// Delete moves a note to the trash
+	Delete(ctx context.Context, note types.Note) error
+	// Trash returns the user's notes deleted after since, newest first
+	Trash(ctx context.Context, userID uint, since time.Time) ([]types.Note, error)
+	// Restore takes a note deleted after since out of the trash, it returns
+	// ErrNotFound if there is no such note
+	Restore(ctx context.Context, noteID uint, userID uint, since time.Time) error
+	// Audience returns the IDs of the author and every member of the circles the note is shared with
+	Audience(ctx context.Context, note types.Note) ([]uint, error)
+	// OnThisDay returns the notes the user wrote on the calendar date of day
+	// in previous years, newest first
+	OnThisDay(ctx context.Context, userID uint, day time.Time) ([]types.Note, error)
+	// RandomBefore returns one of the notes the user wrote before the given
+	// time, or nil if there are none
+	RandomBefore(ctx context.Context, userID uint, before time.Time) (*types.Note, error)
+	// Moods returns the notes the user rated their mood on since the given
+	// time, oldest first
+	Moods(ctx context.Context, userID uint, since time.Time) ([]types.Note, error)
+	// CreationTimes returns when each of the user's notes was written
+	CreationTimes(ctx context.Context, userID uint) ([]time.Time, error)
+	// TagCounts counts the notes visible to the user by tag, most used first
+	TagCounts(ctx context.Context, userID uint) ([]types.TagCount, error)
+	// GetAttachment returns the attachment whatever note it belongs to, callers
+	// check the user may see the note
+	GetAttachment(ctx context.Context, id uint) (types.Attachment, error)
+}
+
+type UserStore interface {
+	// Get returns the user along with their push subscriptions and notification channels
+	Get(ctx context.Context, id uint) (types.User, error)
+	GetByEmail(ctx context.Context, email string) (types.User, error)
+	Count(ctx context.Context) (int64, error)
+	Create(ctx context.Context, user *types.User) error
+	// UpdateSettings saves the memories, digest and email reminder settings of the user
+	UpdateSettings(ctx context.Context, user types.User) error
+	// Circles returns the circles the user is a member of, by name
+	Circles(ctx context.Context, userID uint) ([]types.Circle, error)
+	// ListWithSubscriptions returns every user along with their push
+	// subscriptions and notification channels
+	ListWithSubscriptions(ctx context.Context) ([]types.User, error)
+	// ListWithDigests returns the users who chose a weekly or monthly digest
+	ListWithDigests(ctx context.Context) ([]types.User, error)
+	// ListWithEmailReminders returns the users who want the daily reminder by email
+	ListWithEmailReminders(ctx context.Context) ([]types.User, error)
+	// MarkDigestSent records when the user was last sent their digest
+	MarkDigestSent(ctx context.Context, user types.User, at time.Time) error
+	// MarkReminderEmailSent records when the user was last sent the reminder email
+	MarkReminderEmailSent(ctx context.Context, user types.User, at time.Time) error
+	// SkipReminders records that the user wants no reminders before until
+	SkipReminders(ctx context.Context, user types.User, until time.Time) error
+}
+
+type CircleStore interface {
+	// GetForUser returns the circle with its members, oldest first, and its
+	// open invites, along with the membership of the user. It returns
+	// ErrNotFound unless the user is a member.
+	GetForUser(ctx context.Context, circleID uint, userID uint) (types.Circle, types.CircleMember, error)
+	// Create saves a new circle along with its members
+	Create(ctx context.Context, circle *types.Circle) error
+	Rename(ctx context.Context, circle types.Circle, name string) error
+	// Delete removes the circle along with its members and invites, the notes
+	// shared with it are kept
+	Delete(ctx context.Context, circle types.Circle) error
+	// AddMember makes the user a member unless they already are one
+	AddMember(ctx context.Context, member types.CircleMember) error
+	SetRole(ctx context.Context, circleID uint, userID uint, role string) error
+	RemoveMember(ctx context.Context, circleID uint, userID uint) error
+	CreateInvite(ctx context.Context, invite *types.CircleInvite) error
+	// GetInvite returns the invite along with its circle and who sent it. It
+	// returns ErrNotFound if the invite does not exist or has expired.
+	GetInvite(ctx context.Context, token string) (types.CircleInvite, error)
+	DeleteInvite(ctx context.Context, circleID uint, inviteID uint) error
+}
+
+type SubscriptionStore interface {
+	Get(ctx context.Context, id uint) (types.PushSubscription, error)
+	// GetForUser returns ErrNotFound unless the subscription belongs to the user
+	GetForUser(ctx context.Context, id uint, userID uint) (types.PushSubscription, error)
+	// Save creates the subscription, or takes over the one with the same
+	// endpoint. The label and last success of the device are kept if it
+	// already belonged to the same user.
+	Save(ctx context.Context, sub *types.PushSubscription) error
+	// Rename sets the label the user gave the device
+	Rename(ctx context.Context, sub types.PushSubscription, label string) error
+	// AssignVapidKey sets the VAPID key of the subscriptions which were saved
+	// before keys were recorded
+	AssignVapidKey(ctx context.Context, publicKey string) error
+	// MarkSucceeded records when a push last reached the device
+	MarkSucceeded(ctx context.Context, sub types.PushSubscription, at time.Time) error
+	Delete(ctx context.Context, sub types.PushSubscription) error
+	// DeleteByEndpoint removes the user's subscription with the endpoint, it
+	// returns ErrNotFound if there is none
+	DeleteByEndpoint(ctx context.Context, userID uint, endpoint string) error
+	// DeleteForUser removes the subscriptions of all of the user's devices
+	DeleteForUser(ctx context.Context, userID uint) error
+	// CountByVapidKey counts the devices which subscribed with the key
+	CountByVapidKey(ctx context.Context, publicKey string) (int64, error)
+}
+
+type ChannelStore interface {
+	Get(ctx context.Context, id uint) (types.NotificationChannel, error)
+	// GetForUser returns ErrNotFound unless the channel belongs to the user
+	GetForUser(ctx context.Context, id uint, userID uint) (types.NotificationChannel, error)
+	Create(ctx context.Context, channel *types.NotificationChannel) error
+	// MarkSucceeded records when a notification last reached the channel
+	MarkSucceeded(ctx context.Context, channel types.NotificationChannel, at time.Time) error
+	Delete(ctx context.Context, channel types.NotificationChannel) error
+}
+
+// PushJobStore lets admins follow and retry the jobs of the push queue. The
+// queue itself works on the database directly, as it locks jobs while it
+// sends them.
+type PushJobStore interface {
+	// List returns the newest jobs along with who they are for
+	List(ctx context.Context, limit int) ([]types.PushJob, error)
+	// CountByStatus counts the jobs of a batch by status, or all jobs when
+	// batchID is 0
+	CountByStatus(ctx context.Context, batchID uint) (map[string]int64, error)
+	GetBatch(ctx context.Context, id uint) (types.PushBatch, error)
+	// Retry gives a dead-lettered job a fresh set of attempts from the given
+	// time, it returns ErrNotFound unless the job is dead
+	Retry(ctx context.Context, id uint, at time.Time) error
+}
+
+// Snapshotter copies the whole database to a file
+type Snapshotter interface {
+	// Snapshot writes a consistent copy of the database to dest, which must
+	// not exist yet
+	Snapshot(ctx context.Context, dest string) error
+}
+
+// Stores bundles one implementation of each store
+type Stores struct {
+	Notes         NoteStore
+	Users         UserStore
+	Circles       CircleStore
+	Subscriptions SubscriptionStore
+	Channels      ChannelStore
+	PushJobs      PushJobStore
+	Snapshots     Snapshotter
+}
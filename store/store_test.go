@@ -0,0 +1,487 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/fanks/dbtest"
+	"github.com/oliverisaac/fanks/migrations"
+	"github.com/oliverisaac/fanks/types"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetLevel(logrus.WarnLevel)
+}
+
+// backends are the implementations every test runs against. Each one is
+// empty when it is opened.
+var backends = map[string]func(t *testing.T) Stores{
+	"memory":   func(t *testing.T) Stores { return NewMemory().Stores() },
+	"sqlite":   func(t *testing.T) Stores { return gormBackend(t, "sqlite") },
+	"postgres": func(t *testing.T) Stores { return gormBackend(t, "postgres") },
+}
+
+func gormBackend(t *testing.T, dialect string) Stores {
+	db := dbtest.Dialects[dialect](t)
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	return NewGORM(db)
+}
+
+// eachBackend runs the test against every backend
+func eachBackend(t *testing.T, test func(t *testing.T, b Stores)) {
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			test(t, open(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, b Stores, email string) types.User {
+	t.Helper()
+	user := types.User{Name: email, Email: email}
+	if err := b.Users.Create(t.Context(), &user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// createCircle saves a circle which the first user owns and the others are members of
+func createCircle(t *testing.T, b Stores, name string, owner uint, members ...uint) types.Circle {
+	t.Helper()
+	circle := types.Circle{Name: name, Members: []types.CircleMember{{UserID: owner, Role: types.CircleRoleOwner}}}
+	for _, id := range members {
+		circle.Members = append(circle.Members, types.CircleMember{UserID: id, Role: types.CircleRoleMember})
+	}
+	if err := b.Circles.Create(t.Context(), &circle); err != nil {
+		t.Fatal(err)
+	}
+	return circle
+}
+
+func createNote(t *testing.T, b Stores, note types.Note) types.Note {
+	t.Helper()
+	if err := b.Notes.Create(t.Context(), &note); err != nil {
+		t.Fatal(err)
+	}
+	return note
+}
+
+func writtenAt(note types.Note, at time.Time) types.Note {
+	note.CreatedAt = at
+	return note
+}
+
+func noteIDs(notes []types.Note) []uint {
+	ret := []uint{}
+	for _, n := range notes {
+		ret = append(ret, n.ID)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+func TestNoteVisibility(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Stores) {
+		alice := createUser(t, b, "alice@example.com")
+		bob := createUser(t, b, "bob@example.com")
+		carol := createUser(t, b, "carol@example.com")
+		family := createCircle(t, b, "Family", alice.ID, bob.ID)
+
+		shared := createNote(t, b, types.Note{
+			UserID:  alice.ID,
+			Content: "a sunny day #outside",
+			Circles: []types.Circle{family},
+			Tags:    []types.Tag{{Name: "outside"}},
+		})
+		private := createNote(t, b, types.Note{UserID: alice.ID, Content: "just for me"})
+
+		tests := []struct {
+			name   string
+			user   types.User
+			filter NoteFilter
+			want   []uint
+		}{
+			{"author sees everything", alice, NoteFilter{}, noteIDs([]types.Note{shared, private})},
+			{"member sees the shared note", bob, NoteFilter{}, []uint{shared.ID}},
+			{"outsider sees nothing", carol, NoteFilter{}, []uint{}},
+			{"circle filter", alice, NoteFilter{CircleID: family.ID}, []uint{shared.ID}},
+			{"tag filter", bob, NoteFilter{Tag: "outside"}, []uint{shared.ID}},
+			{"unknown tag", alice, NoteFilter{Tag: "inside"}, []uint{}},
+		}
+		for _, tt := range tests {
+			notes, err := b.Notes.ListForUser(t.Context(), tt.user.ID, tt.filter, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := noteIDs(notes); !slices.Equal(got, tt.want) {
+				t.Errorf("%s: got notes %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		note, err := b.Notes.GetForUser(t.Context(), shared.ID, bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if note.User.ID != alice.ID || len(note.Tags) != 1 || note.Tags[0].Name != "outside" {
+			t.Errorf("note was loaded with author %d and tags %v", note.User.ID, note.Tags)
+		}
+		if _, err := b.Notes.GetForUser(t.Context(), shared.ID, carol.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("outsider getting the note got %v, want ErrNotFound", err)
+		}
+
+		audience, err := b.Notes.Audience(t.Context(), shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(audience)
+		if want := []uint{alice.ID, bob.ID}; !slices.Equal(audience, want) {
+			t.Errorf("audience is %v, want %v", audience, want)
+		}
+
+		// Tags are shared by name
+		again := createNote(t, b, types.Note{UserID: bob.ID, Content: "#outside again", Tags: []types.Tag{{Name: "outside"}}})
+		if again.Tags[0].ID != shared.Tags[0].ID {
+			t.Errorf("the second #outside note got tag %d, want %d", again.Tags[0].ID, shared.Tags[0].ID)
+		}
+	})
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Stores) {
+		alice := createUser(t, b, "alice@example.com")
+		bob := createUser(t, b, "bob@example.com")
+		key := "draft-1"
+
+		first := createNote(t, b, types.Note{UserID: alice.ID, Content: "once", IdempotencyKey: &key})
+		if err := b.Notes.Create(t.Context(), &types.Note{UserID: alice.ID, Content: "twice", IdempotencyKey: &key}); err == nil {
+			t.Error("a second note with the same key was saved")
+		}
+		// Keys only need to be unique per user
+		createNote(t, b, types.Note{UserID: bob.ID, Content: "once", IdempotencyKey: &key})
+
+		// Deleted notes keep their key, or the unique index would reject the replay
+		if err := b.Notes.Delete(t.Context(), first); err != nil {
+			t.Fatal(err)
+		}
+		found, err := b.Notes.GetByIdempotencyKey(t.Context(), alice.ID, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found == nil || found.ID != first.ID || !found.DeletedAt.Valid {
+			t.Errorf("looking up the key of a deleted note found %+v", found)
+		}
+
+		expired, err := b.Notes.ExpireIdempotencyKeys(t.Context(), time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expired != 0 {
+			t.Errorf("expired %d keys of notes from the last hour", expired)
+		}
+		expired, err = b.Notes.ExpireIdempotencyKeys(t.Context(), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expired != 2 {
+			t.Errorf("expired %d keys, want 2", expired)
+		}
+		if found, err := b.Notes.GetByIdempotencyKey(t.Context(), alice.ID, key); err != nil || found != nil {
+			t.Errorf("looking up an expired key found %+v, %v", found, err)
+		}
+		createNote(t, b, types.Note{UserID: alice.ID, Content: "again", IdempotencyKey: &key})
+	})
+}
+
+func TestTrash(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Stores) {
+		alice := createUser(t, b, "alice@example.com")
+		bob := createUser(t, b, "bob@example.com")
+		note := createNote(t, b, types.Note{UserID: alice.ID, Content: "oops"})
+		if err := b.Notes.Delete(t.Context(), note); err != nil {
+			t.Fatal(err)
+		}
+
+		hourAgo := time.Now().Add(-time.Hour)
+		trash, err := b.Notes.Trash(t.Context(), alice.ID, hourAgo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := noteIDs(trash); !slices.Equal(got, []uint{note.ID}) {
+			t.Errorf("trash holds %v, want the deleted note", got)
+		}
+		trash, err = b.Notes.Trash(t.Context(), alice.ID, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trash) != 0 {
+			t.Errorf("trash holds %d notes deleted before the retention, want none", len(trash))
+		}
+
+		if err := b.Notes.Restore(t.Context(), note.ID, bob.ID, hourAgo); !errors.Is(err, ErrNotFound) {
+			t.Errorf("restoring someone else's note got %v, want ErrNotFound", err)
+		}
+		if err := b.Notes.Restore(t.Context(), note.ID, alice.ID, time.Now().Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("restoring a purged note got %v, want ErrNotFound", err)
+		}
+		if err := b.Notes.Restore(t.Context(), note.ID, alice.ID, hourAgo); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Notes.GetForUser(t.Context(), note.ID, alice.ID); err != nil {
+			t.Errorf("the restored note is not back: %v", err)
+		}
+	})
+}
+
+func TestSaveSubscription(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Stores) {
+		alice := createUser(t, b, "alice@example.com")
+		bob := createUser(t, b, "bob@example.com")
+		endpoint := "https://push.example.com/device"
+
+		sub := types.PushSubscription{UserID: alice.ID, Endpoint: endpoint, Auth: "one"}
+		if err := b.Subscriptions.Save(t.Context(), &sub); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Subscriptions.Rename(t.Context(), sub, "phone"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Subscribing again refreshes the keys and keeps the label
+		again := types.PushSubscription{UserID: alice.ID, Endpoint: endpoint, Auth: "two"}
+		if err := b.Subscriptions.Save(t.Context(), &again); err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.Subscriptions.GetForUser(t.Context(), sub.ID, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.ID != sub.ID || got.Label != "phone" || got.Auth != "two" {
+			t.Errorf("resubscribing saved %d with label %q and auth %q, want %d with label phone and auth two", again.ID, got.Label, got.Auth, sub.ID)
+		}
+
+		// Another user signing in on the device takes it over
+		takeover := types.PushSubscription{UserID: bob.ID, Endpoint: endpoint, Auth: "three"}
+		if err := b.Subscriptions.Save(t.Context(), &takeover); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Subscriptions.GetForUser(t.Context(), sub.ID, alice.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("the device still belongs to the first user: %v", err)
+		}
+		got, err = b.Subscriptions.GetForUser(t.Context(), takeover.ID, bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Label != "" {
+			t.Errorf("the new owner sees the label %q of the old one", got.Label)
+		}
+
+		// A removed subscription can be saved again
+		if err := b.Subscriptions.DeleteByEndpoint(t.Context(), bob.ID, endpoint); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Subscriptions.DeleteByEndpoint(t.Context(), bob.ID, endpoint); !errors.Is(err, ErrNotFound) {
+			t.Errorf("removing the subscription twice got %v, want ErrNotFound", err)
+		}
+		back := types.PushSubscription{UserID: bob.ID, Endpoint: endpoint}
+		if err := b.Subscriptions.Save(t.Context(), &back); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Subscriptions.GetForUser(t.Context(), back.ID, bob.ID); err != nil {
+			t.Errorf("the subscription saved after removing it is missing: %v", err)
+		}
+	})
+}
+
+func TestDateQueries(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Stores) {
+		alice := createUser(t, b, "alice@example.com")
+		bob := createUser(t, b, "bob@example.com")
+		friends := createCircle(t, b, "Friends", alice.ID, bob.ID)
+
+		day := time.Date(2026, time.June, 15, 9, 0, 0, 0, time.Local)
+		at := func(year int, month time.Month, d, hour, min int) time.Time {
+			return time.Date(year, month, d, hour, min, 0, 0, time.Local)
+		}
+		lastYear := createNote(t, b, writtenAt(types.Note{UserID: alice.ID, Content: "last year #sun", Mood: 4, Tags: []types.Tag{{Name: "sun"}}}, at(2025, time.June, 15, 12, 0)))
+		twoYears := createNote(t, b, writtenAt(types.Note{UserID: alice.ID, Content: "two years ago #sun", Tags: []types.Tag{{Name: "sun"}}}, at(2024, time.June, 15, 23, 30)))
+		createNote(t, b, writtenAt(types.Note{UserID: alice.ID, Content: "the day before"}, at(2025, time.June, 14, 23, 59)))
+		thisWeek := createNote(t, b, writtenAt(types.Note{UserID: alice.ID, Content: "this week #rain", Mood: 3, Tags: []types.Tag{{Name: "rain"}}}, at(2026, time.June, 12, 8, 0)))
+		today := createNote(t, b, writtenAt(types.Note{UserID: alice.ID, Content: "today", Mood: 5}, at(2026, time.June, 15, 8, 0)))
+		shared := createNote(t, b, writtenAt(types.Note{UserID: bob.ID, Content: "from bob #sun", Circles: []types.Circle{friends}, Tags: []types.Tag{{Name: "sun"}}}, at(2026, time.June, 13, 8, 0)))
+		deleted := createNote(t, b, writtenAt(types.Note{UserID: alice.ID, Content: "gone #sun", Mood: 1, Tags: []types.Tag{{Name: "sun"}}}, at(2025, time.June, 15, 7, 0)))
+		if err := b.Notes.Delete(t.Context(), deleted); err != nil {
+			t.Fatal(err)
+		}
+
+		onThisDay, err := b.Notes.OnThisDay(t.Context(), alice.ID, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := []uint{}; len(onThisDay) != 2 || onThisDay[0].ID != lastYear.ID || onThisDay[1].ID != twoYears.ID {
+			for _, n := range onThisDay {
+				got = append(got, n.ID)
+			}
+			t.Errorf("on this day got %v, want [%d %d]", got, lastYear.ID, twoYears.ID)
+		}
+
+		random, err := b.Notes.RandomBefore(t.Context(), alice.ID, at(2024, time.June, 16, 0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if random == nil || random.ID != twoYears.ID {
+			t.Errorf("the only note before the 16th of June 2024 is %d, got %+v", twoYears.ID, random)
+		}
+		if random, err := b.Notes.RandomBefore(t.Context(), alice.ID, at(2020, time.January, 1, 0, 0)); err != nil || random != nil {
+			t.Errorf("random note from before the first note got %+v, %v", random, err)
+		}
+
+		moods, err := b.Notes.Moods(t.Context(), alice.ID, day.AddDate(0, 0, -7))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(moods) != 2 || moods[0].Mood != 3 || moods[1].Mood != 5 {
+			t.Errorf("moods of the last week are %+v, want 3 then 5", moods)
+		}
+
+		times, err := b.Notes.CreationTimes(t.Context(), alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(times) != 5 {
+			t.Errorf("got %d creation times, want 5", len(times))
+		}
+
+		week := NoteFilter{From: at(2026, time.June, 8, 8, 0), Until: at(2026, time.June, 15, 8, 0)}
+		tests := []struct {
+			name   string
+			filter NoteFilter
+			want   []uint
+		}{
+			{"period", week, noteIDs([]types.Note{thisWeek, shared})},
+			{"own notes in the period", NoteFilter{From: week.From, Until: week.Until, AuthorID: alice.ID}, []uint{thisWeek.ID}},
+			{"others' notes in the period", NoteFilter{From: week.From, Until: week.Until, NotAuthorID: alice.ID}, []uint{shared.ID}},
+			{"until is exclusive", NoteFilter{From: today.CreatedAt, AuthorID: alice.ID}, []uint{today.ID}},
+		}
+		for _, tt := range tests {
+			notes, err := b.Notes.ListForUser(t.Context(), alice.ID, tt.filter, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := noteIDs(notes); !slices.Equal(got, tt.want) {
+				t.Errorf("%s: got notes %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		counts, err := b.Notes.TagCounts(t.Context(), alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []types.TagCount{{Name: "sun", Count: 3}, {Name: "rain", Count: 1}}
+		if !slices.Equal(counts, want) {
+			t.Errorf("tag counts are %v, want %v", counts, want)
+		}
+	})
+}
+
+func TestCircles(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Stores) {
+		alice := createUser(t, b, "alice@example.com")
+		bob := createUser(t, b, "bob@example.com")
+		carol := createUser(t, b, "carol@example.com")
+		circle := createCircle(t, b, "Family", alice.ID, bob.ID)
+		note := createNote(t, b, types.Note{UserID: alice.ID, Content: "shared", Circles: []types.Circle{circle}})
+
+		got, member, err := b.Circles.GetForUser(t.Context(), circle.ID, bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if member.Role != types.CircleRoleMember || len(got.Members) != 2 || got.Members[0].User.Email != alice.Email {
+			t.Errorf("loaded circle %+v with membership %+v", got, member)
+		}
+		if _, _, err := b.Circles.GetForUser(t.Context(), circle.ID, carol.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("outsider getting the circle got %v, want ErrNotFound", err)
+		}
+
+		invite := types.CircleInvite{CircleID: circle.ID, Token: "token", InvitedByID: alice.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := b.Circles.CreateInvite(t.Context(), &invite); err != nil {
+			t.Fatal(err)
+		}
+		expired := types.CircleInvite{CircleID: circle.ID, Token: "old", InvitedByID: alice.ID, ExpiresAt: time.Now().Add(-time.Hour)}
+		if err := b.Circles.CreateInvite(t.Context(), &expired); err != nil {
+			t.Fatal(err)
+		}
+		found, err := b.Circles.GetInvite(t.Context(), "token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Circle.Name != "Family" || found.InvitedBy.Email != alice.Email {
+			t.Errorf("invite was loaded with circle %q from %q", found.Circle.Name, found.InvitedBy.Email)
+		}
+		if _, err := b.Circles.GetInvite(t.Context(), "old"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("getting an expired invite got %v, want ErrNotFound", err)
+		}
+		got, _, err = b.Circles.GetForUser(t.Context(), circle.ID, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Invites) != 1 || got.Invites[0].ID != invite.ID {
+			t.Errorf("circle lists invites %+v, want only the open one", got.Invites)
+		}
+
+		// Joining twice is fine
+		for range 2 {
+			if err := b.Circles.AddMember(t.Context(), types.CircleMember{CircleID: circle.ID, UserID: carol.ID, Role: types.CircleRoleMember}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Circles.SetRole(t.Context(), circle.ID, carol.ID, types.CircleRoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		if _, member, err := b.Circles.GetForUser(t.Context(), circle.ID, carol.ID); err != nil || member.Role != types.CircleRoleAdmin {
+			t.Errorf("carol is %+v after being made an admin: %v", member, err)
+		}
+		if err := b.Circles.RemoveMember(t.Context(), circle.ID, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Notes.GetForUser(t.Context(), note.ID, bob.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("a removed member can still see the circle's notes: %v", err)
+		}
+
+		if err := b.Circles.Rename(t.Context(), circle, "Kin"); err != nil {
+			t.Fatal(err)
+		}
+		circles, err := b.Users.Circles(t.Context(), carol.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(circles) != 1 || circles[0].Name != "Kin" || len(circles[0].Members) != 2 {
+			t.Errorf("carol's circles are %+v", circles)
+		}
+
+		if err := b.Circles.DeleteInvite(t.Context(), circle.ID, invite.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Circles.GetInvite(t.Context(), "token"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("getting a revoked invite got %v, want ErrNotFound", err)
+		}
+
+		if err := b.Circles.Delete(t.Context(), circle); err != nil {
+			t.Fatal(err)
+		}
+		if circles, err := b.Users.Circles(t.Context(), alice.ID); err != nil || len(circles) != 0 {
+			t.Errorf("alice is still in %+v after deleting the circle: %v", circles, err)
+		}
+		kept, err := b.Notes.GetForUser(t.Context(), note.ID, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kept.Circles) != 0 {
+			t.Errorf("the note is still shared with %+v", kept.Circles)
+		}
+	})
+}
@@ -0,0 +1,13 @@
+package types
+
+import "github.com/oliverisaac/fanks/backup"
+
+// AdminPageData is what the admin page shows about the state of the server
+type AdminPageData struct {
+	Backups       []backup.File
+	PushJobs      []PushJob
+	PushJobCounts map[string]int64
+	// DevicesOnPreviousKey counts the devices still subscribed with the
+	// previous VAPID key while keys are rotated
+	DevicesOnPreviousKey int64
+}
@@ -0,0 +1,27 @@
+package types
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Attachment is a photo attached to a note. The image itself lives in the blob store.
+type Attachment struct {
+	gorm.Model
+	NoteID      uint `gorm:"index"`
+	UserID      uint
+	ContentType string
+	Key         string
+	ThumbKey    string
+	Width       int
+	Height      int
+}
+
+func (a Attachment) URL() string {
+	return fmt.Sprintf("/attachments/%d", a.ID)
+}
+
+func (a Attachment) ThumbURL() string {
+	return fmt.Sprintf("/attachments/%d/thumb", a.ID)
+}
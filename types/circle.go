@@ -0,0 +1,60 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	CircleRoleOwner  = "owner"
+	CircleRoleAdmin  = "admin"
+	CircleRoleMember = "member"
+)
+
+type Circle struct {
+	gorm.Model
+	Name    string
+	Members []CircleMember
+	Invites []CircleInvite
+}
+
+// Member returns the membership of the given user, if any
+func (c Circle) Member(userID uint) (CircleMember, bool) {
+	for _, m := range c.Members {
+		if m.UserID == userID {
+			return m, true
+		}
+	}
+	return CircleMember{}, false
+}
+
+type CircleMember struct {
+	CircleID  uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"primaryKey;index"`
+	User      User
+	Role      string
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (m CircleMember) CanManage() bool {
+	return m.Role == CircleRoleOwner || m.Role == CircleRoleAdmin
+}
+
+func (m CircleMember) IsOwner() bool {
+	return m.Role == CircleRoleOwner
+}
+
+type CircleInvite struct {
+	gorm.Model
+	CircleID    uint
+	Circle      Circle
+	Token       string `gorm:"uniqueIndex"`
+	InvitedByID uint
+	InvitedBy   User
+	ExpiresAt   time.Time
+}
+
+func (i CircleInvite) Expired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
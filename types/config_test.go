@@ -0,0 +1,60 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oliverisaac/fanks/vapid"
+)
+
+func TestConfigFromEnvVapidKeys(t *testing.T) {
+	public, private, err := vapid.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "valid keys", env: map[string]string{"VAPID_PUBLIC_KEY": public, "VAPID_PRIVATE_KEY": private}},
+		{name: "missing keys", env: map[string]string{}, wantErr: "You must define env VAPID_PRIVATE_KEY"},
+		{name: "empty keys", env: map[string]string{"VAPID_PUBLIC_KEY": "", "VAPID_PRIVATE_KEY": ""}, wantErr: "checking VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY"},
+		{name: "empty public key", env: map[string]string{"VAPID_PUBLIC_KEY": "", "VAPID_PRIVATE_KEY": private}, wantErr: "checking VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY"},
+		{name: "previous key without its pair", env: map[string]string{"VAPID_PUBLIC_KEY": public, "VAPID_PRIVATE_KEY": private, "VAPID_PREVIOUS_PUBLIC_KEY": public}, wantErr: "checking VAPID_PREVIOUS_PUBLIC_KEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FANKS_COOKIE_STORE_SECRET", "secret")
+			t.Setenv("FANKS_DB_PATH", filepath.Join(t.TempDir(), "fanks.db"))
+			for _, k := range []string{"VAPID_PUBLIC_KEY", "VAPID_PRIVATE_KEY", "VAPID_PREVIOUS_PUBLIC_KEY", "VAPID_PREVIOUS_PRIVATE_KEY"} {
+				if v, ok := tt.env[k]; ok {
+					t.Setenv(k, v)
+				} else {
+					unsetenv(t, k)
+				}
+			}
+
+			cfg, err := ConfigFromEnv()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("ConfigFromEnv returned %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("ConfigFromEnv returned %v, want an error containing %q", err, tt.wantErr)
+			case tt.wantErr == "" && cfg.VapidPublicKey != public:
+				t.Errorf("VapidPublicKey is %q", cfg.VapidPublicKey)
+			}
+		})
+	}
+}
+
+// unsetenv removes the variable for the rest of the test
+func unsetenv(t *testing.T, key string) {
+	t.Setenv(key, "")
+	if err := os.Unsetenv(key); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,40 @@
+package types
+
+import "time"
+
+// Digest is the summary emailed to a user for a period of time
+type Digest struct {
+	User       User
+	Hostname   string
+	Frequency  string
+	Start      time.Time
+	End        time.Time
+	Notes      []Note
+	Highlights []Note
+	Stats      StreakStats
+}
+
+type StreakStats struct {
+	CurrentStreak int
+	LongestStreak int
+	DaysWritten   int
+	TotalNotes    int
+}
+
+// ReminderEmail is the daily reminder for users who get it by email
+type ReminderEmail struct {
+	User   User
+	Prompt string
+	// ComposeURL opens the composer with the prompt, once the user it was
+	// sent to is signed in
+	ComposeURL string
+	// UnsubscribeURL turns email reminders off without signing in
+	UnsubscribeURL string
+}
+
+// ComposeLink is the prompt of a reminder email and the token it was signed
+// for its recipient with, carried through signing in
+type ComposeLink struct {
+	Prompt string
+	Token  string
+}
@@ -5,11 +5,15 @@ import (
 )
 
 type HomePageData struct {
-	User   *User
-	Config Config
-	Notes  []Note
-	Err    error
-	Prompt string
+	User     *User
+	Config   Config
+	Notes    []Note
+	Circles  []Circle
+	Circle   *Circle
+	Tag      string
+	Memories Memories
+	Err      error
+	Prompt   string
 }
 
 func (d HomePageData) WithPrompt(s string) HomePageData {
@@ -31,3 +35,25 @@ func (d HomePageData) WithNotes(notes []Note) HomePageData {
 	d.Notes = append(d.Notes, notes...)
 	return d
 }
+
+func (d HomePageData) WithCircles(circles []Circle) HomePageData {
+	d.Circles = append(d.Circles, circles...)
+	return d
+}
+
+func (d HomePageData) WithMemories(m Memories) HomePageData {
+	d.Memories = m
+	return d
+}
+
+// WithTag sets the tag the feed is filtered by
+func (d HomePageData) WithTag(tag string) HomePageData {
+	d.Tag = tag
+	return d
+}
+
+// WithCircle sets the circle the feed is filtered by
+func (d HomePageData) WithCircle(c Circle) HomePageData {
+	d.Circle = &c
+	return d
+}
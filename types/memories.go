@@ -0,0 +1,11 @@
+package types
+
+// Memories are past notes resurfaced for the user to reread
+type Memories struct {
+	OnThisDay []Note
+	Random    *Note
+}
+
+func (m Memories) IsEmpty() bool {
+	return len(m.OnThisDay) == 0 && m.Random == nil
+}
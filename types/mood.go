@@ -0,0 +1,25 @@
+package types
+
+import "time"
+
+const (
+	MoodNone = 0
+	MoodMin  = 1
+	MoodMax  = 5
+)
+
+// MoodDay is the average mood of the notes a user rated on one day
+type MoodDay struct {
+	Day     time.Time
+	Average float64
+	Count   int
+}
+
+// MoodChart is a user's mood over the Days days between Start and End
+type MoodChart struct {
+	Days   int
+	Start  time.Time
+	End    time.Time
+	Ranges []int
+	Moods  []MoodDay
+}
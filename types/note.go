@@ -6,14 +6,26 @@ import (
 	"gorm.io/gorm"
 )
 
+// Note is soft deleted through gorm.Model, deleted notes stay in the trash
+// until they are purged
 type Note struct {
 	gorm.Model
-	UserID     uint
-	IsUserNote bool `gorm:-`
-	User       User
-	Content    string
-	Prompt     string     `gorm:"default:'Today I am grateful for...'"`
-	CreatedAt  time.Time  `gorm:"autoCreateTime"`
-	UpdatedAt  *time.Time `gorm:"autoUpdateTime"`
-	DeletedAt  *time.Time
+	UserID      uint `gorm:"uniqueIndex:idx_notes_user_idempotency_key"`
+	IsUserNote  bool `gorm:"-"`
+	User        User
+	Content     string
+	Prompt      string   `gorm:"default:'Today I am grateful for...'"`
+	Mood        int      // MoodMin to MoodMax, or MoodNone if the day was not rated
+	Things      []string `gorm:"serializer:json"`
+	Circles     []Circle `gorm:"many2many:note_circles;"`
+	Tags        []Tag    `gorm:"many2many:note_tags;"`
+	Attachments []Attachment
+	// IdempotencyKey is sent by clients which may submit the same note twice,
+	// such as a double tapped submit button or the offline draft queue
+	IdempotencyKey *string `gorm:"uniqueIndex:idx_notes_user_idempotency_key"`
+}
+
+// PurgesAt returns when a deleted note will be removed for good
+func (n Note) PurgesAt(retention time.Duration) time.Time {
+	return n.DeletedAt.Time.Add(retention)
 }
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ChannelNtfy    = "ntfy"
+	ChannelGotify  = "gotify"
+	ChannelMatrix  = "matrix"
+	ChannelWebhook = "webhook"
+)
+
+// ChannelKinds are the kinds of channel a user can add, in the order they are offered
+var ChannelKinds = []string{ChannelNtfy, ChannelGotify, ChannelMatrix, ChannelWebhook}
+
+// NotificationChannel gets the user's reminders through a service other than
+// web push
+type NotificationChannel struct {
+	gorm.Model
+	UserID uint `gorm:"index"`
+	Kind   string
+	Label  string
+	// URL is the ntfy topic, the Gotify server, the Matrix homeserver or the
+	// webhook the messages are sent to
+	URL string
+	// Token is the ntfy or Gotify token, the Matrix access token or the secret
+	// webhooks are signed with
+	Token string
+	// RoomID is the Matrix room messages are sent to
+	RoomID        string
+	LastSuccessAt *time.Time
+}
@@ -0,0 +1,61 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PushJobPending = "pending"
+	PushJobSent    = "sent"
+	// PushJobGone means the push service no longer knows the subscription, so it was removed
+	PushJobGone = "gone"
+	// PushJobDead means the job failed for good and will not be retried
+	PushJobDead = "dead"
+	// PushJobCancelled means the user snoozed or skipped the reminder before it was sent
+	PushJobCancelled = "cancelled"
+)
+
+// PushMessage is the notification shown on the user's device
+type PushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	Topic string `json:"topic"`
+	// Prompt is set on reminders, which can be answered, snoozed or skipped
+	// from the notification
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// PushJob delivers one message to one subscription, retrying until the push
+// service accepts it or the job is given up on
+type PushJob struct {
+	gorm.Model
+	UserID         uint
+	User           User
+	BatchID        *uint `gorm:"index"`
+	SubscriptionID uint
+	// ChannelID is set instead of SubscriptionID for jobs sent to a
+	// notification channel
+	ChannelID      *uint
+	Kind           string
+	Message        PushMessage `gorm:"serializer:json"`
+	Status         string      `gorm:"index:idx_push_jobs_status_next_attempt_at"`
+	Attempts       int
+	NextAttemptAt  time.Time `gorm:"index:idx_push_jobs_status_next_attempt_at"`
+	LastStatusCode int
+	LastError      string
+	FinishedAt     *time.Time
+}
+
+// PushBatch is one trigger of a kind of push. It is fanned out into a job for
+// each device, its progress is the progress of those jobs.
+type PushBatch struct {
+	gorm.Model
+	Kind string
+	// UserID limits the batch to one user, 0 sends to every user
+	UserID uint
+	// QueuedAt is set once a job has been queued for every device
+	QueuedAt *time.Time
+}
@@ -1,14 +1,23 @@
 package types
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type PushSubscription struct {
 	gorm.Model
 	UserID       uint
-	Endpoint     string
+	Endpoint     string `gorm:"uniqueIndex"`
 	P256DH       string
 	Auth         string
 	Keys         string
+	// Label names the device for the user, it defaults to the browser and OS
+	Label         string
+	UserAgent     string
+	LastSuccessAt *time.Time
+	// VapidPublicKey is the key the device subscribed with, pushes to it
+	// must be signed with the matching private key
+	VapidPublicKey string
 }
@@ -0,0 +1,15 @@
+package types
+
+import "time"
+
+type Tag struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"uniqueIndex"`
+	CreatedAt time.Time
+}
+
+// TagCount is the number of notes visible to a user which have a tag
+type TagCount struct {
+	Name  string
+	Count int64
+}
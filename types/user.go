@@ -14,11 +14,29 @@ type User struct {
 	Role              string
 	Notes             []Note
 	PushSubscriptions []PushSubscription
+	WeeklyMemories    bool
+	DigestFrequency   string
+	DigestLastSentAt  *time.Time
 	CreatedAt         time.Time  `gorm:"autoCreateTime"`
 	UpdatedAt         *time.Time `gorm:"autoUpdateTime"`
 	DeletedAt         *time.Time
+
+	// NotificationChannels get reminders alongside the push subscriptions
+	NotificationChannels []NotificationChannel
+	// EmailReminders sends the daily reminder by email as well
+	EmailReminders          bool
+	EmailReminderLastSentAt *time.Time
+	// RemindersSkippedUntil is the end of the day the user skipped the
+	// reminder on, no reminder is pushed or emailed before then
+	RemindersSkippedUntil *time.Time
 }
 
 func (u User) IsSet() bool {
 	return u.Email != ""
 }
+
+const (
+	DigestOff     = ""
+	DigestWeekly  = "weekly"
+	DigestMonthly = "monthly"
+)
@@ -0,0 +1,53 @@
+// Package vapid creates and checks the key pairs fanks signs push messages
+// with. Keys are base64url encoded the way browsers and webpush-go expect
+// them: the private key is the 32 byte P-256 scalar and the public key is the
+// 65 byte uncompressed point.
+package vapid
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"strings"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/pkg/errors"
+)
+
+// Generate returns a new key pair
+func Generate() (publicKey string, privateKey string, err error) {
+	privateKey, publicKey, err = webpush.GenerateVAPIDKeys()
+	return publicKey, privateKey, errors.Wrap(err, "generating VAPID keys")
+}
+
+// Validate checks that the keys are a P-256 key pair which belong together
+func Validate(publicKey string, privateKey string) error {
+	if publicKey == "" || privateKey == "" {
+		return errors.New("both keys must be set")
+	}
+	priv, err := decode(privateKey)
+	if err != nil {
+		return errors.Wrap(err, "decoding private key")
+	}
+	key, err := ecdh.P256().NewPrivateKey(priv)
+	if err != nil {
+		return errors.Wrap(err, "private key is not a P-256 key")
+	}
+
+	pub, err := decode(publicKey)
+	if err != nil {
+		return errors.Wrap(err, "decoding public key")
+	}
+	if _, err := ecdh.P256().NewPublicKey(pub); err != nil {
+		return errors.Wrap(err, "public key is not a P-256 key")
+	}
+	if !bytes.Equal(key.PublicKey().Bytes(), pub) {
+		return errors.New("public key does not belong to the private key")
+	}
+	return nil
+}
+
+// decode accepts base64url with or without padding
+func decode(key string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+}
@@ -0,0 +1,39 @@
+package vapid
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	public, private, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		public  string
+		private string
+		valid   bool
+	}{
+		{name: "generated pair", public: public, private: private, valid: true},
+		{name: "padded keys", public: public + "=", private: private + "=", valid: true},
+		{name: "both empty", public: "", private: ""},
+		{name: "empty public key", public: "", private: private},
+		{name: "empty private key", public: public, private: ""},
+		{name: "keys of different pairs", public: otherPublic, private: private},
+		{name: "swapped keys", public: private, private: public},
+		{name: "not base64", public: strings.Repeat("!", 87), private: private},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.public, tt.private)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: Validate returned %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+func digestPeriodName(d types.Digest) string {
+	if d.Frequency == types.DigestMonthly {
+		return "month"
+	}
+	return "week"
+}
+
+func days(n int) string {
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
+
+func DigestSubject(d types.Digest) string {
+	if d.Frequency == types.DigestMonthly {
+		return fmt.Sprintf("Your Fanks for %s", d.Start.Local().Format("January 2006"))
+	}
+	return fmt.Sprintf("Your Fanks for the week of %s", d.Start.Local().Format("Jan 2"))
+}
+
+func DigestIntro(d types.Digest) string {
+	if len(d.Notes) == 0 {
+		return fmt.Sprintf("You didn't write any notes this %s. There is always something to be grateful for!", digestPeriodName(d))
+	}
+	return fmt.Sprintf("Here is a look back at your %s.", digestPeriodName(d))
+}
+
+// DigestText renders the plaintext alternative of DigestEmail
+func DigestText(d types.Digest) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Hi %s,\n\n%s\n\n", d.User.Name, DigestIntro(d))
+	fmt.Fprintf(&b, "Notes this %s: %d\n", digestPeriodName(d), len(d.Notes))
+	fmt.Fprintf(&b, "Current streak: %s\n", days(d.Stats.CurrentStreak))
+	fmt.Fprintf(&b, "Longest streak: %s\n", days(d.Stats.LongestStreak))
+
+	section := func(title string, notes []types.Note, showAuthor bool) {
+		if len(notes) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s\n%s\n", title, strings.Repeat("=", len(title)))
+		for _, note := range notes {
+			fmt.Fprintf(&b, "\n%s\n%s\n", note.Prompt, note.Content)
+			for i, thing := range note.Things {
+				fmt.Fprintf(&b, "%d. %s\n", i+1, thing)
+			}
+			if note.Mood != types.MoodNone {
+				fmt.Fprintf(&b, "Mood: %s\n", moodLabel(note.Mood))
+			}
+			if showAuthor {
+				fmt.Fprintf(&b, "-- %s, %s\n", note.User.Name, note.CreatedAt.Local().Format("Mon Jan 2"))
+			} else {
+				fmt.Fprintf(&b, "-- %s\n", note.CreatedAt.Local().Format("Mon Jan 2"))
+			}
+		}
+	}
+	section("What you were grateful for", d.Notes, false)
+	section("From your circles", d.Highlights, true)
+
+	fmt.Fprintf(&b, "\nWrite today's note: https://%s/\n", d.Hostname)
+	fmt.Fprintf(&b, "\nYou are receiving this because you turned on the %s digest in your Fanks settings.\n", d.Frequency)
+	return b.String()
+}
@@ -65,35 +65,48 @@ func Layout(cfg types.Config, user *types.User, title string) templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "\" rel=\"stylesheet\"><link rel=\"icon\" href=\"/static/icon-128.png\" type=\"image/png\"><script src=\"/static/htmx-2.0.6.min.js\"></script><link rel=\"manifest\" href=\"")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "\" rel=\"stylesheet\"><link rel=\"icon\" href=\"/static/icon-128.png\" type=\"image/png\"><script src=\"/static/htmx-2.0.6.min.js\"></script><script src=\"/static/htmx-ext-sse-2.2.3.js\"></script><script src=\"")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		var templ_7745c5c3_Var4 templ.SafeURL
-		templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinURLErrs(versionedPath("/static/manifest.json"))
+		var templ_7745c5c3_Var4 string
+		templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(versionedPath("/static/drafts.js"))
 		if templ_7745c5c3_Err != nil {
-			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 27, Col: 67}
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 27, Col: 49}
 		}
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "\"><meta name=\"mobile-web-app-capable\" content=\"yes\"><meta name=\"apple-mobile-web-app-capable\" content=\"yes\"><meta name=\"application-name\" content=\"Fanks\"><meta name=\"apple-mobile-web-app-title\" content=\"Fanks\"><!--\n    <meta name=\"theme-color\" content=\"#2c3e50\"/> \n    <meta name=\"msapplication-navbutton-color\" content=\"#2c3e50\"/>\n    --><meta name=\"apple-mobile-web-app-status-bar-style\" content=\"black-translucent\"><link rel=\"icon\" type=\"image/png\" href=\"/static/icon-512.png\"><link rel=\"apple-touch-icon\" href=\"/static/icon-512.png\"></head><body id=\"body\" class=\"bg-neutral-900 text-neutral-100 flex flex-col min-h-screen\"><header class=\"bg-neutral-800\"><nav class=\"container flex items-center justify-between p-4 mx-auto\"><a href=\"/\" title=\"Napp Home\" class=\"flex items-center space-x-2\"><img src=\"/static/icon-512.png\" class=\"h-10 w-10\" alt=\"Icon\"> <span class=\"text-4xl font-bold\">Fanks</span></a><ul class=\"flex items-center space-x-4\">")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "\"></script><link rel=\"manifest\" href=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var5 templ.SafeURL
+		templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinURLErrs(versionedPath("/static/manifest.json"))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 29, Col: 67}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "\"><meta name=\"mobile-web-app-capable\" content=\"yes\"><meta name=\"apple-mobile-web-app-capable\" content=\"yes\"><meta name=\"application-name\" content=\"Fanks\"><meta name=\"apple-mobile-web-app-title\" content=\"Fanks\"><!--\n    <meta name=\"theme-color\" content=\"#2c3e50\"/> \n    <meta name=\"msapplication-navbutton-color\" content=\"#2c3e50\"/>\n    --><meta name=\"apple-mobile-web-app-status-bar-style\" content=\"black-translucent\"><link rel=\"icon\" type=\"image/png\" href=\"/static/icon-512.png\"><link rel=\"apple-touch-icon\" href=\"/static/icon-512.png\"></head><body id=\"body\" class=\"bg-neutral-900 text-neutral-100 flex flex-col min-h-screen\"><header class=\"bg-neutral-800\"><nav class=\"container flex items-center justify-between p-4 mx-auto\"><a href=\"/\" title=\"Napp Home\" class=\"flex items-center space-x-2\"><img src=\"/static/icon-512.png\" class=\"h-10 w-10\" alt=\"Icon\"> <span class=\"text-4xl font-bold\">Fanks</span></a><ul class=\"flex items-center space-x-4\">")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
 		if user != nil {
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "<li><button hx-post=\"/auth/sign-out\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-gray-600 hover:bg-gray-700\">Sign Out</button></li>")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "<li><a href=\"/memories\" class=\"px-4 py-2 text-white rounded-md hover:bg-neutral-700\">Memories</a></li><li><a href=\"/mood\" class=\"px-4 py-2 text-white rounded-md hover:bg-neutral-700\">Mood</a></li><li><a href=\"/tags\" class=\"px-4 py-2 text-white rounded-md hover:bg-neutral-700\">Tags</a></li><li><a href=\"/circles\" class=\"px-4 py-2 text-white rounded-md hover:bg-neutral-700\">Circles</a></li><li><a href=\"/settings\" class=\"px-4 py-2 text-white rounded-md hover:bg-neutral-700\">Settings</a></li><li><button hx-post=\"/auth/sign-out\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-gray-600 hover:bg-gray-700\">Sign Out</button></li>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 		} else {
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "<li><button hx-get=\"/auth/sign-in\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-primary-600 hover:bg-primary-700\">Sign In</button></li>")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "<li><button hx-get=\"/auth/sign-in\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-primary-600 hover:bg-primary-700\">Sign In</button></li>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "</ul></nav></header><main class=\"container p-4 mx-auto flex-grow\">")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 8, "</ul></nav></header><main class=\"container p-4 mx-auto flex-grow\"><p id=\"drafts-status\" class=\"hidden p-2 mb-4 text-sm rounded-md text-yellow-200 bg-yellow-900/50\"></p>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -101,12 +114,12 @@ func Layout(cfg types.Config, user *types.User, title string) templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 8, "</main><footer class=\"p-4\"><div class=\"flex justify-center items-center space-x-2\">")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 9, "</main><div id=\"toast\"></div><footer class=\"p-4\"><div class=\"flex justify-center items-center space-x-2\">")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
 		if user != nil {
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 9, "<button id=\"push-subscribe-button\" class=\"flex items-center px-4 py-2 text-white rounded-md bg-blue-600 hover:bg-blue-700\">")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 10, "<button id=\"push-subscribe-button\" class=\"flex items-center px-4 py-2 text-white rounded-md bg-blue-600 hover:bg-blue-700\">")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
@@ -114,41 +127,54 @@ func Layout(cfg types.Config, user *types.User, title string) templ.Component {
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 10, "<span class=\"ml-2\">Notify Me</span></button> ")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 11, "<span class=\"ml-2\">Notify Me</span></button> ")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 			if len(user.PushSubscriptions) > 0 {
-				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 11, "<button hx-confirm=\"Unsubscribe all devices from push notifications?\" hx-post=\"/push/unsubscribe\" hx-swap=\"delete\" id=\"push-unsubscribe-button\" class=\"px-4 py-2 text-white rounded-md bg-red-800 hover:bg-red-800\">Do Not Notify Me</button>")
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 12, "<button id=\"push-unsubscribe-button\" class=\"px-4 py-2 text-white rounded-md bg-red-800 hover:bg-red-800\">Do Not Notify Me</button>")
 				if templ_7745c5c3_Err != nil {
 					return templ_7745c5c3_Err
 				}
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 12, " ")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 13, " ")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 			if user.Role == "admin" {
-				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 13, "<button hx-post=\"/push/trigger\" id=\"push-trigger-button\" class=\"px-4 py-2 text-white rounded-md bg-yellow-600 hover:bg-yellow-800\">Trigger Notification</button>")
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 14, "<a href=\"/admin\" class=\"px-4 py-2 text-white rounded-md bg-yellow-600 hover:bg-yellow-800\">Admin</a> <button hx-post=\"/push/trigger\" id=\"push-trigger-button\" class=\"px-4 py-2 text-white rounded-md bg-yellow-600 hover:bg-yellow-800\">Trigger Notification</button> <button hx-post=\"/push/trigger\" hx-vals=\"")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var6 string
+				templ_7745c5c3_Var6, templ_7745c5c3_Err = templ.JoinStringErrs(`{"kind": "memories"}`)
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 104, Col: 67}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var6))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 15, "\" id=\"push-trigger-memories-button\" class=\"px-4 py-2 text-white rounded-md bg-yellow-600 hover:bg-yellow-800\">Trigger Memories</button>")
 				if templ_7745c5c3_Err != nil {
 					return templ_7745c5c3_Err
 				}
 			}
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 14, "</div><p class=\"text-center text-neutral-500 text-xs mt-2\">Version: ")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 16, "</div><p class=\"text-center text-neutral-500 text-xs mt-2\">Version: ")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		var templ_7745c5c3_Var5 string
-		templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinStringErrs(version.Tag)
+		var templ_7745c5c3_Var7 string
+		templ_7745c5c3_Var7, templ_7745c5c3_Err = templ.JoinStringErrs(version.Tag)
 		if templ_7745c5c3_Err != nil {
-			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 88, Col: 76}
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 111, Col: 76}
 		}
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var7))
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 15, "</p></footer><script type=\"text/javascript\">\n\t\tdocument.addEventListener(\"DOMContentLoaded\", (event) => {\n\t\t\tdocument.body.addEventListener('htmx:beforeSwap', function (evt) {\n\t\t\t\tif (evt.detail.xhr.status === 422 || evt.detail.xhr.status === 500) {\n\t\t\t\t\tconsole.log(\"setting status to paint\");\n\t\t\t\t\t// allow 422 responses to swap as we are using this as a signal that\n\t\t\t\t\t// a form was submitted with bad data and want to rerender with the\n\t\t\t\t\t// errors\n\t\t\t\t\t//\n\t\t\t\t\t// set isError to false to avoid error logging in console\n\t\t\t\t\tevt.detail.shouldSwap = true;\n\t\t\t\t\tevt.detail.isError = false;\n\t\t\t\t}\n\t\t\t});\n\t\t});\n\t</script><script>\n\t\tfunction setupNotifications(vapidPublicKey, serviceworkerPath) {\n\t\t\tlet wakeLock = null;\n\n\t\t\t// Register Service Worker\n\t\t\tif ('serviceWorker' in navigator) {\n\t\t\t\tnavigator.serviceWorker.register(serviceworkerPath, { scope: '/' })\n\t\t\t\t\t.then(function (reg) {\n\t\t\t\t\t\tconsole.log('Service Worker registered successfully.');\n\t\t\t\t\t\tif (document.getElementById('push-subscribe-button')) {\n\t\t\t\t\t\t\tdocument.getElementById('push-subscribe-button').addEventListener('click', function () {\n\t\t\t\t\t\t\t\tconsole.log(\"subscribe button pusshed\")\n\t\t\t\t\t\t\t\tif ('serviceWorker' in navigator && 'PushManager' in window) {\n\t\t\t\t\t\t\t\t\tNotification.requestPermission().then(function (permission) {\n\t\t\t\t\t\t\t\t\t\tif (permission === 'granted') {\n\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"going to subscribe\")\n\t\t\t\t\t\t\t\t\t\t\treg.pushManager.subscribe({\n\t\t\t\t\t\t\t\t\t\t\t\tuserVisibleOnly: true,\n\t\t\t\t\t\t\t\t\t\t\t\tapplicationServerKey: urlBase64ToUint8Array(vapidPublicKey)\n\t\t\t\t\t\t\t\t\t\t\t}).then(function (subscription) {\n\t\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"Posting to /push/subscribe\")\n\t\t\t\t\t\t\t\t\t\t\t\tfetch('/push/subscribe', {\n\t\t\t\t\t\t\t\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\t\t\t\t\t\t\t\theaders: {\n\t\t\t\t\t\t\t\t\t\t\t\t\t\t'Content-Type': 'application/json'\n\t\t\t\t\t\t\t\t\t\t\t\t\t},\n\t\t\t\t\t\t\t\t\t\t\t\t\tbody: JSON.stringify(subscription)\n\t\t\t\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t\t\t\t}).then(function (resp) {\n\t\t\t\t\t\t\t\t\t\t\t\talert(\"Subscribed!\")\n\t\t\t\t\t\t\t\t\t\t\t\tdocument.getElementById('push-subscribe-button').remove()\n\t\t\t\t\t\t\t\t\t\t\t}).catch(function (err) {\n\t\t\t\t\t\t\t\t\t\t\t\tconsole.error('Failed to subscribe to push notifications:', err);\n\t\t\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t\t\t} else {\n\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"Permission not granted for notifications\");\n\t\t\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t} else {\n\t\t\t\t\t\t\t\t\tconsole.log(\"Missing deps\")\n\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t});\n\t\t\t\t\t\t}\n\t\t\t\t\t})\n\t\t\t\t\t.catch(err => console.error('Service Worker registration failed:', err));\n\t\t\t}\n\t\t}\n\n\t\tfunction urlBase64ToUint8Array(base64String) {\n\t\t\tconst padding = '='.repeat((4 - base64String.length % 4) % 4);\n\t\t\tconst base64 = (base64String + padding)\n\t\t\t\t.replace(/\\-/g, '+')\n\t\t\t\t.replace(/_/g, '/');\n\n\t\t\tconst rawData = window.atob(base64);\n\t\t\tconst outputArray = new Uint8Array(rawData.length);\n\n\t\t\tfor (let i = 0; i < rawData.length; ++i) {\n\t\t\t\toutputArray[i] = rawData.charCodeAt(i);\n\t\t\t}\n\t\t\treturn outputArray;\n\t\t}\n\t</script>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 17, "</p></footer><script type=\"text/javascript\">\n\t\tdocument.addEventListener(\"DOMContentLoaded\", (event) => {\n\t\t\tdocument.body.addEventListener('htmx:beforeSwap', function (evt) {\n\t\t\t\tif (evt.detail.xhr.status === 422 || evt.detail.xhr.status === 500) {\n\t\t\t\t\tconsole.log(\"setting status to paint\");\n\t\t\t\t\t// allow 422 responses to swap as we are using this as a signal that\n\t\t\t\t\t// a form was submitted with bad data and want to rerender with the\n\t\t\t\t\t// errors\n\t\t\t\t\t//\n\t\t\t\t\t// set isError to false to avoid error logging in console\n\t\t\t\t\tevt.detail.shouldSwap = true;\n\t\t\t\t\tevt.detail.isError = false;\n\t\t\t\t}\n\t\t\t});\n\n\t\t\tFanksDrafts.setupPage();\n\n\t\t\t// Toasts close on their own after a while\n\t\t\tlet toastTimer = null;\n\t\t\tdocument.body.addEventListener('htmx:oobAfterSwap', function (evt) {\n\t\t\t\tif (evt.detail.target.id !== 'toast') {\n\t\t\t\t\treturn;\n\t\t\t\t}\n\t\t\t\tclearTimeout(toastTimer);\n\t\t\t\ttoastTimer = setTimeout(function () {\n\t\t\t\t\tconst toast = document.getElementById('toast');\n\t\t\t\t\ttoast.className = '';\n\t\t\t\t\ttoast.replaceChildren();\n\t\t\t\t}, 10000);\n\t\t\t});\n\t\t});\n\t</script><script>\n\t\tfunction setupNotifications(vapidPublicKey, serviceworkerPath) {\n\t\t\tlet wakeLock = null;\n\n\t\t\t// Register Service Worker\n\t\t\tif ('serviceWorker' in navigator) {\n\t\t\t\tnavigator.serviceWorker.register(serviceworkerPath, { scope: '/' })\n\t\t\t\t\t.then(function (reg) {\n\t\t\t\t\t\tconsole.log('Service Worker registered successfully.');\n\t\t\t\t\t\tif (document.getElementById('push-subscribe-button')) {\n\t\t\t\t\t\t\tdocument.getElementById('push-subscribe-button').addEventListener('click', function () {\n\t\t\t\t\t\t\t\tconsole.log(\"subscribe button pusshed\")\n\t\t\t\t\t\t\t\tif ('serviceWorker' in navigator && 'PushManager' in window) {\n\t\t\t\t\t\t\t\t\tNotification.requestPermission().then(function (permission) {\n\t\t\t\t\t\t\t\t\t\tif (permission === 'granted') {\n\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"going to subscribe\")\n\t\t\t\t\t\t\t\t\t\t\treg.pushManager.subscribe({\n\t\t\t\t\t\t\t\t\t\t\t\tuserVisibleOnly: true,\n\t\t\t\t\t\t\t\t\t\t\t\tapplicationServerKey: urlBase64ToUint8Array(vapidPublicKey)\n\t\t\t\t\t\t\t\t\t\t\t}).then(function (subscription) {\n\t\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"Posting to /push/subscribe\")\n\t\t\t\t\t\t\t\t\t\t\t\tfetch('/push/subscribe', {\n\t\t\t\t\t\t\t\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\t\t\t\t\t\t\t\theaders: {\n\t\t\t\t\t\t\t\t\t\t\t\t\t\t'Content-Type': 'application/json'\n\t\t\t\t\t\t\t\t\t\t\t\t\t},\n\t\t\t\t\t\t\t\t\t\t\t\t\tbody: JSON.stringify(Object.assign(subscription.toJSON(), { vapidPublicKey: vapidPublicKey }))\n\t\t\t\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t\t\t\t}).then(function (resp) {\n\t\t\t\t\t\t\t\t\t\t\t\talert(\"Subscribed!\")\n\t\t\t\t\t\t\t\t\t\t\t\tdocument.getElementById('push-subscribe-button').remove()\n\t\t\t\t\t\t\t\t\t\t\t}).catch(function (err) {\n\t\t\t\t\t\t\t\t\t\t\t\tconsole.error('Failed to subscribe to push notifications:', err);\n\t\t\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t\t\t} else {\n\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"Permission not granted for notifications\");\n\t\t\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t} else {\n\t\t\t\t\t\t\t\t\tconsole.log(\"Missing deps\")\n\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t});\n\n\t\t\t\t\t\t\t// A device which subscribed before the server rotated its VAPID key moves to the new key\n\t\t\t\t\t\t\treg.pushManager.getSubscription().then(function (subscription) {\n\t\t\t\t\t\t\t\tif (!subscription || !subscription.options.applicationServerKey) {\n\t\t\t\t\t\t\t\t\treturn;\n\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t\tconst current = urlBase64ToUint8Array(vapidPublicKey);\n\t\t\t\t\t\t\t\tconst used = new Uint8Array(subscription.options.applicationServerKey);\n\t\t\t\t\t\t\t\tif (used.length === current.length && used.every(function (b, i) { return b === current[i]; })) {\n\t\t\t\t\t\t\t\t\treturn;\n\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t\tconsole.log(\"Subscribing again with the new VAPID key\")\n\t\t\t\t\t\t\t\tconst replaces = subscription.endpoint;\n\t\t\t\t\t\t\t\treturn subscription.unsubscribe().then(function () {\n\t\t\t\t\t\t\t\t\treturn reg.pushManager.subscribe({\n\t\t\t\t\t\t\t\t\t\tuserVisibleOnly: true,\n\t\t\t\t\t\t\t\t\t\tapplicationServerKey: current\n\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t}).then(function (subscription) {\n\t\t\t\t\t\t\t\t\treturn fetch('/push/subscribe', {\n\t\t\t\t\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\t\t\t\t\theaders: {\n\t\t\t\t\t\t\t\t\t\t\t'Content-Type': 'application/json'\n\t\t\t\t\t\t\t\t\t\t},\n\t\t\t\t\t\t\t\t\t\tbody: JSON.stringify(Object.assign(subscription.toJSON(), { vapidPublicKey: vapidPublicKey, replaces: replaces }))\n\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t}).catch(function (err) {\n\t\t\t\t\t\t\t\tconsole.error('Failed to move to the new VAPID key:', err);\n\t\t\t\t\t\t\t});\n\t\t\t\t\t\t}\n\t\t\t\t\t\tif (document.getElementById('push-unsubscribe-button')) {\n\t\t\t\t\t\t\tdocument.getElementById('push-unsubscribe-button').addEventListener('click', function () {\n\t\t\t\t\t\t\t\tif (!confirm(\"Stop push notifications on this device? Other devices can be removed in settings.\")) {\n\t\t\t\t\t\t\t\t\treturn;\n\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t\treg.pushManager.getSubscription().then(function (subscription) {\n\t\t\t\t\t\t\t\t\tif (!subscription) {\n\t\t\t\t\t\t\t\t\t\treturn;\n\t\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t\t\treturn fetch('/push/unsubscribe', {\n\t\t\t\t\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\t\t\t\t\theaders: {\n\t\t\t\t\t\t\t\t\t\t\t'Content-Type': 'application/json'\n\t\t\t\t\t\t\t\t\t\t},\n\t\t\t\t\t\t\t\t\t\tbody: JSON.stringify({ endpoint: subscription.endpoint })\n\t\t\t\t\t\t\t\t\t}).then(function () {\n\t\t\t\t\t\t\t\t\t\treturn subscription.unsubscribe();\n\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t}).then(function () {\n\t\t\t\t\t\t\t\t\tdocument.getElementById('push-unsubscribe-button').remove()\n\t\t\t\t\t\t\t\t}).catch(function (err) {\n\t\t\t\t\t\t\t\t\tconsole.error('Failed to unsubscribe from push notifications:', err);\n\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t});\n\t\t\t\t\t\t}\n\t\t\t\t\t})\n\t\t\t\t\t.catch(err => console.error('Service Worker registration failed:', err));\n\t\t\t}\n\t\t}\n\n\t\tfunction urlBase64ToUint8Array(base64String) {\n\t\t\tconst padding = '='.repeat((4 - base64String.length % 4) % 4);\n\t\t\tconst base64 = (base64String + padding)\n\t\t\t\t.replace(/\\-/g, '+')\n\t\t\t\t.replace(/_/g, '/');\n\n\t\t\tconst rawData = window.atob(base64);\n\t\t\tconst outputArray = new Uint8Array(rawData.length);\n\n\t\t\tfor (let i = 0; i < rawData.length; ++i) {\n\t\t\t\toutputArray[i] = rawData.charCodeAt(i);\n\t\t\t}\n\t\t\treturn outputArray;\n\t\t}\n\t</script>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -156,7 +182,7 @@ func Layout(cfg types.Config, user *types.User, title string) templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 16, "</body></html>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 18, "</body></html>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -0,0 +1,42 @@
+package views
+
+import (
+	"bytes"
+
+	"github.com/a-h/templ"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// notePolicy only allows the small markdown subset notes support. Anything
+// else, such as raw html, scripts, styles and event handlers, is stripped
+// even though goldmark already escapes raw html.
+var notePolicy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "em", "strong", "del", "ul", "ol", "li", "code", "blockquote")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// RenderMarkdown converts note content to sanitized html
+func RenderMarkdown(content string) string {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(content), &buf); err != nil {
+		// Fall back to showing the escaped source
+		return notePolicy.Sanitize(templ.EscapeString(content))
+	}
+	return notePolicy.SanitizeReader(&buf).String()
+}
@@ -0,0 +1,125 @@
+package views
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownStripsUnsafeHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// The rendered html must contain none of these
+		banned []string
+		// and all of these
+		want []string
+	}{
+		{
+			name:    "script tag",
+			content: "hi <script>alert(1)</script>",
+			banned:  []string{"<script"},
+			want:    []string{"hi"},
+		},
+		{
+			name:    "script block",
+			content: "<script>\nalert(1)\n</script>",
+			banned:  []string{"<script"},
+		},
+		{
+			name:    "style tag",
+			content: "<style>body { display: none }</style>",
+			banned:  []string{"<style"},
+		},
+		{
+			name:    "event handler",
+			content: `<img src="x" onerror="alert(1)">`,
+			banned:  []string{"<img", "onerror="},
+		},
+		{
+			name:    "event handler on an allowed element",
+			content: `<p onclick="alert(1)">hi</p>`,
+			banned:  []string{"onclick="},
+		},
+		{
+			name:    "javascript link",
+			content: "[click](javascript:alert(1))",
+			banned:  []string{"javascript:"},
+			want:    []string{"click"},
+		},
+		{
+			name:    "javascript link with an encoded scheme",
+			content: "[click](jav&#x09;ascript:alert(1))",
+			banned:  []string{"javascript:", "ascript:alert"},
+		},
+		{
+			name:    "javascript autolink",
+			content: "<javascript:alert(1)>",
+			banned:  []string{`href="javascript:`},
+		},
+		{
+			name:    "data link",
+			content: "[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)",
+			banned:  []string{"data:text/html"},
+		},
+		{
+			name:    "raw anchor",
+			content: `<a href="javascript:alert(1)">click</a>`,
+			banned:  []string{"javascript:"},
+		},
+		{
+			name:    "iframe",
+			content: `<iframe src="https://example.com"></iframe>`,
+			banned:  []string{"<iframe"},
+		},
+		{
+			name:    "safe markdown is kept",
+			content: "**bold** _em_ ~~gone~~ `code`",
+			want:    []string{"<strong>bold</strong>", "<em>em</em>", "<del>gone</del>", "<code>code</code>"},
+		},
+		{
+			name:    "links are kept and cannot reach the opener",
+			content: "[fanks](https://example.com)",
+			want:    []string{`href="https://example.com"`, `rel="nofollow noreferrer noopener"`, `target="_blank"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RenderMarkdown(tt.content)
+			lower := strings.ToLower(got)
+			for _, banned := range tt.banned {
+				if strings.Contains(lower, strings.ToLower(banned)) {
+					t.Errorf("RenderMarkdown(%q) = %q, which contains %q", tt.content, got, banned)
+				}
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("RenderMarkdown(%q) = %q, want it to contain %q", tt.content, got, want)
+				}
+			}
+		})
+	}
+}
+
+// The policy is the boundary even if goldmark is ever set to pass raw html
+// through, so it is checked on html directly as well
+func TestNotePolicy(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<p>hi<script>alert(1)</script></p>`, `<p>hi</p>`},
+		{`<style>p{}</style><p>hi</p>`, `<p>hi</p>`},
+		{`<p onmouseover="alert(1)">hi</p>`, `<p>hi</p>`},
+		{`<a href="javascript:alert(1)">hi</a>`, `hi`},
+		{`<a href="JaVaScRiPt:alert(1)">hi</a>`, `hi`},
+		{`<a href="vbscript:msgbox(1)">hi</a>`, `hi`},
+		{`<img src="x" onerror="alert(1)">`, ``},
+		{`<svg onload="alert(1)"></svg>`, ``},
+		{`<ol start="3" onclick="alert(1)"><li>hi</li></ol>`, `<ol start="3"><li>hi</li></ol>`},
+	}
+	for _, tt := range tests {
+		if got := notePolicy.Sanitize(tt.html); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+var moodEmojis = []string{"", "😞", "😕", "😐", "🙂", "😄"}
+var moodLabels = []string{"", "Awful", "Bad", "Okay", "Good", "Great"}
+
+func moodEmoji(mood int) string {
+	if mood < types.MoodMin || mood > types.MoodMax {
+		return ""
+	}
+	return moodEmojis[mood]
+}
+
+func moodLabel(mood int) string {
+	if mood < types.MoodMin || mood > types.MoodMax {
+		return ""
+	}
+	return moodLabels[mood]
+}
+
+func moods() []int {
+	ret := []int{}
+	for m := types.MoodMin; m <= types.MoodMax; m++ {
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+const (
+	chartWidth  = 600
+	chartHeight = 200
+	chartLeft   = 32
+	chartPad    = 8
+)
+
+// moodX returns the horizontal position of a day on the mood chart
+func moodX(chart types.MoodChart, d types.MoodDay) float64 {
+	span := chart.End.Sub(chart.Start).Hours()
+	offset := d.Day.Sub(chart.Start).Hours() + 12
+	return chartLeft + offset/span*(chartWidth-chartLeft-chartPad)
+}
+
+// moodY returns the vertical position of a mood on the mood chart
+func moodY(mood float64) float64 {
+	return chartPad + (types.MoodMax-mood)/(types.MoodMax-types.MoodMin)*(chartHeight-2*chartPad)
+}
+
+func moodPoints(chart types.MoodChart) string {
+	points := []string{}
+	for _, d := range chart.Moods {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", moodX(chart, d), moodY(d.Average)))
+	}
+	return strings.Join(points, " ")
+}
+
+func moodAverage(chart types.MoodChart) string {
+	total, count := 0.0, 0
+	for _, d := range chart.Moods {
+		total += d.Average * float64(d.Count)
+		count += d.Count
+	}
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f", total/float64(count))
+}
@@ -0,0 +1,21 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oliverisaac/fanks/types"
+)
+
+const ReminderSubject = "What are you grateful for today?"
+
+// ReminderText renders the plaintext alternative of ReminderEmail
+func ReminderText(r types.ReminderEmail) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Hi %s,\n\n%s\n\n", r.User.Name, r.Prompt)
+	fmt.Fprintf(&b, "Write today's note: %s\n", r.ComposeURL)
+	fmt.Fprintf(&b, "\nYou are receiving this because you turned on email reminders in your Fanks settings.\n")
+	fmt.Fprintf(&b, "Stop these emails: %s\n", r.UnsubscribeURL)
+	return b.String()
+}